@@ -2,22 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/movie_service/internal/services"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/apikey"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/metrics"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/mtls"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/ratelimit"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 )
 
+// scopeMoviesRead is the API key scope required by every movie route.
+const scopeMoviesRead = "movies:read"
+
+// scopeMetricsRead is the API key scope required to scrape metrics.
+const scopeMetricsRead = "metrics:read"
+
 var log = logrus.New()
 
 func init() {
 	log.SetFormatter(&logrus.JSONFormatter{})
 	log.SetOutput(os.Stdout)
+	log.AddHook(tracing.NewLogHook())
 
 	if os.Getenv("LOG_LEVEL") == "debug" {
 		log.SetLevel(logrus.DebugLevel)
@@ -26,51 +45,51 @@ func init() {
 	}
 }
 
-func apiKeyAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		apiKey := getEnvWithDefault("API_KEY", "")
-		if apiKey == "" {
-			log.Warn("API_KEY environment variable not set")
-			c.Next()
-			return
-		}
-		requestApiKey := c.GetHeader("x-api-key")
-		if requestApiKey == "" {
-			log.WithField("client_ip", c.ClientIP()).Warn("Missing API key in request")
-			c.JSON(http.StatusForbidden, gin.H{
-				"status":  "FORBIDDEN",
-				"message": "API key is required",
-			})
-			c.Abort()
-			return
-		}
-		if requestApiKey != apiKey {
-			log.WithField("client_ip", c.ClientIP()).Warn("Invalid API key provided")
-			c.JSON(http.StatusForbidden, gin.H{
-				"status":  "FORBIDDEN",
-				"message": "Invalid API key",
-			})
-			c.Abort()
-			return
-		}
-		c.Next()
+// rateLimitKey gives each API key its own rate limit bucket. Requests on an
+// unprotected service are limited per client IP instead.
+func rateLimitKey(c *gin.Context) string {
+	if name := apikey.Name(c); name != "" {
+		return "key:" + name
 	}
+	return "ip:" + c.ClientIP()
 }
 
 func main() {
 	port := getEnvWithDefault("PORT", "4567")
-	apiKey := getEnvWithDefault("API_KEY", "")
 	dataPath := getEnvWithDefault("MOVIES_DATA_PATH", "data/movies.json")
 
+	apiKeys, err := newAPIKeyRegistry()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load API keys")
+	}
+	signatures, err := newSignatureVerifier(apiKeys)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid request signing configuration")
+	}
+	certs, err := newTLSReloader()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load TLS certificates")
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:    "movie-service",
+		ServiceVersion: getEnvWithDefault("APP_VERSION", "dev"),
+		Exporter:       getEnvWithDefault("OTEL_TRACES_EXPORTER", tracing.ExporterNone),
+	})
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
+
 	logFields := logrus.Fields{
 		"port": port,
 	}
 
-	if apiKey != "" {
+	if apiKeys != nil {
 		logFields["api_key_protected"] = true
+		logFields["api_keys"] = apiKeys.Len()
+		logFields["signature_required"] = signatures.Required()
 	} else {
 		logFields["api_key_protected"] = false
-		log.Warn("No API_KEY set. API endpoints are unprotected!")
+		log.Warn("No API_KEY or API_KEYS_FILE set. API endpoints are unprotected!")
 	}
 
 	log.WithFields(logFields).Info("Starting movie service")
@@ -80,124 +99,57 @@ func main() {
 		log.WithError(err).Fatal("Failed to initialize movie service")
 	}
 
-	router := gin.Default()
-	router.Use(gin.Recovery())
-
-	router.GET("/mshealth", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"version":   getEnvWithDefault("APP_VERSION", "dev"),
-			"timestamp": time.Now().Unix(),
-		})
-	})
-
-	router.GET("/movie-service/mshealth", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"version":   getEnvWithDefault("APP_VERSION", "dev"),
-			"timestamp": time.Now().Unix(),
-		})
-	})
-
-	protected := router.Group("/")
-	protected.Use(apiKeyAuthMiddleware())
-	{
-		protected.GET("/movies", func(c *gin.Context) {
-			requestLogger := log.WithFields(logrus.Fields{
-				"client_ip": c.ClientIP(),
-				"method":    c.Request.Method,
-				"path":      c.Request.URL.Path,
-			})
-			requestLogger.Info("Received request for all movies")
-
-			c.JSON(http.StatusOK, movieService.GetAllMovies())
-		})
-
-		protected.GET("/movies/:id", func(c *gin.Context) {
-			id := c.Param("id")
-
-			requestLogger := log.WithFields(logrus.Fields{
-				"client_ip": c.ClientIP(),
-				"method":    c.Request.Method,
-				"path":      c.Request.URL.Path,
-				"movie_id":  id,
-			})
-			requestLogger.Info("Received request for specific movie")
-
-			movie, found := movieService.GetMovieByID(id)
-			if !found {
-				requestLogger.Warn("Movie not found")
-				c.JSON(http.StatusNotFound, gin.H{
-					"status": "NOT_FOUND",
-					"error":  "Movie with requested ID not found",
-				})
-				return
-			}
-
-			c.JSON(http.StatusOK, movie)
-		})
+	clientLimiter, err := newRateLimiter("clients")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid rate limit")
+	}
+	movieLimiter, err := newRateLimiter("movies")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid rate limit")
 	}
 
-	protectedProd := router.Group("/movie-service")
-	protectedProd.Use(apiKeyAuthMiddleware())
-	{
-		protectedProd.GET("/movies", func(c *gin.Context) {
-			requestLogger := log.WithFields(logrus.Fields{
-				"client_ip": c.ClientIP(),
-				"method":    c.Request.Method,
-				"path":      c.Request.URL.Path,
-			})
-			requestLogger.Info("Received request for all movies")
-
-			c.JSON(http.StatusOK, movieService.GetAllMovies())
-		})
-
-		protectedProd.GET("/movies/:id", func(c *gin.Context) {
-			id := c.Param("id")
-
-			requestLogger := log.WithFields(logrus.Fields{
-				"client_ip": c.ClientIP(),
-				"method":    c.Request.Method,
-				"path":      c.Request.URL.Path,
-				"movie_id":  id,
-			})
-			requestLogger.Info("Received request for specific movie")
-
-			movie, found := movieService.GetMovieByID(id)
-			if !found {
-				requestLogger.Warn("Movie not found")
-				c.JSON(http.StatusNotFound, gin.H{
-					"status": "NOT_FOUND",
-					"error":  "Movie with requested ID not found",
-				})
-				return
-			}
-
-			c.JSON(http.StatusOK, movie)
-		})
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if certs != nil {
+		reloadInterval, err := time.ParseDuration(getEnvWithDefault("TLS_RELOAD_INTERVAL", "30s"))
+		if err != nil || reloadInterval <= 0 {
+			log.Fatal("TLS_RELOAD_INTERVAL must be a positive duration")
+		}
+		go certs.Watch(backgroundCtx, reloadInterval, log)
+	}
+	if os.Getenv("API_KEYS_FILE") != "" {
+		reloadInterval, err := time.ParseDuration(getEnvWithDefault("API_KEYS_RELOAD_INTERVAL", "30s"))
+		if err != nil || reloadInterval <= 0 {
+			log.Fatal("API_KEYS_RELOAD_INTERVAL must be a positive duration")
+		}
+		go apiKeys.Watch(backgroundCtx, reloadInterval, log)
 	}
 
-	router.NoRoute(func(c *gin.Context) {
-		log.WithFields(logrus.Fields{
-			"client_ip": c.ClientIP(),
-			"method":    c.Request.Method,
-			"path":      c.Request.URL.Path,
-		}).Warn("Route not found")
-
-		c.JSON(http.StatusNotFound, gin.H{
-			"status": "NOT_FOUND",
-			"error":  "There is nothing to do here! 404!",
-		})
-	})
+	router := newRouter(movieService, apiKeys, signatures, clientLimiter, movieLimiter)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: router,
 	}
+	if certs != nil {
+		srv.TLSConfig = certs.TLSConfig()
+	}
 
 	go func() {
-		log.WithField("port", port).Info("Server is running")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithFields(logrus.Fields{
+			"port":       port,
+			"tls":        certs != nil,
+			"mutual_tls": certs != nil && certs.MutualTLS(),
+		}).Info("Server is running")
+
+		var err error
+		if certs != nil {
+			// The certificate comes from srv.TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -215,9 +167,209 @@ func main() {
 		log.WithError(err).Fatal("Server forced to shutdown")
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		log.WithError(err).Error("Failed to flush traces")
+	}
+
 	log.Info("Server exited gracefully")
 }
 
+// newRouter builds the service's routes. The limiters are shared by both
+// route prefixes so a caller cannot double its allowance, and the client
+// limit runs ahead of authentication so failed API key and signature checks
+// are throttled too. A nil apiKeys disables authentication.
+func newRouter(movieService *services.MovieService, apiKeys *apikey.Registry, signatures *apikey.Verifier, clientLimiter, movieLimiter *ratelimit.Limiter) *gin.Engine {
+	clientLimit := ratelimit.Middleware(clientLimiter, ratelimit.ClientIP, log)
+	movieLimit := ratelimit.Middleware(movieLimiter, rateLimitKey, log)
+	movieAuth := apikey.Middleware(apiKeys, signatures, log)
+	moviesRead := apikey.RequireScope(scopeMoviesRead, log)
+
+	metricsRegistry := metrics.NewRegistry()
+	promauto.With(metricsRegistry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "movie_catalog_size",
+		Help: "Movies in the catalog.",
+	}, func() float64 {
+		return float64(len(movieService.GetAllMovies()))
+	})
+	moviesNotFound := promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "movie_not_found_total",
+		Help: "Movie lookups by ID that found no movie.",
+	})
+
+	router := gin.New()
+	// Metrics and tracing sit outside Recovery so panics are counted as 500s,
+	// and problem.Recovery is the only recovery so they get a problem body
+	router.Use(gin.Logger(), metrics.GinMiddleware(metricsRegistry), tracing.Middleware(), problem.Recovery())
+
+	// Metrics reveal traffic and error rates, so scraping them takes a key
+	// with the metrics scope and is limited per client IP
+	metricsRoute := []gin.HandlerFunc{clientLimit, movieAuth, apikey.RequireScope(scopeMetricsRead, log), metrics.GinHandler(metricsRegistry)}
+	router.GET("/metrics", metricsRoute...)
+	router.GET("/movie-service/metrics", metricsRoute...)
+
+	router.GET("/mshealth", health)
+	router.GET("/movie-service/mshealth", health)
+
+	registerMovieRoutes(router.Group("/", clientLimit, movieAuth, movieLimit, moviesRead), movieService, moviesNotFound)
+	registerMovieRoutes(router.Group("/movie-service", clientLimit, movieAuth, movieLimit, moviesRead), movieService, moviesNotFound)
+
+	router.NoRoute(func(c *gin.Context) {
+		log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"client_ip": c.ClientIP(),
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+		}).Warn("Route not found")
+
+		problem.NotFound(c, "No route matches "+c.Request.Method+" "+c.Request.URL.Path)
+	})
+
+	return router
+}
+
+func health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "healthy",
+		"version":   getEnvWithDefault("APP_VERSION", "dev"),
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// registerMovieRoutes serves the catalog under group. moviesNotFound counts
+// lookups of unknown IDs.
+func registerMovieRoutes(group *gin.RouterGroup, movieService *services.MovieService, moviesNotFound prometheus.Counter) {
+	group.GET("/movies", func(c *gin.Context) {
+		requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"request_id": tracing.RequestID(c),
+			"client_ip":  c.ClientIP(),
+			"key_name":   apikey.Name(c),
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+		})
+		requestLogger.Info("Received request for all movies")
+
+		c.JSON(http.StatusOK, movieService.GetAllMovies())
+	})
+
+	group.GET("/movies/:id", func(c *gin.Context) {
+		id := c.Param("id")
+
+		requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"request_id": tracing.RequestID(c),
+			"client_ip":  c.ClientIP(),
+			"key_name":   apikey.Name(c),
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"movie_id":   id,
+		})
+		requestLogger.Info("Received request for specific movie")
+
+		movie, found := movieService.GetMovieByID(id)
+		if !found {
+			requestLogger.Warn("Movie not found")
+			moviesNotFound.Inc()
+			problem.NotFound(c, "Movie with requested ID not found")
+			return
+		}
+
+		c.JSON(http.StatusOK, movie)
+	})
+}
+
+// newAPIKeyRegistry loads API keys from API_KEYS_FILE. A single API_KEY is
+// still accepted as a key named "default" with every scope. It returns nil
+// when neither is set.
+func newAPIKeyRegistry() (*apikey.Registry, error) {
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		if os.Getenv("API_KEY") != "" {
+			log.Warn("API_KEY is ignored because API_KEYS_FILE is set")
+		}
+		return apikey.LoadFile(path)
+	}
+	if key := os.Getenv("API_KEY"); key != "" {
+		return apikey.NewRegistry(apikey.Key{
+			Name:   "default",
+			Hash:   apikey.Hash(key),
+			Scopes: []string{apikey.ScopeAll},
+		})
+	}
+	return nil, nil
+}
+
+// newSignatureVerifier configures signed request checks for registry. It
+// returns nil when authentication is disabled.
+func newSignatureVerifier(registry *apikey.Registry) (*apikey.Verifier, error) {
+	if registry == nil {
+		return nil, nil
+	}
+
+	cfg := apikey.DefaultSignatureConfig()
+	var err error
+
+	if value := os.Getenv("API_SIGNATURE_MAX_SKEW"); value != "" {
+		cfg.MaxSkew, err = time.ParseDuration(value)
+		if err != nil || cfg.MaxSkew <= 0 {
+			return nil, errors.New("API_SIGNATURE_MAX_SKEW must be a positive duration")
+		}
+	}
+	if value := os.Getenv("API_SIGNATURE_MAX_BODY_BYTES"); value != "" {
+		cfg.MaxBodyBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || cfg.MaxBodyBytes <= 0 {
+			return nil, errors.New("API_SIGNATURE_MAX_BODY_BYTES must be a positive integer")
+		}
+	}
+	cfg.Required, err = strconv.ParseBool(getEnvWithDefault("API_REQUIRE_SIGNATURE", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_REQUIRE_SIGNATURE: %w", err)
+	}
+
+	return apikey.NewVerifier(registry, cfg), nil
+}
+
+// newTLSReloader configures TLS from TLS_* variables. It returns nil when no
+// certificate is set, in which case the server speaks plain HTTP.
+func newTLSReloader() (*mtls.Reloader, error) {
+	cfg := mtls.DefaultConfig()
+	cfg.CertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.KeyFile = os.Getenv("TLS_KEY_FILE")
+	cfg.ClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	cfg.ClientAuth = getEnvWithDefault("TLS_CLIENT_AUTH", cfg.ClientAuth)
+
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		if cfg.ClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	if value := os.Getenv("TLS_MIN_VERSION"); value != "" {
+		version, err := mtls.ParseVersion(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS_MIN_VERSION: %w", err)
+		}
+		cfg.MinVersion = version
+	}
+
+	return mtls.NewReloader(cfg)
+}
+
+// newRateLimiter configures the limit for a route group from
+// RATE_LIMIT_<GROUP>, falling back to RATE_LIMIT.
+func newRateLimiter(group string) (*ratelimit.Limiter, error) {
+	key := "RATE_LIMIT_" + strings.ToUpper(group)
+	value := getEnvWithDefault(key, getEnvWithDefault("RATE_LIMIT", "600/m"))
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"group": group,
+		"limit": limit.String(),
+	}).Info("Configured rate limit")
+
+	return ratelimit.New(limit), nil
+}
+
 func getEnvWithDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/movie_service/internal/services"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/apikey"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/ratelimit"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Raw API keys of the test router. reader may only read movies and scraper
+// may only read metrics.
+const (
+	readerKey  = "reader-secret"
+	scraperKey = "scraper-secret"
+)
+
+// knownMovieID is the first movie of data/movies.json.
+const knownMovieID = "tt6644200"
+
+// newTestRouter serves the real catalog with movieLimit requests per API key
+// and a generous limit per client IP.
+func newTestRouter(t *testing.T, movieLimit string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+
+	movieService, err := services.NewMovieService("../data/movies.json")
+	require.NoError(t, err)
+	registry, err := apikey.NewRegistry(
+		apikey.Key{Name: "reader", Hash: apikey.Hash(readerKey), Scopes: []string{scopeMoviesRead}},
+		apikey.Key{Name: "scraper", Hash: apikey.Hash(scraperKey), Scopes: []string{scopeMetricsRead}},
+	)
+	require.NoError(t, err)
+
+	clientLimit, err := ratelimit.ParseLimit("1000/m")
+	require.NoError(t, err)
+	perKeyLimit, err := ratelimit.ParseLimit(movieLimit)
+	require.NoError(t, err)
+
+	return newRouter(movieService, registry, nil, ratelimit.New(clientLimit), ratelimit.New(perKeyLimit))
+}
+
+func serve(router *gin.Engine, path, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if key != "" {
+		req.Header.Set(apikey.Header, key)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// assertProblem checks that recorder holds a problem+json body of typ and
+// status for path, and returns the body.
+func assertProblem(t *testing.T, recorder *httptest.ResponseRecorder, status int, typ problem.Type, path string) map[string]any {
+	t.Helper()
+	require.Equal(t, status, recorder.Code, recorder.Body.String())
+	assert.Equal(t, problem.ContentType, recorder.Header().Get("Content-Type"))
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body), recorder.Body.String())
+	assert.Equal(t, string(typ), body["type"])
+	assert.Equal(t, float64(status), body["status"])
+	assert.Equal(t, path, body["instance"])
+	assert.Equal(t, recorder.Header().Get(tracing.RequestIDHeader), body["request_id"])
+	return body
+}
+
+// notFoundCount scrapes movie_not_found_total from the metrics endpoint.
+func notFoundCount(t *testing.T, router *gin.Engine) string {
+	t.Helper()
+	recorder := serve(router, "/metrics", scraperKey)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, "movie_not_found_total "); ok {
+			return value
+		}
+	}
+	t.Fatal("movie_not_found_total is not exported")
+	return ""
+}
+
+func TestScopesAreEnforced(t *testing.T) {
+	router := newTestRouter(t, "100/m")
+
+	for _, path := range []string{"/movies", "/movies/" + knownMovieID, "/movie-service/movies"} {
+		assert.Equal(t, http.StatusOK, serve(router, path, readerKey).Code, path)
+		assertProblem(t, serve(router, path, scraperKey), http.StatusForbidden, problem.TypeForbidden, path)
+	}
+
+	// Metrics need their own scope
+	assertProblem(t, serve(router, "/metrics", readerKey), http.StatusForbidden, problem.TypeForbidden, "/metrics")
+	assert.Equal(t, http.StatusOK, serve(router, "/movie-service/metrics", scraperKey).Code)
+
+	// The health checks need no key
+	assert.Equal(t, http.StatusOK, serve(router, "/mshealth", "").Code)
+}
+
+func TestUnknownMovieIsNotFound(t *testing.T) {
+	router := newTestRouter(t, "100/m")
+	assert.Equal(t, "0", notFoundCount(t, router))
+
+	for _, path := range []string{"/movies/tt0000000", "/movie-service/movies/tt0000000"} {
+		body := assertProblem(t, serve(router, path, readerKey), http.StatusNotFound, problem.TypeNotFound, path)
+		assert.Equal(t, "Movie with requested ID not found", body["detail"])
+	}
+	assert.Equal(t, "2", notFoundCount(t, router))
+
+	// Known movies and unknown routes are not counted
+	require.Equal(t, http.StatusOK, serve(router, "/movies/"+knownMovieID, readerKey).Code)
+	assertProblem(t, serve(router, "/films", readerKey), http.StatusNotFound, problem.TypeNotFound, "/films")
+	assert.Equal(t, "2", notFoundCount(t, router))
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	router := newTestRouter(t, "2/m")
+
+	first := serve(router, "/movies", readerKey)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, first.Header().Get("X-RateLimit-Reset"))
+	assert.Empty(t, first.Header().Get("Retry-After"))
+
+	// Both prefixes draw on the same allowance
+	require.Equal(t, http.StatusOK, serve(router, "/movie-service/movies", readerKey).Code)
+
+	limited := serve(router, "/movies", readerKey)
+	assertProblem(t, limited, http.StatusTooManyRequests, problem.TypeRateLimited, "/movies")
+	assert.Equal(t, "2", limited.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", limited.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, limited.Header().Get("Retry-After"))
+}
@@ -0,0 +1,39 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReplacesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "store.json")
+
+	require.NoError(t, Write(path, []byte("first"), 0o755))
+	require.NoError(t, Write(path, []byte("second"), 0o755))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFailureKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	require.NoError(t, Write(path, []byte("kept"), 0o755))
+
+	// A directory in the way makes the write fail before anything is renamed
+	assert.Error(t, Write(filepath.Join(path, "child"), []byte("lost"), 0o755))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "kept", string(data))
+}
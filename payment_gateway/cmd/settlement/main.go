@@ -0,0 +1,127 @@
+// Command settlement generates a settlement report for a date range straight
+// from the gateway's file transaction store, for days that have not been
+// closed yet or when the gateway is not running. Batch IDs match the ones the
+// gateway's /settlements endpoints use.
+//
+//	settlement -from 2025-03-01 -to 2025-03-31 -format csv -out march.csv
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/settlement"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "settlement:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("settlement", flag.ContinueOnError)
+	from := flags.String("from", "", "first day of the report, as YYYY-MM-DD (default yesterday)")
+	to := flags.String("to", "", "last day of the report, as YYYY-MM-DD (default from)")
+	format := flags.String("format", "csv", `report format, "csv" or "json"`)
+	levelValue := flags.String("level", string(settlement.LevelBatch), `"batch" for a row per batch or "entry" for a row per capture and refund`)
+	merchant := flags.String("merchant", "", "only report this merchant's batches")
+	store := flags.String("store", getEnvWithDefault("TRANSACTION_STORE_PATH", "data/transactions.json"), "path of the file transaction store")
+	timezone := flags.String("timezone", getEnvWithDefault("SETTLEMENT_TIMEZONE", "UTC"), "time zone settlement days are counted in")
+	out := flags.String("out", "", "file to write the report to (default standard output)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return fmt.Errorf("invalid -timezone: %w", err)
+	}
+	if *from == "" {
+		year, month, day := time.Now().In(loc).Date()
+		*from = time.Date(year, month, day-1, 0, 0, 0, 0, loc).Format(settlement.DateLayout)
+	}
+	if *to == "" {
+		*to = *from
+	}
+	if *from, err = settlement.ParseDate(*from); err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if *to, err = settlement.ParseDate(*to); err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if *from > *to {
+		return errors.New("-to must not be before -from")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf(`-format must be "csv" or "json", got %q`, *format)
+	}
+	level, err := settlement.ParseLevel(*levelValue)
+	if err != nil {
+		return fmt.Errorf("invalid -level: %w", err)
+	}
+
+	// A missing store would silently produce an empty report
+	if _, err := os.Stat(*store); err != nil {
+		return fmt.Errorf("cannot open transaction store: %w", err)
+	}
+	transactions, err := repository.NewFileRepository(*store)
+	if err != nil {
+		return err
+	}
+	all, err := transactions.List()
+	if err != nil {
+		return err
+	}
+
+	batches, err := settlement.Build(all, *from, *to, loc)
+	if err != nil {
+		return err
+	}
+	if *merchant != "" {
+		var filtered []settlement.Batch
+		for _, batch := range batches {
+			if batch.Merchant == *merchant {
+				filtered = append(filtered, batch)
+			}
+		}
+		batches = filtered
+	}
+
+	report, err := settlement.NewReport(*from, *to, loc, level, batches, time.Now())
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "csv" {
+		return report.WriteCSV(w)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func getEnvWithDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package currency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// minorUnits maps ISO 4217 codes to the number of digits after the decimal
+// point used by the currency.
+var minorUnits = map[string]int{
+	"AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0,
+	"CNY": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "IDR": 2, "INR": 2,
+	"IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "LKR": 2,
+	"LYD": 3, "MXN": 2, "MYR": 2, "NOK": 2, "NPR": 2, "NZD": 2, "OMR": 3,
+	"PHP": 2, "PKR": 2, "QAR": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2,
+	"TND": 3, "TWD": 2, "UGX": 0, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// MinorUnits returns how many fractional digits amounts in code may carry.
+func MinorUnits(code string) (int, bool) {
+	units, ok := minorUnits[code]
+	return units, ok
+}
+
+func IsKnown(code string) bool {
+	_, ok := minorUnits[code]
+	return ok
+}
+
+// Known returns every currency code this package knows, sorted.
+func Known() []string {
+	codes := make([]string, 0, len(minorUnits))
+	for code := range minorUnits {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ParseList parses a comma separated list of currency codes such as
+// "USD,EUR,INR".
+func ParseList(value string) ([]string, error) {
+	var codes []string
+	for _, part := range strings.Split(value, ",") {
+		code := Normalize(part)
+		if code == "" {
+			continue
+		}
+		if !IsKnown(code) {
+			return nil, fmt.Errorf("unknown currency %q", code)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no currencies configured")
+	}
+	return codes, nil
+}
@@ -0,0 +1,40 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		code      string
+		wantUnits int
+		wantOK    bool
+	}{
+		{code: "USD", wantUnits: 2, wantOK: true},
+		{code: "JPY", wantUnits: 0, wantOK: true},
+		{code: "KWD", wantUnits: 3, wantOK: true},
+		{code: "XXX", wantOK: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.code, func(t *testing.T) {
+			units, ok := MinorUnits(tc.code)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantUnits, units)
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	codes, err := ParseList("usd, EUR ,inr")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"USD", "EUR", "INR"}, codes)
+
+	_, err = ParseList("USD,ABC")
+	assert.Error(t, err)
+
+	_, err = ParseList(" , ")
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+const MaxKeyLength = 255
+
+var (
+	ErrKeyReused  = errors.New("idempotency key already used with a different request body")
+	ErrInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+type Record struct {
+	RequestHash string
+	StatusCode  int
+	Response    types.PaymentResponse
+	CreatedAt   time.Time
+	completed   bool
+}
+
+// Store keeps the first response seen for an idempotency key so that client
+// retries are answered without charging the card again. Keys are scoped per
+// caller, so two API keys can safely use the same idempotency key.
+type Store struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	records map[string]*Record
+	now     func() time.Time
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		records: make(map[string]*Record),
+		now:     time.Now,
+	}
+}
+
+func HashRequest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin reserves key for the given request hash. When a completed record
+// already exists for the same hash it is returned with replay set to true and
+// the caller should answer with the stored response instead of processing.
+func (s *Store) Begin(scope, key, requestHash string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired()
+
+	id := recordID(scope, key)
+	if existing, ok := s.records[id]; ok {
+		if existing.RequestHash != requestHash {
+			return Record{}, false, ErrKeyReused
+		}
+		if !existing.completed {
+			return Record{}, false, ErrInProgress
+		}
+		return *existing, true, nil
+	}
+
+	s.records[id] = &Record{
+		RequestHash: requestHash,
+		CreatedAt:   s.now(),
+	}
+	return Record{}, false, nil
+}
+
+func (s *Store) Complete(scope, key string, statusCode int, response types.PaymentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[recordID(scope, key)]; ok {
+		record.StatusCode = statusCode
+		record.Response = response
+		record.completed = true
+	}
+}
+
+// Release drops a reservation made by Begin without storing a response, so
+// that a request rejected before processing can be corrected and retried.
+func (s *Store) Release(scope, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := recordID(scope, key)
+	if record, ok := s.records[id]; ok && !record.completed {
+		delete(s.records, id)
+	}
+}
+
+func (s *Store) purgeExpired() {
+	cutoff := s.now().Add(-s.ttl)
+	for id, record := range s.records {
+		if record.CreatedAt.Before(cutoff) {
+			delete(s.records, id)
+		}
+	}
+}
+
+func recordID(scope, key string) string {
+	return scope + "\x00" + key
+}
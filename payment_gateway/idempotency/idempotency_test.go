@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeginAndReplay(t *testing.T) {
+	store := NewStore(time.Hour)
+	hash := HashRequest([]byte(`{"amount":"10"}`))
+
+	_, replay, err := store.Begin("key-a", "idem-1", hash)
+	assert.NoError(t, err)
+	assert.False(t, replay)
+
+	response := types.PaymentResponse{Status: "SUCCESS", TransactionID: "txn-1"}
+	store.Complete("key-a", "idem-1", http.StatusOK, response)
+
+	record, replay, err := store.Begin("key-a", "idem-1", hash)
+	assert.NoError(t, err)
+	assert.True(t, replay)
+	assert.Equal(t, http.StatusOK, record.StatusCode)
+	assert.Equal(t, "txn-1", record.Response.TransactionID)
+}
+
+func TestKeyReusedWithDifferentBody(t *testing.T) {
+	store := NewStore(time.Hour)
+
+	_, _, err := store.Begin("key-a", "idem-1", HashRequest([]byte("first")))
+	assert.NoError(t, err)
+	store.Complete("key-a", "idem-1", http.StatusOK, types.PaymentResponse{})
+
+	_, _, err = store.Begin("key-a", "idem-1", HashRequest([]byte("second")))
+	assert.ErrorIs(t, err, ErrKeyReused)
+}
+
+func TestInProgress(t *testing.T) {
+	store := NewStore(time.Hour)
+	hash := HashRequest([]byte("body"))
+
+	_, _, err := store.Begin("key-a", "idem-1", hash)
+	assert.NoError(t, err)
+
+	_, _, err = store.Begin("key-a", "idem-1", hash)
+	assert.ErrorIs(t, err, ErrInProgress)
+}
+
+func TestKeysAreScopedPerCaller(t *testing.T) {
+	store := NewStore(time.Hour)
+
+	_, _, err := store.Begin("key-a", "idem-1", HashRequest([]byte("first")))
+	assert.NoError(t, err)
+
+	_, replay, err := store.Begin("key-b", "idem-1", HashRequest([]byte("second")))
+	assert.NoError(t, err)
+	assert.False(t, replay)
+}
+
+func TestReleaseAllowsRetry(t *testing.T) {
+	store := NewStore(time.Hour)
+
+	_, _, err := store.Begin("key-a", "idem-1", HashRequest([]byte("first")))
+	assert.NoError(t, err)
+	store.Release("key-a", "idem-1")
+
+	_, replay, err := store.Begin("key-a", "idem-1", HashRequest([]byte("second")))
+	assert.NoError(t, err)
+	assert.False(t, replay)
+}
+
+func TestExpiredRecordsArePurged(t *testing.T) {
+	store := NewStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	_, _, err := store.Begin("key-a", "idem-1", HashRequest([]byte("first")))
+	assert.NoError(t, err)
+	store.Complete("key-a", "idem-1", http.StatusOK, types.PaymentResponse{})
+
+	now = now.Add(2 * time.Minute)
+	_, replay, err := store.Begin("key-a", "idem-1", HashRequest([]byte("second")))
+	assert.NoError(t, err)
+	assert.False(t, replay)
+}
@@ -0,0 +1,77 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
+)
+
+// FeeSchedule is the fee the gateway keeps from each capture: Rate of the
+// captured amount plus the Fixed fee of the capture's currency. Fixed fees
+// are per currency, since one number means very different sums in USD and
+// JPY; a currency without one is charged the rate alone.
+type FeeSchedule struct {
+	Rate  decimal.Decimal
+	Fixed map[string]decimal.Decimal
+}
+
+// ParseFeeSchedule accepts a percentage and fixed amounts joined by plus
+// signs, such as "2.9%+USD:0.30+JPY:30", "1.5%" or "0.25". An amount without
+// a currency is a fixed fee in defaultCurrency. Every fixed fee must fit its
+// currency's minor units. "0" charges no fees.
+func ParseFeeSchedule(value, defaultCurrency string) (FeeSchedule, error) {
+	fees := FeeSchedule{Fixed: make(map[string]decimal.Decimal)}
+	for _, part := range strings.Split(value, "+") {
+		part = strings.TrimSpace(part)
+		percent, isPercent := strings.CutSuffix(part, "%")
+		code, number := defaultCurrency, percent
+		if before, after, found := strings.Cut(percent, ":"); found && !isPercent {
+			code, number = currency.Normalize(before), after
+		}
+
+		amount, err := decimal.Parse(strings.TrimSpace(number))
+		if err != nil || amount.IsNeg() {
+			return FeeSchedule{}, fmt.Errorf("invalid fee %q", part)
+		}
+		if !isPercent {
+			minorUnits, ok := currency.MinorUnits(code)
+			if !ok {
+				return FeeSchedule{}, fmt.Errorf("fee %q has unknown currency %q", part, code)
+			}
+			if amount.MinScale() > minorUnits {
+				return FeeSchedule{}, fmt.Errorf("fee %q has more than %d decimal places for %s", part, minorUnits, code)
+			}
+			if _, ok := fees.Fixed[code]; ok {
+				return FeeSchedule{}, fmt.Errorf("more than one fixed fee for %s", code)
+			}
+			fees.Fixed[code] = amount.Trim(minorUnits).Pad(minorUnits)
+			continue
+		}
+		if amount.Cmp(decimal.Hundred) > 0 {
+			return FeeSchedule{}, fmt.Errorf("fee rate %q is over 100%%", part)
+		}
+		if fees.Rate, err = amount.Quo(decimal.Hundred); err != nil {
+			return FeeSchedule{}, fmt.Errorf("invalid fee %q: %w", part, err)
+		}
+	}
+	return fees, nil
+}
+
+// Fee returns the fee on a capture of amount, rounded to the currency's
+// minor units. It never exceeds amount, so the merchant is never owed a
+// negative sum.
+func (f FeeSchedule) Fee(amount decimal.Decimal, code string) (decimal.Decimal, error) {
+	fee, err := amount.Mul(f.Rate)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	if fee, err = fee.Add(f.Fixed[code]); err != nil {
+		return decimal.Decimal{}, err
+	}
+	if scale, ok := currency.MinorUnits(code); ok {
+		fee = fee.Round(scale)
+	}
+	return fee.Min(amount), nil
+}
@@ -0,0 +1,184 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+// Entry kinds, one for each transition that moves money. Declined payments
+// and 3-D Secure challenges move none and post nothing.
+const (
+	KindAuthorize = "authorize"
+	KindCapture   = "capture"
+	KindSale      = "sale"
+	KindVoid      = "void"
+	KindExpire    = "expire"
+	KindRefund    = "refund"
+)
+
+// authorizedAt reports whether txn went through an authorization. Records
+// written before the authorization time was kept fall back to their status
+// and creation time.
+func authorizedAt(txn types.Transaction) (time.Time, bool) {
+	if txn.AuthorizedAt != nil {
+		return *txn.AuthorizedAt, true
+	}
+	switch txn.Status {
+	case types.StatusAuthorized, types.StatusCaptured, types.StatusVoided, types.StatusExpired:
+		return txn.CreatedAt, true
+	}
+	return time.Time{}, false
+}
+
+// Journal returns every entry txn's history calls for, in the order the
+// transitions happened. Captures keep the fee stored on txn; records written
+// before the fee was stored are charged by fees.
+func Journal(txn types.Transaction, fees FeeSchedule) ([]Entry, error) {
+	account := func(typ AccountType) Account {
+		return Account{Type: typ, Merchant: txn.Owner, Currency: txn.Currency}
+	}
+	entry := func(id, kind string, at time.Time, lines ...Line) Entry {
+		// Lines of zero, such as a fee-free capture, are left out
+		var kept []Line
+		for _, line := range lines {
+			if !line.Amount.IsZero() {
+				kept = append(kept, line)
+			}
+		}
+		return Entry{EntryID: id, TransactionID: txn.TransactionID, Kind: kind, Currency: txn.Currency, Lines: kept, PostedAt: at}
+	}
+	prefix := txn.TransactionID + ":"
+
+	var entries []Entry
+	authorizedTime, authorized := authorizedAt(txn)
+	if authorized {
+		entries = append(entries, entry(prefix+KindAuthorize, KindAuthorize, authorizedTime,
+			Line{Account: account(CustomerReceivable), Side: Debit, Amount: txn.Amount},
+			Line{Account: account(AuthorizationHold), Side: Credit, Amount: txn.Amount},
+		))
+	}
+
+	switch {
+	case txn.CapturedAmount.IsPos():
+		capturedTime := txn.CreatedAt
+		if txn.CapturedAt != nil {
+			capturedTime = *txn.CapturedAt
+		}
+		fee, err := captureFee(txn, fees)
+		if err != nil {
+			return nil, err
+		}
+		payable, err := txn.CapturedAmount.Sub(fee)
+		if err != nil {
+			return nil, err
+		}
+
+		if authorized {
+			// The whole hold is released; whatever was not captured is no
+			// longer owed by the card holder
+			released, err := txn.Amount.Sub(txn.CapturedAmount)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry(prefix+KindCapture, KindCapture, capturedTime,
+				Line{Account: account(AuthorizationHold), Side: Debit, Amount: txn.Amount},
+				Line{Account: account(CustomerReceivable), Side: Credit, Amount: released},
+				Line{Account: account(MerchantPayable), Side: Credit, Amount: payable},
+				Line{Account: account(FeeRevenue), Side: Credit, Amount: fee},
+			))
+		} else {
+			entries = append(entries, entry(prefix+KindSale, KindSale, capturedTime,
+				Line{Account: account(CustomerReceivable), Side: Debit, Amount: txn.CapturedAmount},
+				Line{Account: account(MerchantPayable), Side: Credit, Amount: payable},
+				Line{Account: account(FeeRevenue), Side: Credit, Amount: fee},
+			))
+		}
+	case authorized && (txn.Status == types.StatusVoided || txn.Status == types.StatusExpired):
+		kind := KindVoid
+		if txn.Status == types.StatusExpired {
+			kind = KindExpire
+		}
+		entries = append(entries, entry(prefix+kind, kind, txn.UpdatedAt,
+			Line{Account: account(AuthorizationHold), Side: Debit, Amount: txn.Amount},
+			Line{Account: account(CustomerReceivable), Side: Credit, Amount: txn.Amount},
+		))
+	}
+
+	// Fees are kept on refunds, so the merchant bears the whole refund
+	for _, refund := range txn.Refunds {
+		entries = append(entries, entry(prefix+KindRefund+":"+refund.RefundID, KindRefund, refund.CreatedAt,
+			Line{Account: account(MerchantPayable), Side: Debit, Amount: refund.Amount},
+			Line{Account: account(RefundsPayable), Side: Credit, Amount: refund.Amount},
+		))
+	}
+	return entries, nil
+}
+
+func captureFee(txn types.Transaction, fees FeeSchedule) (decimal.Decimal, error) {
+	if txn.CaptureFee != nil {
+		return *txn.CaptureFee, nil
+	}
+	return fees.Fee(txn.CapturedAmount, txn.Currency)
+}
+
+// ChargeFee stores on txn the fee on its captured amount under the ledger's
+// schedule. It is called when a payment is captured, so changing the
+// schedule later does not change the entries of earlier captures.
+func (l *Ledger) ChargeFee(txn *types.Transaction) error {
+	fee, err := l.fees.Fee(txn.CapturedAmount, txn.Currency)
+	if err != nil {
+		return err
+	}
+	txn.CaptureFee = &fee
+	return nil
+}
+
+// Sync posts the entries for txn's transitions that are not in the ledger
+// yet and returns how many it posted. It is called after every change to a
+// transaction; calling it again for the same state posts nothing.
+func (l *Ledger) Sync(txn types.Transaction) (int, error) {
+	entries, err := Journal(txn, l.fees)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	posted := 0
+	for _, entry := range entries {
+		if l.posted[entry.EntryID] {
+			continue
+		}
+		if err := l.post(entry); err != nil {
+			return posted, err
+		}
+		posted++
+	}
+	return posted, nil
+}
+
+// SyncAll syncs every stored transaction, such as when rebuilding the ledger
+// at startup. A transaction that cannot be posted does not stop the rest;
+// their errors are joined.
+func (l *Ledger) SyncAll(transactions repository.TransactionRepository) (int, error) {
+	all, err := transactions.List()
+	if err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	var errs []error
+	for _, txn := range all {
+		n, err := l.Sync(txn)
+		posted += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return posted, errors.Join(errs...)
+}
@@ -0,0 +1,312 @@
+// Package ledger keeps a double-entry journal of the money each payment
+// moves. Every authorization, capture, sale, void, expiry and refund posts a
+// balanced entry, so at any time the balances of all accounts in a currency
+// add up to zero and every amount can be traced back to its transaction.
+//
+// Entries are derived from transactions alone and carry IDs fixed by the
+// transition they record, so syncing a transaction again never posts twice
+// and a ledger can be rebuilt from the transaction store at any time.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
+)
+
+var (
+	ErrDuplicateEntry = errors.New("journal entry has already been posted")
+	ErrUnbalanced     = errors.New("journal entry debits do not equal credits")
+	ErrInvalidEntry   = errors.New("invalid journal entry")
+)
+
+// AccountType is a kind of ledger account.
+type AccountType string
+
+const (
+	// CustomerReceivable is what card holders owe for authorized and
+	// captured payments.
+	CustomerReceivable AccountType = "customer_receivable"
+	// AuthorizationHold is money authorized on a card but not yet captured.
+	AuthorizationHold AccountType = "authorization_hold"
+	// MerchantPayable is what the gateway owes merchants for their captures,
+	// less fees and refunds.
+	MerchantPayable AccountType = "merchant_payable"
+	// FeeRevenue is the gateway's fees on captures.
+	FeeRevenue AccountType = "fee_revenue"
+	// RefundsPayable is what the gateway owes card holders for refunds.
+	RefundsPayable AccountType = "refunds_payable"
+)
+
+// normalSide is the side on which each account type grows.
+var normalSide = map[AccountType]Side{
+	CustomerReceivable: Debit,
+	AuthorizationHold:  Credit,
+	MerchantPayable:    Credit,
+	FeeRevenue:         Credit,
+	RefundsPayable:     Credit,
+}
+
+// Account is one merchant's account of a type in one currency.
+type Account struct {
+	Type     AccountType `json:"type"`
+	Merchant string      `json:"merchant"`
+	Currency string      `json:"currency"`
+}
+
+func (a Account) String() string {
+	return string(a.Type) + "/" + a.Merchant + "/" + a.Currency
+}
+
+type Side string
+
+const (
+	Debit  Side = "debit"
+	Credit Side = "credit"
+)
+
+// Line moves a positive Amount on one side of an account.
+type Line struct {
+	Account Account         `json:"account"`
+	Side    Side            `json:"side"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+// Entry is a balanced set of lines recording one transition of one
+// transaction.
+type Entry struct {
+	EntryID       string    `json:"entry_id"`
+	TransactionID string    `json:"transaction_id"`
+	Kind          string    `json:"kind"`
+	Currency      string    `json:"currency"`
+	Lines         []Line    `json:"lines"`
+	PostedAt      time.Time `json:"posted_at"`
+}
+
+// Validate checks the invariants every posted entry holds: it has an ID and
+// at least two lines, every line moves a positive amount the currency can
+// represent on a known account in the entry's currency, and debits equal
+// credits.
+func (e Entry) Validate() error {
+	if e.EntryID == "" || e.TransactionID == "" {
+		return fmt.Errorf("%w: entry and transaction IDs are required", ErrInvalidEntry)
+	}
+	if len(e.Lines) < 2 {
+		return fmt.Errorf("%w: %s has fewer than two lines", ErrInvalidEntry, e.EntryID)
+	}
+	scale, ok := currency.MinorUnits(e.Currency)
+	if !ok {
+		return fmt.Errorf("%w: %s has unknown currency %q", ErrInvalidEntry, e.EntryID, e.Currency)
+	}
+
+	var debits, credits decimal.Decimal
+	for _, line := range e.Lines {
+		if _, ok := normalSide[line.Account.Type]; !ok {
+			return fmt.Errorf("%w: %s posts to unknown account type %q", ErrInvalidEntry, e.EntryID, line.Account.Type)
+		}
+		if line.Account.Currency != e.Currency {
+			return fmt.Errorf("%w: %s mixes %s into a %s entry", ErrInvalidEntry, e.EntryID, line.Account.Currency, e.Currency)
+		}
+		if !line.Amount.IsPos() {
+			return fmt.Errorf("%w: %s has a line of %s on %s", ErrInvalidEntry, e.EntryID, line.Amount, line.Account)
+		}
+		if line.Amount.Scale() > scale {
+			return fmt.Errorf("%w: %s has %s, more precise than %s allows", ErrInvalidEntry, e.EntryID, line.Amount, e.Currency)
+		}
+
+		var err error
+		switch line.Side {
+		case Debit:
+			debits, err = debits.Add(line.Amount)
+		case Credit:
+			credits, err = credits.Add(line.Amount)
+		default:
+			return fmt.Errorf("%w: %s has a line on side %q", ErrInvalidEntry, e.EntryID, line.Side)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if debits.Cmp(credits) != 0 {
+		return fmt.Errorf("%w: %s debits %s and credits %s", ErrUnbalanced, e.EntryID, debits, credits)
+	}
+	return nil
+}
+
+// Balance is the running total of an account. Amount is the balance on the
+// account's normal side: a positive merchant payable is money owed to the
+// merchant.
+type Balance struct {
+	Account Account         `json:"account"`
+	Debits  decimal.Decimal `json:"debits"`
+	Credits decimal.Decimal `json:"credits"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+func (b *Balance) apply(line Line) error {
+	var err error
+	if line.Side == Debit {
+		b.Debits, err = b.Debits.Add(line.Amount)
+	} else {
+		b.Credits, err = b.Credits.Add(line.Amount)
+	}
+	if err != nil {
+		return err
+	}
+
+	if normalSide[b.Account.Type] == Debit {
+		b.Amount, err = b.Debits.Sub(b.Credits)
+	} else {
+		b.Amount, err = b.Credits.Sub(b.Debits)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Entries are validated against the currency, so it is always known
+	scale, _ := currency.MinorUnits(b.Account.Currency)
+	b.Debits, b.Credits, b.Amount = b.Debits.Pad(scale), b.Credits.Pad(scale), b.Amount.Pad(scale)
+	return nil
+}
+
+// Ledger is an in-memory journal with running account balances. It is safe
+// for concurrent use.
+type Ledger struct {
+	mu       sync.Mutex
+	fees     FeeSchedule
+	entries  []Entry
+	posted   map[string]bool
+	balances map[Account]*Balance
+}
+
+// New returns an empty ledger that charges fees on captures.
+func New(fees FeeSchedule) *Ledger {
+	return &Ledger{
+		fees:     fees,
+		posted:   make(map[string]bool),
+		balances: make(map[Account]*Balance),
+	}
+}
+
+// Post validates entry and adds it to the journal. An entry that breaks an
+// invariant or was already posted is rejected and changes nothing.
+func (l *Ledger) Post(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.post(entry)
+}
+
+func (l *Ledger) post(entry Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+	if l.posted[entry.EntryID] {
+		return fmt.Errorf("%w: %s", ErrDuplicateEntry, entry.EntryID)
+	}
+
+	// Work on copies so an overflow part way through leaves no partial entry
+	updated := make(map[Account]Balance)
+	for _, line := range entry.Lines {
+		balance, ok := updated[line.Account]
+		if !ok {
+			balance = Balance{Account: line.Account}
+			if current := l.balances[line.Account]; current != nil {
+				balance = *current
+			}
+		}
+		if err := balance.apply(line); err != nil {
+			return err
+		}
+		updated[line.Account] = balance
+	}
+
+	for account, balance := range updated {
+		l.balances[account] = &balance
+	}
+	l.entries = append(l.entries, entry)
+	l.posted[entry.EntryID] = true
+	return nil
+}
+
+// Balance returns the balance of account, which is zero for an account
+// nothing was posted to.
+func (l *Ledger) Balance(account Account) Balance {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if balance := l.balances[account]; balance != nil {
+		return *balance
+	}
+	return Balance{Account: account}
+}
+
+// Balances lists the balances of every account of merchant, or of every
+// merchant when merchant is "", ordered by merchant, currency and type.
+func (l *Ledger) Balances(merchant string) []Balance {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var balances []Balance
+	for account, balance := range l.balances {
+		if merchant == "" || account.Merchant == merchant {
+			balances = append(balances, *balance)
+		}
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		a, b := balances[i].Account, balances[j].Account
+		if a.Merchant != b.Merchant {
+			return a.Merchant < b.Merchant
+		}
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.Type < b.Type
+	})
+	return balances
+}
+
+// Entries returns the entries posted for a transaction in posting order.
+func (l *Ledger) Entries(transactionID string) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []Entry
+	for _, entry := range l.entries {
+		if entry.TransactionID == transactionID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Verify checks that total debits equal total credits in every currency,
+// which holds as long as every entry was balanced.
+func (l *Ledger) Verify() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	debits := make(map[string]decimal.Decimal)
+	credits := make(map[string]decimal.Decimal)
+	for account, balance := range l.balances {
+		var err error
+		if debits[account.Currency], err = debits[account.Currency].Add(balance.Debits); err != nil {
+			return err
+		}
+		if credits[account.Currency], err = credits[account.Currency].Add(balance.Credits); err != nil {
+			return err
+		}
+	}
+	for code, total := range debits {
+		if total.Cmp(credits[code]) != 0 {
+			return fmt.Errorf("%w: %s debits %s and credits %s", ErrUnbalanced, code, total, credits[code])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/lifecycle"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/refund"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecimal(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(s)
+	require.NoError(t, err)
+	return d
+}
+
+func ptr(d decimal.Decimal) *decimal.Decimal {
+	return &d
+}
+
+var now = time.Date(2025, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+func standardFees(t *testing.T) FeeSchedule {
+	fees, err := ParseFeeSchedule("2.9%+0.30", "USD")
+	require.NoError(t, err)
+	return fees
+}
+
+func sale(t *testing.T, id, amount string) types.Transaction {
+	captured := now
+	return types.Transaction{
+		TransactionID:  id,
+		Owner:          "movies",
+		Currency:       "USD",
+		Amount:         mustDecimal(t, amount),
+		CapturedAmount: mustDecimal(t, amount),
+		Status:         types.StatusSuccess,
+		CapturedAt:     &captured,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+func authorization(t *testing.T, id, amount string) types.Transaction {
+	authorized := now
+	expires := now.Add(15 * time.Minute)
+	return types.Transaction{
+		TransactionID: id,
+		Owner:         "movies",
+		Currency:      "USD",
+		Amount:        mustDecimal(t, amount),
+		Status:        types.StatusAuthorized,
+		AuthorizedAt:  &authorized,
+		ExpiresAt:     &expires,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+func issueRefund(t *testing.T, txn *types.Transaction, id string, amount *decimal.Decimal) {
+	t.Helper()
+	_, err := refund.Apply(txn, types.Refund{RefundID: id, CreatedAt: now.Add(time.Hour)}, amount)
+	require.NoError(t, err)
+}
+
+func account(typ AccountType) Account {
+	return Account{Type: typ, Merchant: "movies", Currency: "USD"}
+}
+
+// balances returns every non-zero balance as account type to amount.
+func balances(l *Ledger) map[AccountType]string {
+	result := make(map[AccountType]string)
+	for _, balance := range l.Balances("movies") {
+		if !balance.Amount.IsZero() {
+			result[balance.Account.Type] = balance.Amount.String()
+		}
+	}
+	return result
+}
+
+// assertNetsToZero checks that every entry posted is balanced and that the
+// ledger as a whole is.
+func assertNetsToZero(t *testing.T, l *Ledger, transactionIDs ...string) {
+	t.Helper()
+	require.NoError(t, l.Verify())
+
+	total := decimal.Zero
+	for _, id := range transactionIDs {
+		for _, entry := range l.Entries(id) {
+			require.NoError(t, entry.Validate())
+			for _, line := range entry.Lines {
+				var err error
+				if line.Side == Debit {
+					total, err = total.Add(line.Amount)
+				} else {
+					total, err = total.Sub(line.Amount)
+				}
+				require.NoError(t, err)
+			}
+		}
+	}
+	assert.True(t, total.IsZero(), "debits minus credits is %s", total)
+}
+
+func TestFlowsNetToZero(t *testing.T) {
+	tests := []struct {
+		name     string
+		flow     func(t *testing.T) []types.Transaction
+		kinds    []string
+		balances map[AccountType]string
+	}{
+		{
+			name:  "sale",
+			flow:  func(t *testing.T) []types.Transaction { return []types.Transaction{sale(t, "txn-1", "100.00")} },
+			kinds: []string{KindSale},
+			balances: map[AccountType]string{
+				CustomerReceivable: "100.00",
+				MerchantPayable:    "96.80",
+				FeeRevenue:         "3.20",
+			},
+		},
+		{
+			name: "sale with partial then full refund",
+			flow: func(t *testing.T) []types.Transaction {
+				txn := sale(t, "txn-1", "100.00")
+				first := txn
+				issueRefund(t, &txn, "ref-1", ptr(mustDecimal(t, "40.00")))
+				second := txn
+				issueRefund(t, &txn, "ref-2", nil)
+				return []types.Transaction{first, second, txn}
+			},
+			kinds: []string{KindSale, KindRefund, KindRefund},
+			balances: map[AccountType]string{
+				CustomerReceivable: "100.00",
+				// The merchant still owes the fee after refunding in full
+				MerchantPayable: "-3.20",
+				FeeRevenue:      "3.20",
+				RefundsPayable:  "100.00",
+			},
+		},
+		{
+			name: "authorize and capture in full",
+			flow: func(t *testing.T) []types.Transaction {
+				txn := authorization(t, "txn-1", "50.00")
+				authorized := txn
+				require.NoError(t, lifecycle.Capture(&txn, nil, now.Add(time.Minute)))
+				return []types.Transaction{authorized, txn}
+			},
+			kinds: []string{KindAuthorize, KindCapture},
+			balances: map[AccountType]string{
+				CustomerReceivable: "50.00",
+				MerchantPayable:    "48.25",
+				FeeRevenue:         "1.75",
+			},
+		},
+		{
+			name: "authorize, capture part and refund",
+			flow: func(t *testing.T) []types.Transaction {
+				txn := authorization(t, "txn-1", "50.00")
+				authorized := txn
+				require.NoError(t, lifecycle.Capture(&txn, ptr(mustDecimal(t, "20.00")), now.Add(time.Minute)))
+				captured := txn
+				issueRefund(t, &txn, "ref-1", ptr(mustDecimal(t, "5.00")))
+				return []types.Transaction{authorized, captured, txn}
+			},
+			kinds: []string{KindAuthorize, KindCapture, KindRefund},
+			balances: map[AccountType]string{
+				CustomerReceivable: "20.00",
+				MerchantPayable:    "14.12",
+				FeeRevenue:         "0.88",
+				RefundsPayable:     "5.00",
+			},
+		},
+		{
+			name: "authorize and void",
+			flow: func(t *testing.T) []types.Transaction {
+				txn := authorization(t, "txn-1", "50.00")
+				authorized := txn
+				require.NoError(t, lifecycle.Void(&txn, now.Add(time.Minute)))
+				return []types.Transaction{authorized, txn}
+			},
+			kinds:    []string{KindAuthorize, KindVoid},
+			balances: map[AccountType]string{},
+		},
+		{
+			name: "authorize and expire",
+			flow: func(t *testing.T) []types.Transaction {
+				txn := authorization(t, "txn-1", "50.00")
+				authorized := txn
+				require.NoError(t, lifecycle.Expire(&txn, now.Add(time.Hour)))
+				return []types.Transaction{authorized, txn}
+			},
+			kinds:    []string{KindAuthorize, KindExpire},
+			balances: map[AccountType]string{},
+		},
+		{
+			name: "declined",
+			flow: func(t *testing.T) []types.Transaction {
+				return []types.Transaction{{TransactionID: "txn-1", Owner: "movies", Currency: "USD", Amount: mustDecimal(t, "10.00"), Status: types.StatusFailed, CreatedAt: now, UpdatedAt: now}}
+			},
+			balances: map[AccountType]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(standardFees(t))
+			for _, state := range tt.flow(t) {
+				_, err := l.Sync(state)
+				require.NoError(t, err)
+				// Every intermediate state balances too
+				assertNetsToZero(t, l, "txn-1")
+			}
+
+			var kinds []string
+			for _, entry := range l.Entries("txn-1") {
+				kinds = append(kinds, entry.Kind)
+			}
+			assert.Equal(t, tt.kinds, kinds)
+			assert.Equal(t, tt.balances, balances(l))
+		})
+	}
+}
+
+func TestSyncIsIdempotent(t *testing.T) {
+	l := New(standardFees(t))
+	txn := sale(t, "txn-1", "100.00")
+
+	posted, err := l.Sync(txn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, posted)
+
+	posted, err = l.Sync(txn)
+	require.NoError(t, err)
+	assert.Equal(t, 0, posted)
+
+	issueRefund(t, &txn, "ref-1", ptr(mustDecimal(t, "10.00")))
+	posted, err = l.Sync(txn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, posted)
+	assert.Equal(t, "86.80", l.Balance(account(MerchantPayable)).Amount.String())
+}
+
+func TestSyncAllRebuildsFromTransactions(t *testing.T) {
+	transactions := repository.NewMemoryRepository()
+	txn := sale(t, "txn-1", "100.00")
+	issueRefund(t, &txn, "ref-1", ptr(mustDecimal(t, "10.00")))
+	require.NoError(t, transactions.Save(txn))
+	require.NoError(t, transactions.Save(authorization(t, "txn-2", "30.00")))
+
+	l := New(standardFees(t))
+	posted, err := l.SyncAll(transactions)
+	require.NoError(t, err)
+	assert.Equal(t, 3, posted)
+	assertNetsToZero(t, l, "txn-1", "txn-2")
+	assert.Equal(t, "130.00", l.Balance(account(CustomerReceivable)).Amount.String())
+	assert.Equal(t, "30.00", l.Balance(account(AuthorizationHold)).Amount.String())
+}
+
+func TestStoredCaptureFeeOutlivesFeeChanges(t *testing.T) {
+	txn := sale(t, "txn-1", "100.00")
+	require.NoError(t, New(standardFees(t)).ChargeFee(&txn))
+	require.NotNil(t, txn.CaptureFee)
+	assert.Equal(t, "3.20", txn.CaptureFee.String())
+
+	// A ledger rebuilt after the fees changed posts the fee charged at capture
+	fees, err := ParseFeeSchedule("1%", "USD")
+	require.NoError(t, err)
+	l := New(fees)
+	_, err = l.Sync(txn)
+	require.NoError(t, err)
+	assert.Equal(t, "3.20", l.Balance(account(FeeRevenue)).Amount.String())
+	assert.Equal(t, "96.80", l.Balance(account(MerchantPayable)).Amount.String())
+
+	// Records from before the fee was stored are charged the current schedule
+	_, err = l.Sync(sale(t, "txn-2", "100.00"))
+	require.NoError(t, err)
+	assert.Equal(t, "4.20", l.Balance(account(FeeRevenue)).Amount.String())
+}
+
+func TestBalancesAreKeptPerMerchantAndCurrency(t *testing.T) {
+	l := New(FeeSchedule{})
+	usd := sale(t, "txn-1", "10.00")
+	inr := sale(t, "txn-2", "500.00")
+	inr.Currency = "INR"
+	other := sale(t, "txn-3", "7.00")
+	other.Owner = "snacks"
+
+	for _, txn := range []types.Transaction{usd, inr, other} {
+		_, err := l.Sync(txn)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, "10.00", l.Balance(account(MerchantPayable)).Amount.String())
+	assert.Equal(t, "500.00", l.Balance(Account{Type: MerchantPayable, Merchant: "movies", Currency: "INR"}).Amount.String())
+	assert.Equal(t, "7.00", l.Balance(Account{Type: MerchantPayable, Merchant: "snacks", Currency: "USD"}).Amount.String())
+	assert.True(t, l.Balance(account(FeeRevenue)).Amount.IsZero(), "a fee-free sale posts no fee line")
+	assert.Len(t, l.Balances("movies"), 4)
+	assert.Len(t, l.Balances(""), 6)
+	require.NoError(t, l.Verify())
+}
+
+func TestPostEnforcesInvariants(t *testing.T) {
+	line := func(typ AccountType, side Side, amount string) Line {
+		return Line{Account: account(typ), Side: side, Amount: mustDecimal(t, amount)}
+	}
+	valid := func() Entry {
+		return Entry{
+			EntryID:       "txn-1:sale",
+			TransactionID: "txn-1",
+			Kind:          KindSale,
+			Currency:      "USD",
+			Lines: []Line{
+				line(CustomerReceivable, Debit, "10.00"),
+				line(MerchantPayable, Credit, "9.70"),
+				line(FeeRevenue, Credit, "0.30"),
+			},
+			PostedAt: now,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(e *Entry)
+		err    error
+	}{
+		{"unbalanced", func(e *Entry) { e.Lines[1].Amount = mustDecimal(t, "9.69") }, ErrUnbalanced},
+		{"single line", func(e *Entry) { e.Lines = e.Lines[:1] }, ErrInvalidEntry},
+		{"missing ID", func(e *Entry) { e.EntryID = "" }, ErrInvalidEntry},
+		{"zero amount", func(e *Entry) {
+			e.Lines = append(e.Lines, line(RefundsPayable, Debit, "0"), line(RefundsPayable, Credit, "0"))
+		}, ErrInvalidEntry},
+		{"negative amount", func(e *Entry) {
+			e.Lines[0].Amount = mustDecimal(t, "-10.00")
+			e.Lines[0].Side = Credit
+			e.Lines = append(e.Lines, line(CustomerReceivable, Debit, "20.00"))
+		}, ErrInvalidEntry},
+		{"unknown account", func(e *Entry) { e.Lines[2].Account.Type = "suspense" }, ErrInvalidEntry},
+		{"unknown side", func(e *Entry) { e.Lines[2].Side = "both" }, ErrInvalidEntry},
+		{"mixed currencies", func(e *Entry) { e.Lines[2].Account.Currency = "EUR" }, ErrInvalidEntry},
+		{"too precise", func(e *Entry) {
+			e.Lines[1].Amount = mustDecimal(t, "9.695")
+			e.Lines[2].Amount = mustDecimal(t, "0.305")
+		}, ErrInvalidEntry},
+		{"unknown currency", func(e *Entry) { e.Currency = "XXX" }, ErrInvalidEntry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(FeeSchedule{})
+			entry := valid()
+			tt.change(&entry)
+
+			assert.ErrorIs(t, l.Post(entry), tt.err)
+			assert.Empty(t, l.Balances(""), "a rejected entry must not move any balance")
+			assert.Empty(t, l.Entries("txn-1"))
+		})
+	}
+
+	l := New(FeeSchedule{})
+	require.NoError(t, l.Post(valid()))
+	assert.ErrorIs(t, l.Post(valid()), ErrDuplicateEntry)
+	assert.Equal(t, "10.00", l.Balance(account(CustomerReceivable)).Amount.String())
+	assert.Equal(t, "10.00", l.Balance(account(CustomerReceivable)).Debits.String())
+}
+
+func TestParseFeeSchedule(t *testing.T) {
+	tests := []struct {
+		value    string
+		amount   string
+		currency string
+		want     string
+	}{
+		{"2.9%+0.30", "100.00", "USD", "3.20"},
+		{"2.9%+0.30", "0.10", "USD", "0.10"}, // capped at the amount
+		{"1.5%", "33.33", "USD", "0.50"},
+		{"0.25", "10.00", "USD", "0.25"},
+		{"0", "10.00", "USD", "0.00"},
+		{"2.9%+USD:0.30+JPY:30", "1000", "JPY", "59"},
+		{"2.9%+USD:0.30+JPY:30", "100.00", "USD", "3.20"},
+		{"2.9%+USD:0.30", "1000", "JPY", "29"}, // no fixed fee in JPY
+		{"2.9%+usd:0.3", "100.00", "USD", "3.20"},
+	}
+	for _, tt := range tests {
+		fees, err := ParseFeeSchedule(tt.value, "USD")
+		require.NoError(t, err, tt.value)
+		fee, err := fees.Fee(mustDecimal(t, tt.amount), tt.currency)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, fee.String(), "%s of %s %s", tt.value, tt.amount, tt.currency)
+	}
+
+	for _, value := range []string{"", "abc", "-1%", "101%", "2%+-0.30", "JPY:0.30", "XXX:1", "USD:0.30+0.25", "1%:USD"} {
+		_, err := ParseFeeSchedule(value, "USD")
+		assert.Error(t, err, value)
+	}
+
+	// A bare fixed fee must fit the default currency
+	_, err := ParseFeeSchedule("2.9%+0.30", "JPY")
+	assert.Error(t, err)
+}
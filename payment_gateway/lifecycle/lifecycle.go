@@ -0,0 +1,135 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+var (
+	ErrInvalidTransition    = errors.New("transaction cannot move to the requested state")
+	ErrAuthorizationExpired = errors.New("authorization has expired")
+	ErrInvalidAmount        = errors.New("invalid capture amount")
+	ErrExceedsAuthorized    = errors.New("capture amount exceeds the authorized amount")
+)
+
+// transitions lists the states each transaction state may move to. PENDING
+// is only seen for asynchronous payments and REQUIRES_ACTION for payments
+// waiting on a 3-D Secure challenge. SUCCESS is a one-step sale and behaves
+// like CAPTURED from here on.
+var transitions = map[string][]string{
+	types.StatusPending:           {types.StatusSuccess, types.StatusFailed, types.StatusAuthorized},
+	types.StatusRequiresAction:    {types.StatusSuccess, types.StatusFailed, types.StatusAuthorized},
+	types.StatusAuthorized:        {types.StatusCaptured, types.StatusVoided, types.StatusExpired},
+	types.StatusSuccess:           {types.StatusPartiallyRefunded, types.StatusRefunded},
+	types.StatusCaptured:          {types.StatusPartiallyRefunded, types.StatusRefunded},
+	types.StatusPartiallyRefunded: {types.StatusPartiallyRefunded, types.StatusRefunded},
+}
+
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func transition(txn *types.Transaction, to string, now time.Time) error {
+	if !CanTransition(txn.Status, to) {
+		return ErrInvalidTransition
+	}
+	txn.Status = to
+	txn.UpdatedAt = now
+	return nil
+}
+
+func isExpired(txn *types.Transaction, now time.Time) bool {
+	return txn.ExpiresAt != nil && now.After(*txn.ExpiresAt)
+}
+
+// Capture charges an authorized transaction. A nil amount captures the full
+// authorized amount; a smaller amount captures partially and releases the
+// rest, and must fit the currency's minor units. An authorization past its
+// expiry is moved to EXPIRED instead.
+func Capture(txn *types.Transaction, amount *decimal.Decimal, now time.Time) error {
+	if txn.Status == types.StatusAuthorized && isExpired(txn, now) {
+		return ErrAuthorizationExpired
+	}
+
+	captured := txn.Amount
+	if amount != nil {
+		captured = *amount
+	}
+	if !captured.IsPos() {
+		return fmt.Errorf("%w: must be greater than zero", ErrInvalidAmount)
+	}
+	if minorUnits, ok := currency.MinorUnits(txn.Currency); ok {
+		if captured.MinScale() > minorUnits {
+			return fmt.Errorf("%w: must have at most %d decimal places for %s", ErrInvalidAmount, minorUnits, txn.Currency)
+		}
+		captured = captured.Trim(minorUnits).Pad(minorUnits)
+	}
+	if captured.Cmp(txn.Amount) > 0 {
+		return ErrExceedsAuthorized
+	}
+
+	if err := transition(txn, types.StatusCaptured, now); err != nil {
+		return err
+	}
+	txn.CapturedAmount = captured
+	txn.CapturedAt = &now
+	txn.ExpiresAt = nil
+	return nil
+}
+
+func Void(txn *types.Transaction, now time.Time) error {
+	if txn.Status == types.StatusAuthorized && isExpired(txn, now) {
+		return ErrAuthorizationExpired
+	}
+	if err := transition(txn, types.StatusVoided, now); err != nil {
+		return err
+	}
+	txn.ExpiresAt = nil
+	return nil
+}
+
+func Expire(txn *types.Transaction, now time.Time) error {
+	if !isExpired(txn, now) {
+		return ErrInvalidTransition
+	}
+	return transition(txn, types.StatusExpired, now)
+}
+
+// ExpireAuthorizations moves every authorization whose window has passed to
+// EXPIRED and returns how many were expired.
+func ExpireAuthorizations(transactions repository.TransactionRepository, now time.Time) (int, error) {
+	all, err := transactions.List()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, txn := range all {
+		if txn.Status != types.StatusAuthorized || !isExpired(&txn, now) {
+			continue
+		}
+		_, err := transactions.Update(txn.TransactionID, func(txn *types.Transaction) error {
+			return Expire(txn, now)
+		})
+		if errors.Is(err, ErrInvalidTransition) {
+			// Captured or voided between List and Update.
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
@@ -0,0 +1,139 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecimal(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(s)
+	require.NoError(t, err)
+	return d
+}
+
+func authorizedTransaction(t *testing.T, id string, expiresAt time.Time) types.Transaction {
+	return types.Transaction{
+		TransactionID: id,
+		Amount:        mustDecimal(t, "50.00"),
+		Currency:      "USD",
+		Status:        types.StatusAuthorized,
+		ExpiresAt:     &expiresAt,
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: types.StatusAuthorized, to: types.StatusCaptured, want: true},
+		{from: types.StatusAuthorized, to: types.StatusVoided, want: true},
+		{from: types.StatusAuthorized, to: types.StatusExpired, want: true},
+		{from: types.StatusAuthorized, to: types.StatusRefunded, want: false},
+		{from: types.StatusCaptured, to: types.StatusVoided, want: false},
+		{from: types.StatusCaptured, to: types.StatusRefunded, want: true},
+		{from: types.StatusVoided, to: types.StatusCaptured, want: false},
+		{from: types.StatusExpired, to: types.StatusCaptured, want: false},
+		{from: types.StatusFailed, to: types.StatusCaptured, want: false},
+		{from: types.StatusPending, to: types.StatusSuccess, want: true},
+		{from: types.StatusPending, to: types.StatusRefunded, want: false},
+		{from: types.StatusRequiresAction, to: types.StatusSuccess, want: true},
+		{from: types.StatusRequiresAction, to: types.StatusAuthorized, want: true},
+		{from: types.StatusRequiresAction, to: types.StatusRefunded, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.from+"To"+tc.to, func(t *testing.T) {
+			assert.Equal(t, tc.want, CanTransition(tc.from, tc.to))
+		})
+	}
+}
+
+func TestFullCapture(t *testing.T) {
+	now := time.Now()
+	txn := authorizedTransaction(t, "txn-1", now.Add(time.Hour))
+
+	require.NoError(t, Capture(&txn, nil, now))
+	assert.Equal(t, types.StatusCaptured, txn.Status)
+	assert.Equal(t, "50.00", txn.CapturedAmount.String())
+	require.NotNil(t, txn.CapturedAt)
+	assert.True(t, txn.CapturedAt.Equal(now))
+	assert.Nil(t, txn.ExpiresAt)
+
+	assert.ErrorIs(t, Capture(&txn, nil, now), ErrInvalidTransition)
+	assert.ErrorIs(t, Void(&txn, now), ErrInvalidTransition)
+}
+
+func TestPartialCapture(t *testing.T) {
+	now := time.Now()
+	txn := authorizedTransaction(t, "txn-1", now.Add(time.Hour))
+
+	tooMuch := mustDecimal(t, "50.01")
+	assert.ErrorIs(t, Capture(&txn, &tooMuch, now), ErrExceedsAuthorized)
+
+	zero := mustDecimal(t, "0")
+	assert.ErrorIs(t, Capture(&txn, &zero, now), ErrInvalidAmount)
+
+	partial := mustDecimal(t, "20.00")
+	require.NoError(t, Capture(&txn, &partial, now))
+	assert.Equal(t, "20.00", txn.CapturedAmount.String())
+}
+
+func TestCaptureAmountMustFitCurrency(t *testing.T) {
+	now := time.Now()
+	txn := authorizedTransaction(t, "txn-1", now.Add(time.Hour))
+
+	tooPrecise := mustDecimal(t, "20.001")
+	assert.ErrorIs(t, Capture(&txn, &tooPrecise, now), ErrInvalidAmount)
+	assert.Equal(t, types.StatusAuthorized, txn.Status)
+
+	// Trailing zeros are not extra precision
+	whole := mustDecimal(t, "20")
+	require.NoError(t, Capture(&txn, &whole, now))
+	assert.Equal(t, "20.00", txn.CapturedAmount.String())
+}
+
+func TestVoid(t *testing.T) {
+	now := time.Now()
+	txn := authorizedTransaction(t, "txn-1", now.Add(time.Hour))
+
+	require.NoError(t, Void(&txn, now))
+	assert.Equal(t, types.StatusVoided, txn.Status)
+	assert.ErrorIs(t, Capture(&txn, nil, now), ErrInvalidTransition)
+}
+
+func TestCaptureAfterExpiry(t *testing.T) {
+	now := time.Now()
+	txn := authorizedTransaction(t, "txn-1", now.Add(-time.Minute))
+
+	assert.ErrorIs(t, Capture(&txn, nil, now), ErrAuthorizationExpired)
+	assert.ErrorIs(t, Void(&txn, now), ErrAuthorizationExpired)
+	assert.Equal(t, types.StatusAuthorized, txn.Status)
+}
+
+func TestExpireAuthorizations(t *testing.T) {
+	now := time.Now()
+	repo := repository.NewMemoryRepository()
+
+	require.NoError(t, repo.Save(authorizedTransaction(t, "stale", now.Add(-time.Minute))))
+	require.NoError(t, repo.Save(authorizedTransaction(t, "fresh", now.Add(time.Hour))))
+	require.NoError(t, repo.Save(types.Transaction{TransactionID: "sale", Status: types.StatusSuccess}))
+
+	expired, err := ExpireAuthorizations(repo, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	stale, _ := repo.FindByID("stale")
+	assert.Equal(t, types.StatusExpired, stale.Status)
+
+	fresh, _ := repo.FindByID("fresh")
+	assert.Equal(t, types.StatusAuthorized, fresh.Status)
+}
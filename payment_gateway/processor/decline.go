@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+// DeclineError is returned when the issuer declines a payment. Message is
+// safe to show to the customer.
+type DeclineError struct {
+	Code    types.DeclineCode
+	Message string
+}
+
+func (e *DeclineError) Error() string {
+	return e.Message
+}
+
+// declineMessages are the messages issuers send with each decline code.
+var declineMessages = map[types.DeclineCode]string{
+	types.DeclineInsufficientFunds: "insufficient funds",
+	types.DeclineDoNotHonor:        "payment declined by the issuing bank",
+	types.DeclineExpiredCard:       "card has expired",
+	types.DeclineIncorrectCVV:      "incorrect security code",
+	types.DeclineLostOrStolen:      "card reported lost or stolen",
+	types.DeclineSuspectedFraud:    "payment declined as suspected fraud",
+	types.DeclineProcessingError:   "an error occurred while processing the card",
+	types.DeclineIssuerUnavailable: "issuing bank is unavailable",
+}
+
+// Decline returns the error for a payment declined with code.
+func Decline(code types.DeclineCode) *DeclineError {
+	return &DeclineError{Code: code, Message: declineMessages[code]}
+}
+
+// IsIssuerDeclineCode reports whether code is one an issuer can return, as
+// opposed to the codes the gateway uses for payments it fails itself.
+func IsIssuerDeclineCode(code types.DeclineCode) bool {
+	_, ok := declineMessages[code]
+	return ok
+}
+
+// DeclineCodeOf returns the decline code of a failed ProcessPayment or
+// Authorize call. Other errors are reported as processing errors. It returns
+// "" for a nil error and for ErrInterrupted, which declines nothing.
+func DeclineCodeOf(err error) types.DeclineCode {
+	if err == nil || errors.Is(err, ErrInterrupted) {
+		return ""
+	}
+	var decline *DeclineError
+	if errors.As(err, &decline) {
+		return decline.Code
+	}
+	return types.DeclineProcessingError
+}
+
+// DeclineWeight is the relative share of simulated declines given Code.
+type DeclineWeight struct {
+	Code   types.DeclineCode
+	Weight float64
+}
+
+// DeclineDistribution is the mix of decline codes the simulator picks from.
+// It is a slice rather than a map so a seeded simulator picks the same codes
+// on every run.
+type DeclineDistribution []DeclineWeight
+
+// DefaultDeclineDistribution roughly follows the mix of declines a card
+// acquirer sees: mostly generic and insufficient funds declines, with a
+// small share of retryable issuer problems.
+func DefaultDeclineDistribution() DeclineDistribution {
+	return DeclineDistribution{
+		{Code: types.DeclineDoNotHonor, Weight: 40},
+		{Code: types.DeclineInsufficientFunds, Weight: 25},
+		{Code: types.DeclineExpiredCard, Weight: 8},
+		{Code: types.DeclineIncorrectCVV, Weight: 7},
+		{Code: types.DeclineLostOrStolen, Weight: 3},
+		{Code: types.DeclineSuspectedFraud, Weight: 5},
+		{Code: types.DeclineProcessingError, Weight: 7},
+		{Code: types.DeclineIssuerUnavailable, Weight: 5},
+	}
+}
+
+// ParseDeclineDistribution accepts comma separated code=weight pairs, such
+// as "insufficient_funds=3,do_not_honor=1". Weights are relative and need
+// not add up to anything in particular.
+func ParseDeclineDistribution(value string) (DeclineDistribution, error) {
+	var distribution DeclineDistribution
+	seen := make(map[types.DeclineCode]bool)
+	total := 0.0
+
+	for _, part := range strings.Split(value, ",") {
+		name, weightPart, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid decline weight %q, expected code=weight", part)
+		}
+		code := types.DeclineCode(strings.TrimSpace(name))
+		if !IsIssuerDeclineCode(code) {
+			return nil, fmt.Errorf("unknown decline code %q", code)
+		}
+		if seen[code] {
+			return nil, fmt.Errorf("decline code %q listed twice", code)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(weightPart), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for %q: %q", code, weightPart)
+		}
+		seen[code] = true
+		total += weight
+		distribution = append(distribution, DeclineWeight{Code: code, Weight: weight})
+	}
+
+	if total <= 0 {
+		return nil, errors.New("decline distribution needs at least one positive weight")
+	}
+	return distribution, nil
+}
+
+// pick returns the code whose share of the total weight contains r, a
+// number in [0, 1).
+func (d DeclineDistribution) pick(r float64) types.DeclineCode {
+	total := 0.0
+	for _, w := range d {
+		total += w.Weight
+	}
+
+	// Fall back to a generic decline for an empty distribution, and to the
+	// last weighted code when rounding overshoots the end
+	code := types.DeclineDoNotHonor
+	target := r * total
+	for _, w := range d {
+		if w.Weight <= 0 {
+			continue
+		}
+		if target < w.Weight {
+			return w.Code
+		}
+		target -= w.Weight
+		code = w.Code
+	}
+	return code
+}
@@ -1,26 +1,41 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math/rand"
-	"time"
 
+	"github.com/govalues/decimal"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
 )
 
-type PaymentProcessor struct{}
+// ErrInterrupted wraps the context's error when ctx ends before the issuer
+// answers. The payment is neither approved nor declined, so ProcessPayment
+// and Authorize return it with PENDING and the payment can be retried.
+var ErrInterrupted = errors.New("payment processing was interrupted")
 
-func NewPaymentProcessor() *PaymentProcessor {
-	return &PaymentProcessor{}
+// Processor is the card network backend the gateway talks to. Every call
+// honours ctx so a cancelled client request stops waiting on the issuer.
+type Processor interface {
+	ProcessPayment(ctx context.Context, req types.PaymentRequest) (string, error)
+	// Authorize reserves funds on the card without charging it. The hold is
+	// later settled with Capture or released with Void.
+	Authorize(ctx context.Context, req types.PaymentRequest) (string, error)
+	Capture(ctx context.Context, txn types.Transaction, amount decimal.Decimal) error
+	Void(ctx context.Context, txn types.Transaction) error
 }
 
-func (p *PaymentProcessor) ProcessPayment(req types.PaymentRequest) (string, error) {
-	processingDelay := 400 + rand.Intn(401)
-	time.Sleep(time.Duration(processingDelay) * time.Millisecond)
-
-	if rand.Float64() < 0.1 {
-		return "FAILED", fmt.Errorf("payment declined by the issuing bank")
+// New returns the processor backend selected by kind. An empty kind selects
+// the simulator configured by cfg.
+func New(kind string, cfg SimulatorConfig) (Processor, error) {
+	switch kind {
+	case "", "simulator":
+		return NewSimulator(cfg), nil
+	case "approve":
+		return NewAlwaysSucceed(), nil
+	case "decline":
+		return NewAlwaysFail(types.DeclineDoNotHonor), nil
+	default:
+		return nil, fmt.Errorf("unknown processor %q", kind)
 	}
-
-	return "SUCCESS", nil
 }
@@ -1,6 +1,9 @@
 package processor
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -8,34 +11,44 @@ import (
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
 )
 
-func TestProcessPayment(t *testing.T) {
-	processor := NewPaymentProcessor()
+func testSimulator(failureRate float64) *Simulator {
+	return NewSimulator(SimulatorConfig{
+		FailureRate: failureRate,
+		Seed:        42,
+	})
+}
 
+func testRequest(cardNumber string) types.PaymentRequest {
 	testAmount, _ := decimal.NewFromFloat64(100.0)
 
-	req := types.PaymentRequest{
-		CardNumber: "4111111111111111",
+	return types.PaymentRequest{
+		CardNumber: cardNumber,
 		CVV:        "123",
 		Expiry:     "12/25",
 		Name:       "Test User",
 		Amount:     testAmount,
 		Timestamp:  time.Now(),
 	}
+}
+
+func TestProcessPayment(t *testing.T) {
+	processor := testSimulator(0.1)
+	req := testRequest("4111111111111111")
 
 	successCount := 0
 	failureCount := 0
-	totalCalls := 20
+	totalCalls := 100
 
 	for i := 0; i < totalCalls; i++ {
-		status, err := processor.ProcessPayment(req)
+		status, err := processor.ProcessPayment(context.Background(), req)
 		if err != nil {
 			failureCount++
-			if status != "FAILED" {
+			if status != types.StatusFailed {
 				t.Errorf("Expected FAILED status on error, got %s", status)
 			}
 		} else {
 			successCount++
-			if status != "SUCCESS" {
+			if status != types.StatusSuccess {
 				t.Errorf("Expected SUCCESS status, got %s", status)
 			}
 		}
@@ -51,3 +64,247 @@ func TestProcessPayment(t *testing.T) {
 
 	t.Logf("Success count: %d, Failure count: %d", successCount, failureCount)
 }
+
+func TestSeededSimulatorIsReproducible(t *testing.T) {
+	req := testRequest("4111111111111111")
+
+	first := testSimulator(0.5)
+	second := testSimulator(0.5)
+
+	for i := 0; i < 20; i++ {
+		firstStatus, _ := first.ProcessPayment(context.Background(), req)
+		secondStatus, _ := second.ProcessPayment(context.Background(), req)
+		if firstStatus != secondStatus {
+			t.Fatalf("Call %d: expected identical outcomes for the same seed, got %s and %s", i, firstStatus, secondStatus)
+		}
+	}
+}
+
+func TestSimulatorLatency(t *testing.T) {
+	processor := NewSimulator(SimulatorConfig{
+		MinLatency: 20 * time.Millisecond,
+		MaxLatency: 30 * time.Millisecond,
+		Seed:       42,
+	})
+
+	start := time.Now()
+	if _, err := processor.ProcessPayment(context.Background(), testRequest("4111111111111111")); err != nil {
+		t.Fatalf("Expected success with zero failure rate, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected at least 20ms latency, got %s", elapsed)
+	}
+}
+
+func TestSimulatorHonoursContext(t *testing.T) {
+	processor := NewSimulator(SimulatorConfig{
+		MinLatency: time.Second,
+		MaxLatency: time.Second,
+		Seed:       42,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	status, err := processor.ProcessPayment(ctx, testRequest("4111111111111111"))
+	if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected an interrupted deadline, got %v", err)
+	}
+	if status != types.StatusPending {
+		t.Errorf("Expected PENDING status on cancellation, got %s", status)
+	}
+	if code := DeclineCodeOf(err); code != "" {
+		t.Errorf("Expected no decline code on cancellation, got %q", code)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	processor := testSimulator(0.1)
+	req := testRequest("4111111111111111")
+
+	for i := 0; i < 5; i++ {
+		status, err := processor.Authorize(context.Background(), req)
+		if err != nil {
+			if status != types.StatusFailed {
+				t.Errorf("Expected FAILED status on error, got %s", status)
+			}
+		} else if status != types.StatusAuthorized {
+			t.Errorf("Expected AUTHORIZED status, got %s", status)
+		}
+	}
+}
+
+func TestCaptureAndVoid(t *testing.T) {
+	processor := testSimulator(0.1)
+
+	testAmount, _ := decimal.NewFromFloat64(100.0)
+	txn := types.Transaction{TransactionID: "txn-1", Amount: testAmount, Status: types.StatusAuthorized}
+
+	if err := processor.Capture(context.Background(), txn, testAmount); err != nil {
+		t.Errorf("Expected capture to succeed, got %v", err)
+	}
+	if err := processor.Void(context.Background(), txn); err != nil {
+		t.Errorf("Expected void to succeed, got %v", err)
+	}
+}
+
+func TestTestCardsAreDeterministic(t *testing.T) {
+	processor := testSimulator(0.5)
+
+	tests := []struct {
+		name       string
+		cardNumber string
+		wantStatus string
+		wantError  string
+		wantCode   types.DeclineCode
+	}{
+		{name: "Approved", cardNumber: TestCardApproved, wantStatus: types.StatusSuccess},
+		{name: "Declined", cardNumber: TestCardDeclined, wantStatus: types.StatusFailed, wantError: "payment declined by the issuing bank", wantCode: types.DeclineDoNotHonor},
+		{name: "InsufficientFunds", cardNumber: TestCardInsufficientFunds, wantStatus: types.StatusFailed, wantError: "insufficient funds", wantCode: types.DeclineInsufficientFunds},
+		{name: "Expired", cardNumber: TestCardExpired, wantStatus: types.StatusFailed, wantError: "card has expired", wantCode: types.DeclineExpiredCard},
+		{name: "ProcessingError", cardNumber: TestCardProcessingError, wantStatus: types.StatusFailed, wantError: "an error occurred while processing the card", wantCode: types.DeclineProcessingError},
+		{name: "IncorrectCVV", cardNumber: TestCardIncorrectCVV, wantStatus: types.StatusFailed, wantError: "incorrect security code", wantCode: types.DeclineIncorrectCVV},
+		{name: "LostOrStolen", cardNumber: TestCardLostOrStolen, wantStatus: types.StatusFailed, wantError: "card reported lost or stolen", wantCode: types.DeclineLostOrStolen},
+		{name: "SuspectedFraud", cardNumber: TestCardSuspectedFraud, wantStatus: types.StatusFailed, wantError: "payment declined as suspected fraud", wantCode: types.DeclineSuspectedFraud},
+		{name: "IssuerUnavailable", cardNumber: TestCardIssuerUnavailable, wantStatus: types.StatusFailed, wantError: "issuing bank is unavailable", wantCode: types.DeclineIssuerUnavailable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := testRequest(tc.cardNumber)
+
+			for i := 0; i < 5; i++ {
+				status, err := processor.ProcessPayment(context.Background(), req)
+				if status != tc.wantStatus {
+					t.Fatalf("Expected %s status, got %s", tc.wantStatus, status)
+				}
+				if tc.wantError == "" && err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if tc.wantError != "" && (err == nil || err.Error() != tc.wantError) {
+					t.Fatalf("Expected error %q, got %v", tc.wantError, err)
+				}
+				if code := DeclineCodeOf(err); code != tc.wantCode {
+					t.Fatalf("Expected decline code %q, got %q", tc.wantCode, code)
+				}
+			}
+		})
+	}
+}
+
+func TestStaticProcessors(t *testing.T) {
+	req := testRequest("4111111111111111")
+
+	status, err := NewAlwaysSucceed().ProcessPayment(context.Background(), req)
+	if err != nil || status != types.StatusSuccess {
+		t.Errorf("Expected SUCCESS, got %s (%v)", status, err)
+	}
+
+	status, err = NewAlwaysFail(types.DeclineInsufficientFunds).ProcessPayment(context.Background(), req)
+	if err == nil || status != types.StatusFailed {
+		t.Errorf("Expected FAILED, got %s (%v)", status, err)
+	}
+	if code := DeclineCodeOf(err); code != types.DeclineInsufficientFunds {
+		t.Errorf("Expected insufficient_funds, got %q", code)
+	}
+}
+
+func TestSimulatorDeclineDistribution(t *testing.T) {
+	processor := NewSimulator(SimulatorConfig{
+		FailureRate: 1,
+		Declines: DeclineDistribution{
+			{Code: types.DeclineInsufficientFunds, Weight: 3},
+			{Code: types.DeclineIssuerUnavailable, Weight: 1},
+			{Code: types.DeclineLostOrStolen, Weight: 0},
+		},
+		Seed: 42,
+	})
+	req := testRequest("4111111111111111")
+
+	counts := make(map[types.DeclineCode]int)
+	for i := 0; i < 400; i++ {
+		status, err := processor.ProcessPayment(context.Background(), req)
+		if status != types.StatusFailed {
+			t.Fatalf("Expected FAILED status with a failure rate of 1, got %s", status)
+		}
+		counts[DeclineCodeOf(err)]++
+	}
+
+	if counts[types.DeclineLostOrStolen] != 0 {
+		t.Errorf("Expected no declines for a zero weight, got %d", counts[types.DeclineLostOrStolen])
+	}
+	if funds, unavailable := counts[types.DeclineInsufficientFunds], counts[types.DeclineIssuerUnavailable]; funds < 2*unavailable || unavailable == 0 {
+		t.Errorf("Expected roughly 3:1 insufficient_funds to issuer_unavailable, got %d:%d", funds, unavailable)
+	}
+}
+
+func TestDeclineCodeOf(t *testing.T) {
+	if code := DeclineCodeOf(nil); code != "" {
+		t.Errorf("Expected no code for a nil error, got %q", code)
+	}
+	if code := DeclineCodeOf(fmt.Errorf("issuer said: %w", Decline(types.DeclineExpiredCard))); code != types.DeclineExpiredCard {
+		t.Errorf("Expected expired_card for a wrapped decline, got %q", code)
+	}
+	if code := DeclineCodeOf(context.DeadlineExceeded); code != types.DeclineProcessingError {
+		t.Errorf("Expected processing_error for a timeout, got %q", code)
+	}
+	if !types.DeclineIssuerUnavailable.Retryable() || types.DeclineInsufficientFunds.Retryable() {
+		t.Error("Expected only temporary failures to be retryable")
+	}
+}
+
+func TestParseDeclineDistribution(t *testing.T) {
+	distribution, err := ParseDeclineDistribution("insufficient_funds=3, do_not_honor=1.5")
+	if err != nil {
+		t.Fatalf("Expected a valid distribution, got %v", err)
+	}
+	want := DeclineDistribution{
+		{Code: types.DeclineInsufficientFunds, Weight: 3},
+		{Code: types.DeclineDoNotHonor, Weight: 1.5},
+	}
+	if len(distribution) != len(want) || distribution[0] != want[0] || distribution[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, distribution)
+	}
+
+	for _, value := range []string{
+		"insufficient_funds",
+		"card_melted=1",
+		"risk_blocked=1",
+		"do_not_honor=-1",
+		"do_not_honor=1,do_not_honor=2",
+		"do_not_honor=0",
+	} {
+		if _, err := ParseDeclineDistribution(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestParseLatencyRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantMin time.Duration
+		wantMax time.Duration
+		wantErr bool
+	}{
+		{value: "400-800", wantMin: 400 * time.Millisecond, wantMax: 800 * time.Millisecond},
+		{value: "250", wantMin: 250 * time.Millisecond, wantMax: 250 * time.Millisecond},
+		{value: "800-400", wantErr: true},
+		{value: "fast", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			gotMin, gotMax, err := ParseLatencyRange(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tc.value)
+				}
+				return
+			}
+			if err != nil || gotMin != tc.wantMin || gotMax != tc.wantMax {
+				t.Errorf("Expected %s-%s, got %s-%s (%v)", tc.wantMin, tc.wantMax, gotMin, gotMax, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+type SimulatorConfig struct {
+	// FailureRate is the probability (0-1) that a non-test card is declined.
+	FailureRate float64
+	// Declines is the mix of decline codes given to declined payments.
+	Declines   DeclineDistribution
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// Seed makes the sequence of outcomes and latencies reproducible.
+	Seed int64
+}
+
+func DefaultSimulatorConfig() SimulatorConfig {
+	return SimulatorConfig{
+		FailureRate: 0.1,
+		Declines:    DefaultDeclineDistribution(),
+		MinLatency:  400 * time.Millisecond,
+		MaxLatency:  800 * time.Millisecond,
+		Seed:        time.Now().UnixNano(),
+	}
+}
+
+// ParseLatencyRange accepts either a single number of milliseconds ("500") or
+// an inclusive range ("400-800").
+func ParseLatencyRange(value string) (time.Duration, time.Duration, error) {
+	minPart, maxPart, isRange := strings.Cut(value, "-")
+	if !isRange {
+		maxPart = minPart
+	}
+
+	minMs, err := strconv.Atoi(strings.TrimSpace(minPart))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latency %q: %w", value, err)
+	}
+	maxMs, err := strconv.Atoi(strings.TrimSpace(maxPart))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latency %q: %w", value, err)
+	}
+	if minMs < 0 || maxMs < minMs {
+		return 0, 0, fmt.Errorf("invalid latency range %q", value)
+	}
+
+	return time.Duration(minMs) * time.Millisecond, time.Duration(maxMs) * time.Millisecond, nil
+}
+
+// Simulator imitates an issuing bank: it waits for a random latency and
+// declines a configurable share of payments. Reserved test cards are always
+// deterministic.
+type Simulator struct {
+	cfg SimulatorConfig
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func NewSimulator(cfg SimulatorConfig) *Simulator {
+	return &Simulator{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+func (s *Simulator) ProcessPayment(ctx context.Context, req types.PaymentRequest) (string, error) {
+	return outcome(s.simulate(ctx, req), types.StatusSuccess)
+}
+
+func (s *Simulator) Authorize(ctx context.Context, req types.PaymentRequest) (string, error) {
+	return outcome(s.simulate(ctx, req), types.StatusAuthorized)
+}
+
+// outcome is the status of a payment that simulate returned err for.
+func outcome(err error, approved string) (string, error) {
+	switch {
+	case errors.Is(err, ErrInterrupted):
+		return types.StatusPending, err
+	case err != nil:
+		return types.StatusFailed, err
+	}
+	return approved, nil
+}
+
+// Capture and Void only talk to the acquirer, so they are quicker than a
+// full authorization and never declined.
+func (s *Simulator) Capture(ctx context.Context, txn types.Transaction, amount decimal.Decimal) error {
+	return sleep(ctx, s.latency()/4)
+}
+
+func (s *Simulator) Void(ctx context.Context, txn types.Transaction) error {
+	return sleep(ctx, s.latency()/4)
+}
+
+func (s *Simulator) simulate(ctx context.Context, req types.PaymentRequest) error {
+	if outcome, ok := testCards[req.CardNumber]; ok {
+		if err := sleep(ctx, outcome.delay); err != nil {
+			return fmt.Errorf("%w: %w", ErrInterrupted, err)
+		}
+		if outcome.decline != "" {
+			return Decline(outcome.decline)
+		}
+		return nil
+	}
+
+	if err := sleep(ctx, s.latency()); err != nil {
+		return fmt.Errorf("%w: %w", ErrInterrupted, err)
+	}
+
+	if s.float64() < s.cfg.FailureRate {
+		return Decline(s.cfg.Declines.pick(s.float64()))
+	}
+	return nil
+}
+
+func (s *Simulator) latency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spread := s.cfg.MaxLatency - s.cfg.MinLatency
+	if spread <= 0 {
+		return s.cfg.MinLatency
+	}
+	return s.cfg.MinLatency + time.Duration(s.rng.Int63n(int64(spread)+1))
+}
+
+func (s *Simulator) float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rng.Float64()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
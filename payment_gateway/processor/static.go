@@ -0,0 +1,45 @@
+package processor
+
+import (
+	"context"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+// StaticProcessor answers instantly with a fixed outcome. It is meant for
+// tests and local development where the simulator's randomness gets in the
+// way.
+type StaticProcessor struct {
+	decline types.DeclineCode
+}
+
+func NewAlwaysSucceed() *StaticProcessor {
+	return &StaticProcessor{}
+}
+
+func NewAlwaysFail(decline types.DeclineCode) *StaticProcessor {
+	return &StaticProcessor{decline: decline}
+}
+
+func (p *StaticProcessor) ProcessPayment(ctx context.Context, req types.PaymentRequest) (string, error) {
+	if p.decline != "" {
+		return types.StatusFailed, Decline(p.decline)
+	}
+	return types.StatusSuccess, nil
+}
+
+func (p *StaticProcessor) Authorize(ctx context.Context, req types.PaymentRequest) (string, error) {
+	if p.decline != "" {
+		return types.StatusFailed, Decline(p.decline)
+	}
+	return types.StatusAuthorized, nil
+}
+
+func (p *StaticProcessor) Capture(ctx context.Context, txn types.Transaction, amount decimal.Decimal) error {
+	return nil
+}
+
+func (p *StaticProcessor) Void(ctx context.Context, txn types.Transaction) error {
+	return nil
+}
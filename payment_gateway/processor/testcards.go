@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+// Reserved card numbers that always produce the same outcome, so integration
+// tests can exercise approvals, declines and timeouts on purpose. Any other
+// card number keeps the random simulated behaviour.
+const (
+	TestCardApproved          = "4242424242424242"
+	TestCardDeclined          = "4000000000000002"
+	TestCardInsufficientFunds = "4000000000009995"
+	TestCardExpired           = "4000000000000069"
+	TestCardProcessingError   = "4000000000000119"
+	TestCardIncorrectCVV      = "4000000000000127"
+	TestCardLostOrStolen      = "4000000000009987"
+	TestCardSuspectedFraud    = "4100000000000019"
+	TestCardIssuerUnavailable = "4000000000000341"
+	TestCardSlowResponse      = "4000000000000044"
+	// TestCardThreeDSChallenge always asks for 3-D Secure and is approved
+	// once the challenge has been passed.
+	TestCardThreeDSChallenge = "4000000000003220"
+)
+
+const slowResponseDelay = 5 * time.Second
+
+type testCardOutcome struct {
+	decline types.DeclineCode
+	delay   time.Duration
+}
+
+var testCards = map[string]testCardOutcome{
+	TestCardApproved:          {},
+	TestCardDeclined:          {decline: types.DeclineDoNotHonor},
+	TestCardInsufficientFunds: {decline: types.DeclineInsufficientFunds},
+	TestCardExpired:           {decline: types.DeclineExpiredCard},
+	TestCardProcessingError:   {decline: types.DeclineProcessingError},
+	TestCardIncorrectCVV:      {decline: types.DeclineIncorrectCVV},
+	TestCardLostOrStolen:      {decline: types.DeclineLostOrStolen},
+	TestCardSuspectedFraud:    {decline: types.DeclineSuspectedFraud},
+	TestCardIssuerUnavailable: {decline: types.DeclineIssuerUnavailable},
+	TestCardSlowResponse:      {delay: slowResponseDelay},
+	TestCardThreeDSChallenge:  {},
+}
+
+func IsTestCard(cardNumber string) bool {
+	_, ok := testCards[cardNumber]
+	return ok
+}
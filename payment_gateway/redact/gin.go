@@ -0,0 +1,77 @@
+package redact
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// GinLogger replaces gin's default access log. Requests are written through
+// logger, so its Hook applies, and sensitive query parameters are redacted
+// before the path is logged.
+func GinLogger(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		query := c.Request.URL.RawQuery
+
+		c.Next()
+
+		if query != "" {
+			path += "?" + Query(query)
+		}
+
+		entry := logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"status":     c.Writer.Status(),
+			"method":     c.Request.Method,
+			"path":       path,
+			"client_ip":  c.ClientIP(),
+			"latency_ms": time.Since(start).Milliseconds(),
+			"user_agent": c.Request.UserAgent(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			entry.Error("Request handled")
+		case status >= 400:
+			entry.Warn("Request handled")
+		default:
+			entry.Info("Request handled")
+		}
+	}
+}
+
+// Query redacts sensitive parameters in a raw query string. Masked values
+// are written unescaped so they stay readable in the log.
+func Query(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return String(rawQuery)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, key := range keys {
+		for _, value := range values[key] {
+			if IsSensitiveKey(key) {
+				value = Value(key, value)
+			} else {
+				value = url.QueryEscape(String(value))
+			}
+			params = append(params, url.QueryEscape(key)+"="+value)
+		}
+	}
+	return strings.Join(params, "&")
+}
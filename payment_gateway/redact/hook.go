@@ -0,0 +1,70 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hook scrubs card data from every log entry before it is formatted. Add it
+// to a logger with AddHook and every field, error and message written
+// through that logger is covered.
+type Hook struct{}
+
+func NewHook() *Hook {
+	return &Hook{}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = String(entry.Message)
+
+	// entry.Data is a private copy for this entry, so fields can be
+	// replaced without touching the logger the entry was created from.
+	for key, value := range entry.Data {
+		entry.Data[key] = field(key, value)
+	}
+	return nil
+}
+
+func field(key string, value any) any {
+	switch v := value.(type) {
+	case nil, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		if IsSensitiveKey(key) && v != nil {
+			return Value(key, fmt.Sprint(v))
+		}
+		return v
+	case string:
+		if IsSensitiveKey(key) {
+			return Value(key, v)
+		}
+		return String(v)
+	case error:
+		return String(v.Error())
+	case fmt.Stringer:
+		if IsSensitiveKey(key) {
+			return Value(key, v.String())
+		}
+		return String(v.String())
+	}
+
+	if IsSensitiveKey(key) {
+		return Redacted
+	}
+
+	// Structs, maps and slices are checked in their JSON form, which is how
+	// the formatter would write them. Anything that needed scrubbing is
+	// logged as the scrubbed JSON text instead.
+	data, err := json.Marshal(value)
+	if err != nil {
+		return String(fmt.Sprintf("%+v", value))
+	}
+	if scrubbed := String(string(data)); scrubbed != string(data) {
+		return json.RawMessage(scrubbed)
+	}
+	return value
+}
@@ -0,0 +1,12 @@
+package redact
+
+import "strings"
+
+// CardNumber masks everything but the first six and last four digits of a
+// PAN. Numbers too short to keep both ends are masked entirely.
+func CardNumber(cardNumber string) string {
+	if len(cardNumber) < 13 {
+		return strings.Repeat("*", len(cardNumber))
+	}
+	return cardNumber[:6] + strings.Repeat("*", len(cardNumber)-10) + cardNumber[len(cardNumber)-4:]
+}
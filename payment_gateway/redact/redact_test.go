@@ -0,0 +1,184 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPAN    = "4242424242424242"
+	testMasked = "424242******4242"
+)
+
+func TestCardNumber(t *testing.T) {
+	tests := []struct {
+		name       string
+		cardNumber string
+		want       string
+	}{
+		{name: "SixteenDigits", cardNumber: "4242424242424242", want: "424242******4242"},
+		{name: "FifteenDigits", cardNumber: "378282246310005", want: "378282*****0005"},
+		{name: "TooShort", cardNumber: "123456", want: "******"},
+		{name: "Empty", cardNumber: "", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, CardNumber(tc.cardNumber))
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "BarePAN", input: "charging " + testPAN + " now", want: "charging " + testMasked + " now"},
+		{name: "GroupedPAN", input: "card 4242 4242 4242 4242", want: "card " + testMasked},
+		{name: "DashedPAN", input: "card 4242-4242-4242-4242", want: "card " + testMasked},
+		{name: "NotLuhn", input: "order 4242424242424241", want: "order 4242424242424241"},
+		{name: "JSONBody", input: `{"card_number":"4242424242424241","cvv":"123","expiry":"12/30","name":"John"}`, want: `{"card_number":"424242******4241","cvv":"[REDACTED]","expiry":"[REDACTED]","name":"John"}`},
+		{name: "FormBody", input: "cvv=123&expiry=12%2F30&name=John", want: "cvv=[REDACTED]&expiry=[REDACTED]&name=John"},
+		{name: "StructDump", input: "{CardNumber:4242424242424242 CVV:123}", want: "{CardNumber:" + testMasked + " CVV:[REDACTED]}"},
+		{name: "AlreadyMasked", input: `{"card_number":"` + testMasked + `","cvv":"[REDACTED]"}`, want: `{"card_number":"` + testMasked + `","cvv":"[REDACTED]"}`},
+		{name: "WordContainingKey", input: "japan: 2 tickets", want: "japan: 2 tickets"},
+		{name: "Plain", input: "Transaction completed successfully", want: "Transaction completed successfully"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, String(tc.input))
+		})
+	}
+}
+
+func newTestLogger() (*logrus.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(&buf)
+	logger.AddHook(NewHook())
+	return logger, &buf
+}
+
+type testRequest struct {
+	CardNumber string `json:"card_number"`
+	CVV        string `json:"cvv"`
+	Expiry     string `json:"expiry"`
+	Name       string `json:"name"`
+}
+
+func TestHook(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(logger *logrus.Logger)
+	}{
+		{name: "SensitiveFields", log: func(logger *logrus.Logger) {
+			logger.WithFields(logrus.Fields{"card_number": testPAN, "cvv": "123", "expiry": "12/30"}).Info("payment")
+		}},
+		{name: "NumericCVV", log: func(logger *logrus.Logger) {
+			logger.WithField("cvv", 123).Info("payment")
+		}},
+		{name: "Message", log: func(logger *logrus.Logger) {
+			logger.Infof("charging card %s with cvv=123 expiry=12/30", testPAN)
+		}},
+		{name: "Error", log: func(logger *logrus.Logger) {
+			logger.WithError(errors.New(`bad body {"card_number":"` + testPAN + `","cvv":"123","expiry":"12/30"}`)).Warn("Invalid request format")
+		}},
+		{name: "OtherField", log: func(logger *logrus.Logger) {
+			logger.WithField("body", `{"card_number":"`+testPAN+`","cvv":"123","expiry":"12/30"}`).Info("request")
+		}},
+		{name: "Struct", log: func(logger *logrus.Logger) {
+			logger.WithField("request", testRequest{CardNumber: testPAN, CVV: "123", Expiry: "12/30", Name: "John"}).Info("request")
+		}},
+		{name: "Slice", log: func(logger *logrus.Logger) {
+			logger.WithField("cards", []string{testPAN}).Info("request")
+		}},
+		{name: "WholeStructUnderSensitiveKey", log: func(logger *logrus.Logger) {
+			logger.WithField("cvv", []string{"123"}).Info("request")
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, buf := newTestLogger()
+			tc.log(logger)
+
+			output := buf.String()
+			require.NotEmpty(t, output)
+			assert.NotContains(t, output, testPAN)
+			assert.NotContains(t, output, "123\"")
+			assert.NotContains(t, output, "12/30")
+
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), "output must stay valid JSON")
+		})
+	}
+}
+
+func TestHookMasksPAN(t *testing.T) {
+	logger, buf := newTestLogger()
+	logger.WithField("card_number", testPAN).Info("payment")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, testMasked, entry["card_number"])
+}
+
+func TestHookLeavesOtherFieldsAlone(t *testing.T) {
+	logger, buf := newTestLogger()
+	logger.WithFields(logrus.Fields{
+		"transaction_id": "6f343fc1-d932-4e18-b0d5-985a2983cebd",
+		"amount":         "24.23",
+		"status_code":    200,
+		"masked_card":    testMasked,
+	}).Info("Transaction completed successfully")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "6f343fc1-d932-4e18-b0d5-985a2983cebd", entry["transaction_id"])
+	assert.Equal(t, "24.23", entry["amount"])
+	assert.Equal(t, float64(200), entry["status_code"])
+	assert.Equal(t, testMasked, entry["masked_card"])
+	assert.Equal(t, "Transaction completed successfully", entry["msg"])
+}
+
+func TestGinLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger, buf := newTestLogger()
+
+	router := gin.New()
+	router.Use(GinLogger(logger))
+	router.GET("/payment", func(c *gin.Context) {
+		c.Error(errors.New("lookup failed for " + testPAN))
+		c.Status(http.StatusBadRequest)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/payment?card_number="+testPAN+"&cvv=123&expiry=12/30&note=card+"+testPAN+"&page=2", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	output := buf.String()
+	assert.NotContains(t, output, testPAN)
+	assert.NotContains(t, output, "cvv=123")
+	assert.NotContains(t, output, "12/30")
+	assert.NotContains(t, output, "12%2F30")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "warning", entry["level"])
+	assert.Equal(t, float64(http.StatusBadRequest), entry["status"])
+	assert.Contains(t, entry["path"], "page=2")
+	assert.Equal(t, "/payment?card_number="+testMasked+"&cvv=[REDACTED]&expiry=[REDACTED]&note=card+424242%2A%2A%2A%2A%2A%2A4242&page=2", entry["path"])
+	assert.Contains(t, entry["errors"], testMasked)
+}
@@ -0,0 +1,101 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces values that must not be logged even in part.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are field names whose values are never logged as-is.
+// Card numbers are masked, everything else is replaced with Redacted.
+var sensitiveKeys = map[string]bool{
+	"card_number": true,
+	"cardnumber":  true,
+	"pan":         true,
+	"cvv":         true,
+	"cvc":         true,
+	"expiry":      true,
+}
+
+var (
+	// panPattern finds 12-19 digit runs and card numbers typed in groups of
+	// four separated by spaces or dashes.
+	panPattern = regexp.MustCompile(`\b(?:\d{4}(?:[ -]\d{4}){2,3}|\d{12,19})\b`)
+	// keyValuePattern finds sensitive keys written out inside text, as JSON
+	// ("cvv":"123"), form or query values (cvv=123) or struct dumps (cvv:123).
+	maskedPattern   = regexp.MustCompile(`^\d{0,6}\*+\d{0,4}$`)
+	keyValuePattern = regexp.MustCompile(`(?i)("?\b(?:card_number|cardnumber|pan|cvv|cvc|expiry)\b"?\s*[:=]\s*"?)([^"&,\s}]*)`)
+)
+
+// IsSensitiveKey reports whether a field or parameter name holds card data.
+func IsSensitiveKey(key string) bool {
+	return sensitiveKeys[strings.ToLower(key)]
+}
+
+// Value returns how the value of a sensitive field is logged. Values that are
+// already masked or redacted are returned unchanged, so scrubbing twice is
+// harmless.
+func Value(key, value string) string {
+	if value == Redacted {
+		return value
+	}
+	switch strings.ToLower(key) {
+	case "card_number", "cardnumber", "pan":
+		if maskedPattern.MatchString(value) {
+			return value
+		}
+		if digits := digitsOnly(value); digits != "" {
+			return CardNumber(digits)
+		}
+		return Redacted
+	default:
+		return Redacted
+	}
+}
+
+// String scrubs free text: values of sensitive keys are removed and any
+// number that looks like a real PAN is masked to first6/last4.
+func String(s string) string {
+	s = keyValuePattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := keyValuePattern.FindStringSubmatch(match)
+		if parts[2] == "" {
+			return match
+		}
+		key := strings.Trim(strings.TrimRight(parts[1], `:="' `), `"`)
+		return parts[1] + Value(key, parts[2])
+	})
+	return panPattern.ReplaceAllStringFunc(s, func(match string) string {
+		digits := digitsOnly(match)
+		if !isLuhn(digits) {
+			return match
+		}
+		return CardNumber(digits)
+	})
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isLuhn(digits string) bool {
+	var sum int
+	for i := 0; i < len(digits); i++ {
+		n := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return len(digits) > 0 && sum%10 == 0
+}
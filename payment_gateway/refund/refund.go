@@ -0,0 +1,69 @@
+package refund
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/lifecycle"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+var (
+	ErrNotRefundable   = errors.New("transaction is not in a refundable state")
+	ErrInvalidAmount   = errors.New("invalid refund amount")
+	ErrExceedsCaptured = errors.New("refund amount exceeds the remaining captured amount")
+)
+
+// Apply records a refund against txn. A nil amount refunds whatever has not
+// been refunded yet. The cumulative refunded amount never exceeds the amount
+// originally captured, and an amount must fit the currency's minor units.
+func Apply(txn *types.Transaction, refund types.Refund, amount *decimal.Decimal) (types.Refund, error) {
+	if !lifecycle.CanTransition(txn.Status, types.StatusPartiallyRefunded) {
+		return types.Refund{}, ErrNotRefundable
+	}
+
+	remaining, err := txn.CapturedAmount.Sub(txn.RefundedAmount)
+	if err != nil {
+		return types.Refund{}, err
+	}
+
+	refund.Amount = remaining
+	if amount != nil {
+		refund.Amount = *amount
+	}
+	if !refund.Amount.IsPos() {
+		return types.Refund{}, fmt.Errorf("%w: must be greater than zero", ErrInvalidAmount)
+	}
+	if minorUnits, ok := currency.MinorUnits(txn.Currency); ok {
+		if refund.Amount.MinScale() > minorUnits {
+			return types.Refund{}, fmt.Errorf("%w: must have at most %d decimal places for %s", ErrInvalidAmount, minorUnits, txn.Currency)
+		}
+		refund.Amount = refund.Amount.Trim(minorUnits).Pad(minorUnits)
+	}
+	if refund.Amount.Cmp(remaining) > 0 {
+		return types.Refund{}, ErrExceedsCaptured
+	}
+
+	refunded, err := txn.RefundedAmount.Add(refund.Amount)
+	if err != nil {
+		return types.Refund{}, err
+	}
+
+	if refund.CreatedAt.IsZero() {
+		refund.CreatedAt = time.Now()
+	}
+
+	txn.RefundedAmount = refunded
+	txn.Refunds = append(txn.Refunds, refund)
+	txn.UpdatedAt = refund.CreatedAt
+	if refunded.Cmp(txn.CapturedAmount) == 0 {
+		txn.Status = types.StatusRefunded
+	} else {
+		txn.Status = types.StatusPartiallyRefunded
+	}
+
+	return refund, nil
+}
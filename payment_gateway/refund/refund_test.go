@@ -0,0 +1,128 @@
+package refund
+
+import (
+	"testing"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecimal(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(s)
+	require.NoError(t, err)
+	return d
+}
+
+func successfulTransaction(t *testing.T) types.Transaction {
+	return types.Transaction{
+		TransactionID:  "txn-1",
+		Amount:         mustDecimal(t, "100.00"),
+		CapturedAmount: mustDecimal(t, "100.00"),
+		Currency:       "USD",
+		Status:         types.StatusSuccess,
+	}
+}
+
+func TestFullRefund(t *testing.T) {
+	txn := successfulTransaction(t)
+
+	refund, err := Apply(&txn, types.Refund{RefundID: "ref-1"}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "100.00", refund.Amount.String())
+	assert.Equal(t, types.StatusRefunded, txn.Status)
+	assert.Equal(t, "100.00", txn.RefundedAmount.String())
+	assert.Len(t, txn.Refunds, 1)
+}
+
+func TestPartialRefunds(t *testing.T) {
+	txn := successfulTransaction(t)
+
+	first := mustDecimal(t, "30.00")
+	_, err := Apply(&txn, types.Refund{RefundID: "ref-1"}, &first)
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusPartiallyRefunded, txn.Status)
+
+	tooMuch := mustDecimal(t, "70.01")
+	_, err = Apply(&txn, types.Refund{RefundID: "ref-2"}, &tooMuch)
+	assert.ErrorIs(t, err, ErrExceedsCaptured)
+	assert.Len(t, txn.Refunds, 1)
+
+	refund, err := Apply(&txn, types.Refund{RefundID: "ref-3"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "70.00", refund.Amount.String())
+	assert.Equal(t, types.StatusRefunded, txn.Status)
+	assert.Len(t, txn.Refunds, 2)
+
+	_, err = Apply(&txn, types.Refund{RefundID: "ref-4"}, nil)
+	assert.ErrorIs(t, err, ErrNotRefundable)
+}
+
+func TestInvalidRefundAmount(t *testing.T) {
+	txn := successfulTransaction(t)
+
+	for _, value := range []string{"0", "-5"} {
+		amount := mustDecimal(t, value)
+		_, err := Apply(&txn, types.Refund{}, &amount)
+		assert.ErrorIs(t, err, ErrInvalidAmount, value)
+	}
+}
+
+func TestRefundAmountMustFitCurrency(t *testing.T) {
+	txn := successfulTransaction(t)
+
+	tooPrecise := mustDecimal(t, "10.005")
+	_, err := Apply(&txn, types.Refund{}, &tooPrecise)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	assert.Empty(t, txn.Refunds)
+
+	// Trailing zeros are not extra precision
+	whole := mustDecimal(t, "10.000")
+	refund, err := Apply(&txn, types.Refund{}, &whole)
+	require.NoError(t, err)
+	assert.Equal(t, "10.00", refund.Amount.String())
+
+	yen := types.Transaction{
+		Amount:         mustDecimal(t, "1000"),
+		CapturedAmount: mustDecimal(t, "1000"),
+		Currency:       "JPY",
+		Status:         types.StatusSuccess,
+	}
+	fraction := mustDecimal(t, "0.50")
+	_, err = Apply(&yen, types.Refund{}, &fraction)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
+
+func TestFailedTransactionNotRefundable(t *testing.T) {
+	txn := successfulTransaction(t)
+	txn.Status = types.StatusFailed
+
+	_, err := Apply(&txn, types.Refund{}, nil)
+	assert.ErrorIs(t, err, ErrNotRefundable)
+}
+
+func TestRefundLimitedToCapturedAmount(t *testing.T) {
+	txn := successfulTransaction(t)
+	txn.Status = types.StatusCaptured
+	txn.CapturedAmount = mustDecimal(t, "40.00")
+
+	tooMuch := mustDecimal(t, "40.01")
+	_, err := Apply(&txn, types.Refund{}, &tooMuch)
+	assert.ErrorIs(t, err, ErrExceedsCaptured)
+
+	refund, err := Apply(&txn, types.Refund{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "40.00", refund.Amount.String())
+	assert.Equal(t, types.StatusRefunded, txn.Status)
+}
+
+func TestAuthorizedTransactionNotRefundable(t *testing.T) {
+	txn := successfulTransaction(t)
+	txn.Status = types.StatusAuthorized
+
+	_, err := Apply(&txn, types.Refund{}, nil)
+	assert.ErrorIs(t, err, ErrNotRefundable)
+}
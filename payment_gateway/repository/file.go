@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/atomicfile"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+// FileRepository keeps transactions in memory and writes the full set to a
+// JSON file after every change. The file is replaced atomically so a crash
+// mid-write never leaves a truncated store behind.
+type FileRepository struct {
+	mu     sync.Mutex
+	path   string
+	memory *MemoryRepository
+}
+
+func NewFileRepository(path string) (*FileRepository, error) {
+	repo := &FileRepository{
+		path:   path,
+		memory: NewMemoryRepository(),
+	}
+
+	if err := repo.load(); err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func (r *FileRepository) load() error {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read transaction store: %w", err)
+	}
+
+	var transactions []types.Transaction
+	if err := json.Unmarshal(data, &transactions); err != nil {
+		return fmt.Errorf("failed to parse transaction store: %w", err)
+	}
+	for _, txn := range transactions {
+		r.memory.transactions[txn.TransactionID] = txn
+	}
+	return nil
+}
+
+// Save writes the store with txn in it before keeping txn in memory, so a
+// failed write leaves both as they were.
+func (r *FileRepository) Save(txn types.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.persist(txn); err != nil {
+		return err
+	}
+	return r.memory.Save(txn)
+}
+
+func (r *FileRepository) FindByID(transactionID string) (types.Transaction, error) {
+	return r.memory.FindByID(transactionID)
+}
+
+func (r *FileRepository) List() ([]types.Transaction, error) {
+	return r.memory.List()
+}
+
+// Update works on a copy of the stored transaction and only keeps it once
+// the store is written. Writers hold r.mu, so nothing changes the
+// transaction between reading and saving it.
+func (r *FileRepository) Update(transactionID string, fn func(txn *types.Transaction) error) (types.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	txn, err := r.memory.FindByID(transactionID)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	txn.Refunds = append([]types.Refund(nil), txn.Refunds...)
+	if err := fn(&txn); err != nil {
+		return types.Transaction{}, err
+	}
+	if err := r.persist(txn); err != nil {
+		return types.Transaction{}, err
+	}
+	return txn, r.memory.Save(txn)
+}
+
+// persist writes the stored transactions with changed in place of the
+// stored copy, or added when it is new.
+func (r *FileRepository) persist(changed types.Transaction) error {
+	transactions, err := r.memory.List()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range transactions {
+		if transactions[i].TransactionID == changed.TransactionID {
+			transactions[i] = changed
+			replaced = true
+		}
+	}
+	if !replaced {
+		transactions = append(transactions, changed)
+	}
+
+	data, err := json.Marshal(transactions)
+	if err != nil {
+		return fmt.Errorf("failed to encode transaction store: %w", err)
+	}
+
+	if err := atomicfile.Write(r.path, data, 0o755); err != nil {
+		return fmt.Errorf("failed to write transaction store: %w", err)
+	}
+	return nil
+}
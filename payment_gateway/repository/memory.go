@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+type MemoryRepository struct {
+	mu           sync.RWMutex
+	transactions map[string]types.Transaction
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		transactions: make(map[string]types.Transaction),
+	}
+}
+
+func (r *MemoryRepository) Save(txn types.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transactions[txn.TransactionID] = txn
+	return nil
+}
+
+func (r *MemoryRepository) FindByID(transactionID string) (types.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	txn, ok := r.transactions[transactionID]
+	if !ok {
+		return types.Transaction{}, ErrNotFound
+	}
+	return txn, nil
+}
+
+func (r *MemoryRepository) Update(transactionID string, fn func(txn *types.Transaction) error) (types.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	txn, ok := r.transactions[transactionID]
+	if !ok {
+		return types.Transaction{}, ErrNotFound
+	}
+	txn.Refunds = append([]types.Refund(nil), txn.Refunds...)
+	if err := fn(&txn); err != nil {
+		return types.Transaction{}, err
+	}
+	r.transactions[transactionID] = txn
+	return txn, nil
+}
+
+func (r *MemoryRepository) List() ([]types.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transactions := make([]types.Transaction, 0, len(r.transactions))
+	for _, txn := range r.transactions {
+		transactions = append(transactions, txn)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+	return transactions, nil
+}
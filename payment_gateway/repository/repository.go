@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+var ErrNotFound = errors.New("transaction not found")
+
+type TransactionRepository interface {
+	Save(txn types.Transaction) error
+	FindByID(transactionID string) (types.Transaction, error)
+	// List returns every stored transaction ordered by creation time.
+	List() ([]types.Transaction, error)
+	// Update applies fn to the stored transaction atomically. When fn returns
+	// an error the stored transaction is left untouched.
+	Update(transactionID string, fn func(txn *types.Transaction) error) (types.Transaction, error)
+}
+
+// New returns the repository backend selected by kind. An empty kind selects
+// the in-memory backend; "file" persists transactions to path.
+func New(kind, path string) (TransactionRepository, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryRepository(), nil
+	case "file":
+		return NewFileRepository(path)
+	default:
+		return nil, fmt.Errorf("unknown transaction store %q", kind)
+	}
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleTransaction(id string) types.Transaction {
+	amount, _ := decimal.NewFromFloat64(24.23)
+	now := time.Now().UTC().Truncate(time.Second)
+	return types.Transaction{
+		TransactionID:  id,
+		RequestID:      "req-" + id,
+		CardholderName: "John Doe",
+		MaskedCard:     "424242******4242",
+		Amount:         amount,
+		Status:         "SUCCESS",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	fileRepo, err := NewFileRepository(filepath.Join(t.TempDir(), "transactions.json"))
+	require.NoError(t, err)
+
+	repos := map[string]TransactionRepository{
+		"Memory": NewMemoryRepository(),
+		"File":   fileRepo,
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			_, err := repo.FindByID("missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			txn := sampleTransaction("txn-1")
+			require.NoError(t, repo.Save(txn))
+
+			found, err := repo.FindByID("txn-1")
+			require.NoError(t, err)
+			assert.Equal(t, txn, found)
+
+			txn.Status = "FAILED"
+			require.NoError(t, repo.Save(txn))
+
+			found, err = repo.FindByID("txn-1")
+			require.NoError(t, err)
+			assert.Equal(t, "FAILED", found.Status)
+
+			updated, err := repo.Update("txn-1", func(txn *types.Transaction) error {
+				txn.Status = "REFUNDED"
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "REFUNDED", updated.Status)
+
+			_, err = repo.Update("txn-1", func(txn *types.Transaction) error {
+				txn.Status = "SUCCESS"
+				return errors.New("rejected")
+			})
+			assert.Error(t, err)
+
+			found, err = repo.FindByID("txn-1")
+			require.NoError(t, err)
+			assert.Equal(t, "REFUNDED", found.Status)
+
+			all, err := repo.List()
+			require.NoError(t, err)
+			assert.Len(t, all, 1)
+
+			_, err = repo.Update("missing", func(txn *types.Transaction) error { return nil })
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestFileRepositoryPersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "transactions.json")
+
+	repo, err := NewFileRepository(path)
+	require.NoError(t, err)
+	require.NoError(t, repo.Save(sampleTransaction("txn-1")))
+
+	reopened, err := NewFileRepository(path)
+	require.NoError(t, err)
+
+	found, err := reopened.FindByID("txn-1")
+	require.NoError(t, err)
+	assert.Equal(t, "SUCCESS", found.Status)
+	assert.Equal(t, "424242******4242", found.MaskedCard)
+}
+
+func TestFileRepositoryWriteFailureKeepsMemory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	repo, err := NewFileRepository(filepath.Join(dir, "transactions.json"))
+	require.NoError(t, err)
+	require.NoError(t, repo.Save(sampleTransaction("txn-1")))
+
+	// A file where the store's directory should be makes every write fail
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, os.WriteFile(dir, nil, 0o600))
+
+	assert.Error(t, repo.Save(sampleTransaction("txn-2")))
+	_, err = repo.FindByID("txn-2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = repo.Update("txn-1", func(txn *types.Transaction) error {
+		txn.Status = "FAILED"
+		return nil
+	})
+	assert.Error(t, err)
+	found, err := repo.FindByID("txn-1")
+	require.NoError(t, err)
+	assert.Equal(t, "SUCCESS", found.Status)
+}
+
+func TestNewUnknownBackend(t *testing.T) {
+	_, err := New("postgres", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package risk
+
+import (
+	"sync"
+	"time"
+
+	"github.com/govalues/decimal"
+)
+
+// maxAmounts bounds the amounts kept per caller and currency for outlier
+// detection.
+const maxAmounts = 50
+
+// History holds recent attempts in memory. Attempts older than the window
+// are dropped as new ones arrive.
+type History struct {
+	mu       sync.Mutex
+	window   time.Duration
+	attempts []Attempt
+	amounts  map[string][]decimal.Decimal
+}
+
+func NewHistory(window time.Duration) *History {
+	return &History{
+		window:  window,
+		amounts: make(map[string][]decimal.Decimal),
+	}
+}
+
+func (h *History) Add(attempt Attempt) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := attempt.At.Add(-h.window)
+	kept := h.attempts[:0]
+	for _, existing := range h.attempts {
+		if existing.At.After(cutoff) {
+			kept = append(kept, existing)
+		}
+	}
+	h.attempts = append(kept, attempt)
+
+	key := amountKey(attempt.Caller, attempt.Currency)
+	amounts := append(h.amounts[key], attempt.Amount)
+	if len(amounts) > maxAmounts {
+		amounts = amounts[len(amounts)-maxAmounts:]
+	}
+	h.amounts[key] = amounts
+}
+
+func (h *History) SetDeclined(id string, declined bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.attempts {
+		if h.attempts[i].ID == id {
+			h.attempts[i].Declined = declined
+			return
+		}
+	}
+}
+
+// Within returns the attempts made in the window ending at now that match.
+func (h *History) Within(now time.Time, window time.Duration, match func(Attempt) bool) []Attempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := now.Add(-window)
+	var matched []Attempt
+	for _, attempt := range h.attempts {
+		if attempt.At.After(cutoff) && !attempt.At.After(now) && match(attempt) {
+			matched = append(matched, attempt)
+		}
+	}
+	return matched
+}
+
+// Amounts returns the caller's recent amounts in currency, oldest first.
+func (h *History) Amounts(caller, currency string) []decimal.Decimal {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]decimal.Decimal(nil), h.amounts[amountKey(caller, currency)]...)
+}
+
+func amountKey(caller, currency string) string {
+	return caller + ":" + currency
+}
@@ -0,0 +1,153 @@
+package risk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+const (
+	DecisionAllow  = "ALLOW"
+	DecisionReview = "REVIEW"
+	DecisionBlock  = "BLOCK"
+)
+
+const maxScore = 100
+
+// Attempt is a payment as the risk engine sees it. The card is identified by
+// a fingerprint so the history never holds card numbers.
+type Attempt struct {
+	ID       string
+	Card     string
+	IP       string
+	Caller   string
+	Name     string
+	Amount   decimal.Decimal
+	Currency string
+	At       time.Time
+	Declined bool
+}
+
+func NewAttempt(id, caller, ip string, req types.PaymentRequest, now time.Time) Attempt {
+	return Attempt{
+		ID:       id,
+		Card:     Fingerprint(req.CardNumber),
+		IP:       ip,
+		Caller:   caller,
+		Name:     strings.ToLower(strings.Join(strings.Fields(req.Name), " ")),
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		At:       now,
+	}
+}
+
+func Fingerprint(cardNumber string) string {
+	sum := sha256.Sum256([]byte(cardNumber))
+	return hex.EncodeToString(sum[:])
+}
+
+// Rule is a single fraud signal. Evaluate returns the points the attempt
+// scores on this rule, and a reason when the score is above zero. The
+// attempt being evaluated is already part of history.
+type Rule interface {
+	Name() string
+	Evaluate(attempt Attempt, history *History) (int, string)
+}
+
+type Config struct {
+	// Window is how far back velocity, decline and name rules look.
+	Window         time.Duration
+	CardAttempts   int
+	IPAttempts     int
+	CallerAttempts int
+	Declines       int
+	CardNames      int
+	// OutlierFactor flags amounts this many times the caller's average.
+	OutlierFactor float64
+	ReviewScore   int
+	BlockScore    int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Window:         10 * time.Minute,
+		CardAttempts:   5,
+		IPAttempts:     20,
+		CallerAttempts: 1000,
+		Declines:       3,
+		CardNames:      2,
+		OutlierFactor:  10,
+		ReviewScore:    50,
+		BlockScore:     80,
+	}
+}
+
+// DefaultRules builds the standard rule set from cfg.
+func DefaultRules(cfg Config) []Rule {
+	return []Rule{
+		&VelocityRule{RuleName: "card_velocity", Key: byCard, Limit: cfg.CardAttempts, Window: cfg.Window, Score: 40},
+		&VelocityRule{RuleName: "ip_velocity", Key: byIP, Limit: cfg.IPAttempts, Window: cfg.Window, Score: 30},
+		&VelocityRule{RuleName: "caller_velocity", Key: byCaller, Limit: cfg.CallerAttempts, Window: cfg.Window, Score: 20},
+		&DeclineRule{Limit: cfg.Declines, Window: cfg.Window, Score: 50},
+		&AmountOutlierRule{Factor: cfg.OutlierFactor, MinHistory: 5, Score: 30},
+		&NameMismatchRule{Limit: cfg.CardNames, Window: cfg.Window, Score: 40},
+		&NamePatternRule{Score: 20},
+	}
+}
+
+// Engine scores payments against its rules and keeps the history the rules
+// need. It is safe for concurrent use.
+type Engine struct {
+	rules       []Rule
+	history     *History
+	reviewScore int
+	blockScore  int
+}
+
+// NewEngine returns an engine using cfg's thresholds. Without rules it uses
+// DefaultRules(cfg).
+func NewEngine(cfg Config, rules ...Rule) *Engine {
+	if len(rules) == 0 {
+		rules = DefaultRules(cfg)
+	}
+	return &Engine{
+		rules:       rules,
+		history:     NewHistory(cfg.Window),
+		reviewScore: cfg.ReviewScore,
+		blockScore:  cfg.BlockScore,
+	}
+}
+
+// Assess records attempt and scores it.
+func (e *Engine) Assess(attempt Attempt) types.RiskAssessment {
+	e.history.Add(attempt)
+
+	assessment := types.RiskAssessment{Decision: DecisionAllow}
+	for _, rule := range e.rules {
+		score, reason := rule.Evaluate(attempt, e.history)
+		if score <= 0 {
+			continue
+		}
+		assessment.Score += score
+		assessment.Reasons = append(assessment.Reasons, rule.Name()+": "+reason)
+	}
+	assessment.Score = min(assessment.Score, maxScore)
+
+	switch {
+	case assessment.Score >= e.blockScore:
+		assessment.Decision = DecisionBlock
+	case assessment.Score >= e.reviewScore:
+		assessment.Decision = DecisionReview
+	}
+	return assessment
+}
+
+// RecordOutcome tells the engine how an assessed attempt ended, so declines
+// count towards later decisions.
+func (e *Engine) RecordOutcome(id string, declined bool) {
+	e.history.SetDeclined(id, declined)
+}
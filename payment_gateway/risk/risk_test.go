@@ -0,0 +1,163 @@
+package risk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAttempt(id string, now time.Time) Attempt {
+	return NewAttempt(id, "caller", "10.0.0.1", types.PaymentRequest{
+		CardNumber: "4242424242424242",
+		Name:       "John Doe",
+		Amount:     decimal.MustParse("25.00"),
+		Currency:   "USD",
+	}, now)
+}
+
+func TestNewAttemptFingerprintsCard(t *testing.T) {
+	attempt := testAttempt("a", time.Now())
+	assert.NotContains(t, attempt.Card, "4242424242424242")
+	assert.Equal(t, Fingerprint("4242424242424242"), attempt.Card)
+	assert.Equal(t, "john doe", attempt.Name)
+}
+
+func TestCleanAttemptIsAllowed(t *testing.T) {
+	engine := NewEngine(DefaultConfig())
+
+	assessment := engine.Assess(testAttempt("a", time.Now()))
+	assert.Equal(t, DecisionAllow, assessment.Decision)
+	assert.Zero(t, assessment.Score)
+	assert.Empty(t, assessment.Reasons)
+}
+
+func TestCardVelocity(t *testing.T) {
+	cfg := DefaultConfig()
+	engine := NewEngine(cfg)
+	now := time.Now()
+
+	var assessment types.RiskAssessment
+	for i := 0; i <= cfg.CardAttempts; i++ {
+		attempt := testAttempt(fmt.Sprint(i), now.Add(time.Duration(i)*time.Second))
+		attempt.IP = fmt.Sprintf("10.0.0.%d", i)
+		assessment = engine.Assess(attempt)
+	}
+	assert.Equal(t, 40, assessment.Score)
+	assert.Equal(t, DecisionAllow, assessment.Decision)
+	assert.Contains(t, assessment.Reasons[0], "card_velocity")
+
+	later := testAttempt("later", now.Add(cfg.Window+time.Minute))
+	assert.Zero(t, engine.Assess(later).Score, "attempts outside the window are forgotten")
+}
+
+func TestRepeatedDeclinesAndVelocityBlock(t *testing.T) {
+	cfg := DefaultConfig()
+	engine := NewEngine(cfg)
+	now := time.Now()
+
+	for i := 0; i < cfg.CardAttempts; i++ {
+		id := fmt.Sprint(i)
+		engine.Assess(testAttempt(id, now))
+		engine.RecordOutcome(id, true)
+	}
+
+	assessment := engine.Assess(testAttempt("next", now))
+	assert.Equal(t, 90, assessment.Score)
+	assert.Equal(t, DecisionBlock, assessment.Decision)
+	assert.Len(t, assessment.Reasons, 2)
+}
+
+func TestDeclinesAloneReview(t *testing.T) {
+	cfg := DefaultConfig()
+	engine := NewEngine(cfg)
+	now := time.Now()
+
+	for i := 0; i < cfg.Declines; i++ {
+		id := fmt.Sprint(i)
+		engine.Assess(testAttempt(id, now))
+		engine.RecordOutcome(id, true)
+	}
+
+	assessment := engine.Assess(testAttempt("next", now))
+	assert.Equal(t, DecisionReview, assessment.Decision)
+	assert.Contains(t, assessment.Reasons[0], "repeated_declines")
+}
+
+func TestAmountOutlier(t *testing.T) {
+	cfg := DefaultConfig()
+	engine := NewEngine(cfg, &AmountOutlierRule{Factor: 10, MinHistory: 5, Score: 30})
+	now := time.Now()
+
+	big := testAttempt("big", now)
+	big.Amount = decimal.MustParse("5000.00")
+	fresh := NewEngine(cfg, &AmountOutlierRule{Factor: 10, MinHistory: 5, Score: 30})
+	assert.Zero(t, fresh.Assess(big).Score, "no history yet")
+
+	for i := 0; i < 5; i++ {
+		engine.Assess(testAttempt(fmt.Sprint(i), now))
+	}
+	assessment := engine.Assess(testAttempt("normal", now))
+	assert.Zero(t, assessment.Score)
+
+	assessment = engine.Assess(big)
+	assert.Equal(t, 30, assessment.Score)
+	assert.Contains(t, assessment.Reasons[0], "amount_outlier")
+
+	other := big
+	other.Currency = "EUR"
+	assert.Zero(t, engine.Assess(other).Score, "currencies are compared separately")
+}
+
+func TestNameMismatch(t *testing.T) {
+	cfg := DefaultConfig()
+	engine := NewEngine(cfg, &NameMismatchRule{Limit: 2, Window: cfg.Window, Score: 40})
+	now := time.Now()
+
+	for i, name := range []string{"john doe", "John  Doe", "jane roe"} {
+		attempt := testAttempt(fmt.Sprint(i), now)
+		attempt.Name = NewAttempt("", "", "", types.PaymentRequest{Name: name}, now).Name
+		assert.Zero(t, engine.Assess(attempt).Score)
+	}
+
+	attempt := testAttempt("third-name", now)
+	attempt.Name = "max mustermann"
+	assessment := engine.Assess(attempt)
+	assert.Equal(t, 40, assessment.Score)
+	assert.Contains(t, assessment.Reasons[0], "3 different names")
+}
+
+func TestNamePattern(t *testing.T) {
+	rule := &NamePatternRule{Score: 20}
+	tests := []struct {
+		name string
+		want int
+	}{
+		{name: "John Doe", want: 0},
+		{name: "Test User", want: 20},
+		{name: "aaaa bbbb", want: 20},
+		{name: "Li Wu", want: 0},
+		{name: "Asdf Qwerty", want: 20},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			attempt := NewAttempt("a", "caller", "ip", types.PaymentRequest{Name: tc.name}, time.Now())
+			score, _ := rule.Evaluate(attempt, NewHistory(time.Minute))
+			assert.Equal(t, tc.want, score)
+		})
+	}
+}
+
+func TestScoreIsCapped(t *testing.T) {
+	engine := NewEngine(DefaultConfig(), &NamePatternRule{Score: 70}, &NamePatternRule{Score: 70})
+
+	attempt := testAttempt("a", time.Now())
+	attempt.Name = "test user"
+	assessment := engine.Assess(attempt)
+	assert.Equal(t, 100, assessment.Score)
+	assert.Equal(t, DecisionBlock, assessment.Decision)
+}
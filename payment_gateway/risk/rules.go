@@ -0,0 +1,144 @@
+package risk
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func byCard(a Attempt) string   { return a.Card }
+func byIP(a Attempt) string     { return a.IP }
+func byCaller(a Attempt) string { return a.Caller }
+
+// VelocityRule scores attempts that share Key with more than Limit attempts
+// within Window, counting the current one.
+type VelocityRule struct {
+	RuleName string
+	Key      func(Attempt) string
+	Limit    int
+	Window   time.Duration
+	Score    int
+}
+
+func (r *VelocityRule) Name() string {
+	return r.RuleName
+}
+
+func (r *VelocityRule) Evaluate(attempt Attempt, history *History) (int, string) {
+	key := r.Key(attempt)
+	count := len(history.Within(attempt.At, r.Window, func(a Attempt) bool {
+		return r.Key(a) == key
+	}))
+	if count <= r.Limit {
+		return 0, ""
+	}
+	return r.Score, fmt.Sprintf("%d attempts in %s", count, r.Window)
+}
+
+// DeclineRule scores cards that were declined at least Limit times within
+// Window.
+type DeclineRule struct {
+	Limit  int
+	Window time.Duration
+	Score  int
+}
+
+func (r *DeclineRule) Name() string {
+	return "repeated_declines"
+}
+
+func (r *DeclineRule) Evaluate(attempt Attempt, history *History) (int, string) {
+	declines := len(history.Within(attempt.At, r.Window, func(a Attempt) bool {
+		return a.Card == attempt.Card && a.Declined
+	}))
+	if declines < r.Limit {
+		return 0, ""
+	}
+	return r.Score, fmt.Sprintf("%d declines in %s", declines, r.Window)
+}
+
+// AmountOutlierRule scores amounts more than Factor times the average of the
+// caller's earlier payments in the same currency. Callers with fewer than
+// MinHistory earlier payments are not scored.
+type AmountOutlierRule struct {
+	Factor     float64
+	MinHistory int
+	Score      int
+}
+
+func (r *AmountOutlierRule) Name() string {
+	return "amount_outlier"
+}
+
+func (r *AmountOutlierRule) Evaluate(attempt Attempt, history *History) (int, string) {
+	amounts := history.Amounts(attempt.Caller, attempt.Currency)
+	// The last amount is the attempt itself.
+	if len(amounts) <= r.MinHistory {
+		return 0, ""
+	}
+	earlier := amounts[:len(amounts)-1]
+
+	var total float64
+	for _, amount := range earlier {
+		f, _ := amount.Float64()
+		total += f
+	}
+	average := total / float64(len(earlier))
+
+	current, _ := attempt.Amount.Float64()
+	if average <= 0 || current <= average*r.Factor {
+		return 0, ""
+	}
+	return r.Score, fmt.Sprintf("amount %s is %.1fx the average of %.2f", attempt.Amount, current/average, average)
+}
+
+// NameMismatchRule scores cards used with more than Limit different
+// cardholder names within Window.
+type NameMismatchRule struct {
+	Limit  int
+	Window time.Duration
+	Score  int
+}
+
+func (r *NameMismatchRule) Name() string {
+	return "name_mismatch"
+}
+
+func (r *NameMismatchRule) Evaluate(attempt Attempt, history *History) (int, string) {
+	names := make(map[string]bool)
+	for _, a := range history.Within(attempt.At, r.Window, func(a Attempt) bool {
+		return a.Card == attempt.Card
+	}) {
+		names[a.Name] = true
+	}
+	if len(names) <= r.Limit {
+		return 0, ""
+	}
+	return r.Score, fmt.Sprintf("card used with %d different names in %s", len(names), r.Window)
+}
+
+// placeholderNames are words that show up in made-up cardholder names.
+var placeholderNames = []string{"test", "fake", "asdf", "qwerty", "sample", "dummy", "unknown"}
+
+// NamePatternRule scores cardholder names that look made up.
+type NamePatternRule struct {
+	Score int
+}
+
+func (r *NamePatternRule) Name() string {
+	return "name_pattern"
+}
+
+func (r *NamePatternRule) Evaluate(attempt Attempt, history *History) (int, string) {
+	for _, word := range strings.Fields(attempt.Name) {
+		for _, placeholder := range placeholderNames {
+			if word == placeholder {
+				return r.Score, fmt.Sprintf("name contains %q", placeholder)
+			}
+		}
+		if len(word) > 2 && strings.Count(word, word[:1]) == len(word) {
+			return r.Score, "name contains a repeated letter"
+		}
+	}
+	return 0, ""
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/idempotency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/ledger"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/lifecycle"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/processor"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/redact"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/refund"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/risk"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/threeds"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/validator"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/vault"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/webhook"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/worker"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxLongPollWait      = 60 * time.Second
+)
+
+const (
+	scopePaymentsRead   = "payments:read"
+	scopePaymentsWrite  = "payments:write"
+	scopePaymentsRefund = "payments:refund"
+	scopeWebhooks       = "webhooks:manage"
+	scopeTokens         = "tokens:manage"
+	scopeSettlements    = "settlements:read"
+	scopeLedger         = "ledger:read"
+	scopeMetrics        = "metrics:read"
+)
+
+type paymentMode string
+
+const (
+	paymentModeSale      paymentMode = "sale"
+	paymentModeAuthorize paymentMode = "authorize"
+)
+
+type paymentHandler struct {
+	validator           validator.PaymentValidator
+	processor           processor.Processor
+	idempotency         *idempotency.Store
+	transactions        repository.TransactionRepository
+	authorizationWindow time.Duration
+	defaultCurrency     string
+	webhooks            *webhook.Dispatcher
+	workers             *worker.Pool
+	notifier            *worker.Notifier
+	threeDSPolicy       threeds.Policy
+	challenges          *threeds.Service
+	publicURL           string
+	vault               *vault.Vault
+	risk                *risk.Engine
+	ledger              *ledger.Ledger
+}
+
+func registerPaymentRoutes(group *gin.RouterGroup, h *paymentHandler) {
+	group.POST("/payment", requireScope(scopePaymentsWrite), h.processPayment)
+	group.POST("/payment/authorize", requireScope(scopePaymentsWrite), h.authorizePayment)
+	group.GET("/payment/:transaction_id", requireScope(scopePaymentsRead), h.getTransaction)
+	group.POST("/payment/:transaction_id/capture", requireScope(scopePaymentsWrite), h.capturePayment)
+	group.POST("/payment/:transaction_id/void", requireScope(scopePaymentsWrite), h.voidPayment)
+	group.POST("/payment/:transaction_id/refund", requireScope(scopePaymentsRefund), h.refundPayment)
+	group.POST("/payment/:transaction_id/complete", requireScope(scopePaymentsWrite), h.completeChallenge)
+}
+
+func (h *paymentHandler) processPayment(c *gin.Context) {
+	h.handlePayment(c, paymentModeSale)
+}
+
+func (h *paymentHandler) authorizePayment(c *gin.Context) {
+	h.handlePayment(c, paymentModeAuthorize)
+}
+
+func (h *paymentHandler) handlePayment(c *gin.Context, mode paymentMode) {
+	requestID := tracing.RequestID(c)
+	startTime := time.Now()
+
+	ctx, span := tracing.Start(c.Request.Context(), "payment.handle",
+		attribute.String("payment.mode", string(mode)),
+		attribute.String("key_name", callerID(c)),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"request_id": requestID,
+		"client_ip":  c.ClientIP(),
+		"key_name":   callerID(c),
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+		"mode":       mode,
+	})
+
+	requestLogger.Info("Received payment request")
+
+	var req types.PaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLogger.WithError(err).Warn("Invalid request format")
+		problem.BadRequest(c, "Request body is malformed or missing required fields")
+		return
+	}
+
+	req.Currency = currency.Normalize(req.Currency)
+	if req.Currency == "" {
+		req.Currency = h.defaultCurrency
+	}
+
+	if req.CardToken != "" {
+		requestLogger = requestLogger.WithField("card_token", req.CardToken)
+		if !h.resolveCardToken(c, requestLogger, &req) {
+			return
+		}
+	}
+
+	scope := callerID(c) + ":" + string(mode)
+	idempotencyKey := c.GetHeader(idempotencyKeyHeader)
+	if idempotencyKey != "" {
+		requestLogger = requestLogger.WithField("idempotency_key", idempotencyKey)
+		if len(idempotencyKey) > idempotency.MaxKeyLength {
+			requestLogger.Warn("Idempotency key too long")
+			problem.BadRequest(c, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, _ := json.Marshal(req)
+		record, replay, err := h.idempotency.Begin(scope, idempotencyKey, idempotency.HashRequest(body))
+		switch {
+		case errors.Is(err, idempotency.ErrKeyReused):
+			requestLogger.Warn("Idempotency key reused with a different request")
+			problem.Respond(c, problem.New(problem.TypeIdempotencyKeyReused, http.StatusUnprocessableEntity, err.Error()))
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			requestLogger.Warn("Idempotent request already in progress")
+			problem.Respond(c, problem.New(problem.TypeConflict, http.StatusConflict, err.Error()))
+			return
+		case replay:
+			requestLogger.WithField("transaction_id", record.Response.TransactionID).Info("Replaying idempotent response")
+			c.Header("Idempotent-Replayed", "true")
+			c.JSON(record.StatusCode, record.Response)
+			return
+		}
+
+		// A panic would otherwise leave the key reserved until it expires,
+		// answering every retry with 409. Recovery still handles the panic.
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				h.idempotency.Release(scope, idempotencyKey)
+				panic(recovered)
+			}
+		}()
+	}
+
+	req.Timestamp = time.Now()
+	_, validateSpan := tracing.Start(ctx, "payment.validate")
+	errors := h.validator.Validate(req)
+	validateSpan.SetAttributes(attribute.Int("validation.errors", len(errors)))
+	validateSpan.End()
+	if len(errors) > 0 {
+		if idempotencyKey != "" {
+			h.idempotency.Release(scope, idempotencyKey)
+		}
+		recordValidationFailures("payment", errors)
+		requestLogger.WithField("validation_errors", errors).Warn("Validation failed")
+		problem.Respond(c, problem.Validation(errors))
+		return
+	}
+
+	if minorUnits, ok := currency.MinorUnits(req.Currency); ok {
+		req.Amount = req.Amount.Pad(minorUnits)
+	}
+
+	transactionID := uuid.New().String()
+	requestLogger = requestLogger.WithFields(logrus.Fields{
+		"transaction_id": transactionID,
+		"amount":         req.Amount.String(),
+		"currency":       req.Currency,
+	})
+	span.SetAttributes(
+		attribute.String("transaction_id", transactionID),
+		attribute.String("payment.currency", req.Currency),
+	)
+
+	txn := types.Transaction{
+		TransactionID:  transactionID,
+		RequestID:      requestID,
+		Owner:          callerID(c),
+		IdempotencyKey: idempotencyKey,
+		ClientIP:       c.ClientIP(),
+		CardholderName: req.Name,
+		MaskedCard:     redact.CardNumber(req.CardNumber),
+		CardToken:      req.CardToken,
+		CardBrand:      string(validator.DetectBrand(req.CardNumber)),
+		Amount:         req.Amount,
+		Currency:       req.Currency,
+		Mode:           string(mode),
+		Status:         types.StatusPending,
+		CreatedAt:      req.Timestamp,
+		UpdatedAt:      req.Timestamp,
+	}
+
+	if h.risk != nil {
+		_, riskSpan := tracing.Start(ctx, "risk.assess")
+		assessment := h.risk.Assess(risk.NewAttempt(transactionID, txn.Owner, txn.ClientIP, req, req.Timestamp))
+		riskSpan.SetAttributes(
+			attribute.Int("risk.score", assessment.Score),
+			attribute.String("risk.decision", string(assessment.Decision)),
+		)
+		riskSpan.End()
+		txn.Risk = &assessment
+		requestLogger = requestLogger.WithFields(logrus.Fields{
+			"risk_score":    assessment.Score,
+			"risk_decision": assessment.Decision,
+		})
+		riskLogger := requestLogger.WithField("risk_reasons", assessment.Reasons)
+		if assessment.Decision == risk.DecisionAllow {
+			riskLogger.Info("Risk assessed")
+		} else {
+			riskLogger.Warn("Risk assessed")
+		}
+
+		if assessment.Decision == risk.DecisionBlock {
+			recordOutcome(mode, types.StatusFailed, types.DeclineRiskBlocked, startTime)
+			h.blockPayment(c, requestLogger, txn, scope, idempotencyKey)
+			return
+		}
+	}
+
+	if h.threeDSPolicy.Requires(req) {
+		h.requireChallenge(c, requestLogger, mode, req, txn, scope, idempotencyKey)
+		return
+	}
+
+	if wantsAsync(c) {
+		h.handleAsyncPayment(c, requestLogger, mode, req, txn, scope, idempotencyKey, startTime)
+		return
+	}
+
+	txn, response := h.completePayment(ctx, requestLogger, mode, req, txn, startTime)
+	if txn.Status == types.StatusPending {
+		// Nothing was decided, so a retry with the same key starts over
+		if idempotencyKey != "" {
+			h.idempotency.Release(scope, idempotencyKey)
+		}
+		c.Header("Retry-After", "1")
+		problem.Respond(c, problem.New(problem.TypeServiceUnavailable, http.StatusServiceUnavailable, response.Message).
+			With("transaction_id", txn.TransactionID).
+			With("transaction_status", txn.Status).
+			With("retryable", true))
+		return
+	}
+
+	if idempotencyKey != "" {
+		h.idempotency.Complete(scope, idempotencyKey, http.StatusOK, response)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// wantsAsync reports whether the client opted in to asynchronous processing
+// with "Prefer: respond-async" or "?async=true".
+func wantsAsync(c *gin.Context) bool {
+	if strings.Contains(strings.ToLower(c.GetHeader("Prefer")), "respond-async") {
+		return true
+	}
+	async, _ := strconv.ParseBool(c.Query("async"))
+	return async
+}
+
+func (h *paymentHandler) handleAsyncPayment(c *gin.Context, requestLogger *logrus.Entry, mode paymentMode, req types.PaymentRequest, txn types.Transaction, scope, idempotencyKey string, startTime time.Time) {
+	txn.Message = "Transaction accepted for processing"
+	if err := h.transactions.Save(txn); err != nil {
+		if idempotencyKey != "" {
+			h.idempotency.Release(scope, idempotencyKey)
+		}
+		requestLogger.WithError(err).Error("Failed to record transaction")
+		problem.Internal(c, "Failed to record transaction")
+		return
+	}
+
+	// The worker outlives the request, so it keeps the trace but not the
+	// request's cancellation
+	requestCtx := c.Request.Context()
+	err := h.workers.Submit(func(ctx context.Context) {
+		h.completePayment(tracing.Detach(ctx, requestCtx), requestLogger, mode, req, txn, startTime)
+	})
+	if err != nil {
+		txn.Status = types.StatusFailed
+		txn.Message = "Payment gateway is busy, please retry later"
+		txn.DeclineCode = types.DeclineGatewayBusy
+		txn.UpdatedAt = time.Now()
+		if saveErr := h.transactions.Save(txn); saveErr != nil {
+			requestLogger.WithError(saveErr).Error("Failed to record transaction")
+		}
+		if idempotencyKey != "" {
+			h.idempotency.Release(scope, idempotencyKey)
+		}
+		recordOutcome(mode, txn.Status, txn.DeclineCode, startTime)
+		requestLogger.WithError(err).Warn("Async payment queue rejected request")
+		c.Header("Retry-After", "1")
+		problem.Respond(c, problem.New(problem.TypeServiceUnavailable, http.StatusServiceUnavailable, txn.Message).
+			With("transaction_id", txn.TransactionID).
+			With("transaction_status", txn.Status).
+			With("decline_code", txn.DeclineCode).
+			With("retryable", txn.DeclineCode.Retryable()))
+		return
+	}
+
+	requestLogger.Info("Transaction accepted for asynchronous processing")
+
+	response := types.PaymentResponse{
+		Status:        txn.Status,
+		Message:       txn.Message,
+		TransactionID: txn.TransactionID,
+		RequestID:     txn.RequestID,
+		CardBrand:     txn.CardBrand,
+		Amount:        &txn.Amount,
+		Currency:      txn.Currency,
+		Risk:          txn.Risk,
+	}
+
+	if idempotencyKey != "" {
+		h.idempotency.Complete(scope, idempotencyKey, http.StatusAccepted, response)
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/authorize")+"/"+txn.TransactionID)
+	c.JSON(http.StatusAccepted, response)
+}
+
+// blockPayment declines a payment the risk engine blocked without sending it
+// to the processor.
+func (h *paymentHandler) blockPayment(c *gin.Context, requestLogger *logrus.Entry, txn types.Transaction, scope, idempotencyKey string) {
+	txn.Status = types.StatusFailed
+	txn.Message = "Payment blocked by risk screening"
+	txn.DeclineCode = types.DeclineRiskBlocked
+	if err := h.transactions.Save(txn); err != nil {
+		requestLogger.WithError(err).Error("Failed to record transaction")
+	}
+	h.publishEvent(requestLogger, webhook.EventPaymentFailed, txn)
+
+	response := types.PaymentResponse{
+		Status:        txn.Status,
+		Message:       txn.Message,
+		TransactionID: txn.TransactionID,
+		RequestID:     txn.RequestID,
+		CardBrand:     txn.CardBrand,
+		Amount:        &txn.Amount,
+		Currency:      txn.Currency,
+		Risk:          txn.Risk,
+	}
+	response.Decline(txn.DeclineCode)
+
+	if idempotencyKey != "" {
+		h.idempotency.Complete(scope, idempotencyKey, http.StatusOK, response)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// completePayment sends the payment to the processor, records the outcome
+// and notifies webhooks and long-poll waiters. It runs on the request
+// goroutine for synchronous payments and on a worker for asynchronous ones.
+func (h *paymentHandler) completePayment(ctx context.Context, requestLogger *logrus.Entry, mode paymentMode, req types.PaymentRequest, txn types.Transaction, startTime time.Time) (types.Transaction, types.PaymentResponse) {
+	spanName := "processor.ProcessPayment"
+	if mode == paymentModeAuthorize {
+		spanName = "processor.Authorize"
+	}
+	ctx, span := tracing.Start(ctx, spanName, attribute.String("transaction_id", txn.TransactionID))
+	defer span.End()
+	requestLogger = requestLogger.WithContext(ctx)
+
+	var status string
+	var err error
+	if mode == paymentModeAuthorize {
+		status, err = h.processor.Authorize(ctx, req)
+	} else {
+		status, err = h.processor.ProcessPayment(ctx, req)
+	}
+
+	if errors.Is(err, processor.ErrInterrupted) {
+		span.SetAttributes(attribute.String("payment.status", status))
+		return h.interruptPayment(requestLogger, txn, err)
+	}
+
+	processingTime := time.Since(startTime).Milliseconds()
+	declineCode := processor.DeclineCodeOf(err)
+	recordOutcome(mode, status, declineCode, startTime)
+	// A decline is a normal outcome, not a span error
+	span.SetAttributes(attribute.String("payment.status", status))
+	if err != nil {
+		span.SetAttributes(attribute.String("payment.decline_code", string(declineCode)))
+	}
+
+	response := types.PaymentResponse{
+		Status:        status,
+		Message:       "Transaction processed successfully",
+		TransactionID: txn.TransactionID,
+		RequestID:     txn.RequestID,
+		CardBrand:     txn.CardBrand,
+		Amount:        &txn.Amount,
+		Currency:      txn.Currency,
+		Risk:          txn.Risk,
+	}
+	if status == types.StatusAuthorized {
+		expiresAt := time.Now().Add(h.authorizationWindow)
+		response.Message = "Transaction authorized successfully"
+		response.ExpiresAt = &expiresAt
+	}
+
+	if err != nil {
+		requestLogger.WithFields(logrus.Fields{
+			"error":              err.Error(),
+			"decline_code":       declineCode,
+			"status":             status,
+			"processing_time_ms": processingTime,
+		}).Error("Transaction processing failed")
+		response.Message = err.Error()
+		response.Decline(declineCode)
+	} else {
+		requestLogger.WithFields(logrus.Fields{
+			"status":             status,
+			"processing_time_ms": processingTime,
+		}).Info("Transaction completed successfully")
+	}
+
+	txn.Status = status
+	txn.Message = response.Message
+	txn.DeclineCode = declineCode
+	txn.ExpiresAt = response.ExpiresAt
+	txn.UpdatedAt = time.Now()
+	switch status {
+	case types.StatusSuccess:
+		txn.CapturedAmount = txn.Amount
+		capturedAt := txn.UpdatedAt
+		txn.CapturedAt = &capturedAt
+		h.chargeFee(requestLogger, &txn)
+	case types.StatusAuthorized:
+		authorizedAt := txn.UpdatedAt
+		txn.AuthorizedAt = &authorizedAt
+	}
+	if err := h.transactions.Save(txn); err != nil {
+		requestLogger.WithError(err).Error("Failed to record transaction")
+	}
+	h.recordLedger(requestLogger, txn)
+	h.notifier.Notify(txn.TransactionID)
+	if h.risk != nil {
+		h.risk.RecordOutcome(txn.TransactionID, status == types.StatusFailed)
+	}
+
+	switch status {
+	case types.StatusSuccess:
+		h.publishEvent(requestLogger, webhook.EventPaymentSucceeded, txn)
+	case types.StatusFailed:
+		h.publishEvent(requestLogger, webhook.EventPaymentFailed, txn)
+	}
+
+	return txn, response
+}
+
+// interruptPayment keeps a payment whose processing was cut short, such as
+// by the client going away or the gateway shutting down, PENDING. Whether
+// the issuer would have approved it is unknown, so it is not counted as a
+// failure by metrics, risk screening or webhooks, and it can be retried.
+func (h *paymentHandler) interruptPayment(requestLogger *logrus.Entry, txn types.Transaction, err error) (types.Transaction, types.PaymentResponse) {
+	requestLogger.WithError(err).Warn("Transaction processing was interrupted")
+
+	txn.Message = "Payment processing was interrupted, please retry"
+	txn.UpdatedAt = time.Now()
+	if err := h.transactions.Save(txn); err != nil {
+		requestLogger.WithError(err).Error("Failed to record transaction")
+	}
+
+	retryable := true
+	return txn, types.PaymentResponse{
+		Status:        txn.Status,
+		Message:       txn.Message,
+		TransactionID: txn.TransactionID,
+		RequestID:     txn.RequestID,
+		CardBrand:     txn.CardBrand,
+		Amount:        &txn.Amount,
+		Currency:      txn.Currency,
+		Risk:          txn.Risk,
+		Retryable:     &retryable,
+	}
+}
+
+// chargeFee stores the ledger fee on a payment as it is captured. Without
+// one the ledger charges the fee schedule current when it posts.
+func (h *paymentHandler) chargeFee(requestLogger *logrus.Entry, txn *types.Transaction) {
+	if err := h.ledger.ChargeFee(txn); err != nil {
+		requestLogger.WithError(err).Error("Failed to compute capture fee")
+	}
+}
+
+// recordLedger posts the ledger entries for txn's latest transition. Like
+// webhooks, ledger problems are logged and never fail the request.
+func (h *paymentHandler) recordLedger(requestLogger *logrus.Entry, txn types.Transaction) {
+	if _, err := h.ledger.Sync(txn); err != nil {
+		requestLogger.WithError(err).Error("Failed to post ledger entries")
+	}
+}
+
+// publishEvent queues a webhook for the caller that owns txn. Delivery
+// problems never fail the payment request itself.
+func (h *paymentHandler) publishEvent(requestLogger *logrus.Entry, eventType string, txn types.Transaction) {
+	if err := h.webhooks.Publish(txn.Owner, eventType, types.NewPaymentEvent(txn)); err != nil {
+		requestLogger.WithError(err).WithField("event_type", eventType).Error("Failed to queue webhook event")
+	}
+}
+
+func (h *paymentHandler) getTransaction(c *gin.Context) {
+	transactionID := c.Param("transaction_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip":      c.ClientIP(),
+		"key_name":       callerID(c),
+		"method":         c.Request.Method,
+		"path":           c.Request.URL.Path,
+		"transaction_id": transactionID,
+	})
+	requestLogger.Info("Received request for transaction")
+
+	wait, err := parseLongPollWait(c.Query("wait"))
+	if err != nil {
+		requestLogger.WithError(err).Warn("Invalid wait parameter")
+		problem.BadRequest(c, "wait must be a duration such as 30s, up to "+maxLongPollWait.String())
+		return
+	}
+
+	txn, err := h.waitForTransaction(c.Request.Context(), transactionID, callerID(c), wait)
+	if errors.Is(err, repository.ErrNotFound) {
+		requestLogger.Warn("Transaction not found")
+		problem.NotFound(c, "Transaction with requested ID not found")
+		return
+	}
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to look up transaction")
+		problem.Internal(c, "Failed to look up transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, txn)
+}
+
+// waitForTransaction returns owner's transaction once it has left PENDING or
+// wait has elapsed, whichever comes first. A zero wait returns immediately.
+func (h *paymentHandler) waitForTransaction(ctx context.Context, transactionID, owner string, wait time.Duration) (types.Transaction, error) {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		changed, release := h.notifier.Subscribe(transactionID)
+		txn, err := h.findOwned(transactionID, owner)
+		if err != nil || txn.Status != types.StatusPending || wait <= 0 {
+			release()
+			return txn, err
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			release()
+			return txn, nil
+		case <-ctx.Done():
+			release()
+			return txn, nil
+		}
+	}
+}
+
+// findOwned looks up a transaction owned by owner. Another caller's
+// transaction is reported as not found, so its ID reveals nothing.
+func (h *paymentHandler) findOwned(transactionID, owner string) (types.Transaction, error) {
+	txn, err := h.transactions.FindByID(transactionID)
+	if err == nil {
+		err = checkOwner(&txn, owner)
+	}
+	return txn, err
+}
+
+// checkOwner is the ownership check for Update callbacks, which must repeat
+// it since the transaction may have been read before.
+func checkOwner(txn *types.Transaction, owner string) error {
+	if txn.Owner != owner {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func parseLongPollWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		if seconds, convErr := strconv.Atoi(value); convErr == nil {
+			wait, err = time.Duration(seconds)*time.Second, nil
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if wait < 0 || wait > maxLongPollWait {
+		return 0, errors.New("wait out of range")
+	}
+	return wait, nil
+}
+
+func (h *paymentHandler) capturePayment(c *gin.Context) {
+	requestID := tracing.RequestID(c)
+	transactionID := c.Param("transaction_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"request_id":     requestID,
+		"client_ip":      c.ClientIP(),
+		"key_name":       callerID(c),
+		"method":         c.Request.Method,
+		"path":           c.Request.URL.Path,
+		"transaction_id": transactionID,
+	})
+	requestLogger.Info("Received capture request")
+
+	var req types.CaptureRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			requestLogger.WithError(err).Warn("Invalid request format")
+			problem.BadRequest(c, "Request body is malformed or missing required fields")
+			return
+		}
+	}
+
+	owner := callerID(c)
+	txn, err := h.findOwned(transactionID, owner)
+	if err == nil {
+		// Check the transition on a copy first so the processor is only
+		// called for captures that the state machine will accept.
+		err = lifecycle.Capture(&txn, req.Amount, time.Now())
+	}
+	if err == nil {
+		err = h.processor.Capture(c.Request.Context(), txn, txn.CapturedAmount)
+	}
+	if err == nil {
+		txn, err = h.transactions.Update(transactionID, func(txn *types.Transaction) error {
+			if err := checkOwner(txn, owner); err != nil {
+				return err
+			}
+			if err := lifecycle.Capture(txn, req.Amount, time.Now()); err != nil {
+				return err
+			}
+			h.chargeFee(requestLogger, txn)
+			return nil
+		})
+	}
+	if err != nil {
+		h.respondLifecycleError(c, requestLogger, requestID, transactionID, err)
+		return
+	}
+
+	requestLogger.WithFields(logrus.Fields{
+		"captured_amount": txn.CapturedAmount.String(),
+		"currency":        txn.Currency,
+	}).Info("Capture completed successfully")
+
+	h.recordLedger(requestLogger, txn)
+	h.publishEvent(requestLogger, webhook.EventPaymentSucceeded, txn)
+
+	c.JSON(http.StatusOK, types.PaymentResponse{
+		Status:         txn.Status,
+		Message:        "Transaction captured successfully",
+		TransactionID:  txn.TransactionID,
+		RequestID:      requestID,
+		Currency:       txn.Currency,
+		CapturedAmount: &txn.CapturedAmount,
+	})
+}
+
+func (h *paymentHandler) voidPayment(c *gin.Context) {
+	requestID := tracing.RequestID(c)
+	transactionID := c.Param("transaction_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"request_id":     requestID,
+		"client_ip":      c.ClientIP(),
+		"key_name":       callerID(c),
+		"method":         c.Request.Method,
+		"path":           c.Request.URL.Path,
+		"transaction_id": transactionID,
+	})
+	requestLogger.Info("Received void request")
+
+	owner := callerID(c)
+	txn, err := h.findOwned(transactionID, owner)
+	if err == nil {
+		err = lifecycle.Void(&txn, time.Now())
+	}
+	if err == nil {
+		err = h.processor.Void(c.Request.Context(), txn)
+	}
+	if err == nil {
+		txn, err = h.transactions.Update(transactionID, func(txn *types.Transaction) error {
+			if err := checkOwner(txn, owner); err != nil {
+				return err
+			}
+			return lifecycle.Void(txn, time.Now())
+		})
+	}
+	if err != nil {
+		h.respondLifecycleError(c, requestLogger, requestID, transactionID, err)
+		return
+	}
+
+	requestLogger.Info("Void completed successfully")
+
+	h.recordLedger(requestLogger, txn)
+
+	c.JSON(http.StatusOK, types.PaymentResponse{
+		Status:        txn.Status,
+		Message:       "Transaction voided successfully",
+		TransactionID: txn.TransactionID,
+		RequestID:     requestID,
+	})
+}
+
+func (h *paymentHandler) respondLifecycleError(c *gin.Context, requestLogger *logrus.Entry, requestID, transactionID string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		requestLogger.Warn("Transaction not found")
+		problem.NotFound(c, "Transaction with requested ID not found")
+	case errors.Is(err, lifecycle.ErrAuthorizationExpired):
+		expired, expireErr := h.transactions.Update(transactionID, func(txn *types.Transaction) error {
+			return lifecycle.Expire(txn, time.Now())
+		})
+		if expireErr != nil {
+			requestLogger.WithError(expireErr).Warn("Failed to expire authorization")
+		} else {
+			h.recordLedger(requestLogger, expired)
+		}
+		requestLogger.Warn("Authorization has expired")
+		problem.Respond(c, problem.New(problem.TypeAuthorizationExpired, http.StatusConflict, err.Error()).
+			With("transaction_status", types.StatusExpired))
+	case errors.Is(err, lifecycle.ErrInvalidTransition):
+		requestLogger.Warn("Invalid transaction state transition")
+		problem.Respond(c, problem.New(problem.TypeConflict, http.StatusConflict, err.Error()))
+	case errors.Is(err, lifecycle.ErrInvalidAmount), errors.Is(err, lifecycle.ErrExceedsAuthorized):
+		requestLogger.WithError(err).Warn("Capture amount rejected")
+		problem.Respond(c, problem.Validation([]types.ValidationError{{Field: "amount", Message: err.Error()}}))
+	default:
+		requestLogger.WithError(err).Error("Transaction update failed")
+		problem.Internal(c, "Failed to update transaction")
+	}
+}
+
+func (h *paymentHandler) refundPayment(c *gin.Context) {
+	requestID := tracing.RequestID(c)
+	transactionID := c.Param("transaction_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"request_id":     requestID,
+		"client_ip":      c.ClientIP(),
+		"key_name":       callerID(c),
+		"method":         c.Request.Method,
+		"path":           c.Request.URL.Path,
+		"transaction_id": transactionID,
+	})
+	requestLogger.Info("Received refund request")
+
+	var req types.RefundRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			requestLogger.WithError(err).Warn("Invalid request format")
+			problem.BadRequest(c, "Request body is malformed or missing required fields")
+			return
+		}
+	}
+
+	owner := callerID(c)
+	var issued types.Refund
+	txn, err := h.transactions.Update(transactionID, func(txn *types.Transaction) error {
+		if err := checkOwner(txn, owner); err != nil {
+			return err
+		}
+		var applyErr error
+		issued, applyErr = refund.Apply(txn, types.Refund{
+			RefundID:  uuid.New().String(),
+			RequestID: requestID,
+			Reason:    req.Reason,
+			CreatedAt: time.Now(),
+		}, req.Amount)
+		return applyErr
+	})
+
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		requestLogger.Warn("Transaction not found")
+		problem.NotFound(c, "Transaction with requested ID not found")
+		return
+	case errors.Is(err, refund.ErrNotRefundable):
+		requestLogger.Warn("Transaction is not refundable")
+		problem.Respond(c, problem.New(problem.TypeConflict, http.StatusConflict, err.Error()))
+		return
+	case errors.Is(err, refund.ErrInvalidAmount), errors.Is(err, refund.ErrExceedsCaptured):
+		requestLogger.WithError(err).Warn("Refund amount rejected")
+		problem.Respond(c, problem.Validation([]types.ValidationError{{Field: "amount", Message: err.Error()}}))
+		return
+	case err != nil:
+		requestLogger.WithError(err).Error("Refund processing failed")
+		problem.Internal(c, "Failed to process refund")
+		return
+	}
+
+	requestLogger.WithFields(logrus.Fields{
+		"refund_id":          issued.RefundID,
+		"amount":             issued.Amount.String(),
+		"currency":           txn.Currency,
+		"transaction_status": txn.Status,
+	}).Info("Refund completed successfully")
+
+	h.recordLedger(requestLogger, txn)
+	h.publishEvent(requestLogger, webhook.EventPaymentRefunded, txn)
+
+	c.JSON(http.StatusOK, types.RefundResponse{
+		Status:            types.StatusSuccess,
+		Message:           "Refund processed successfully",
+		RefundID:          issued.RefundID,
+		TransactionID:     txn.TransactionID,
+		Amount:            issued.Amount,
+		RefundedAmount:    txn.RefundedAmount,
+		Currency:          txn.Currency,
+		TransactionStatus: txn.Status,
+		RequestID:         requestID,
+	})
+}
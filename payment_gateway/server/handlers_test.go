@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/idempotency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/ledger"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/processor"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/threeds"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/validator"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/webhook"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/worker"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/apikey"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Raw API keys of the test server. booking and cinema are separate tenants
+// with every scope; readonly may only read payments.
+const (
+	bookingKey  = "booking-secret"
+	cinemaKey   = "cinema-secret"
+	readonlyKey = "readonly-secret"
+)
+
+const paymentBody = `{"card_number":"4242424242424242","cvv":"123","expiry":"12/30","name":"John Doe","amount":100,"timestamp":"-"}`
+
+func newTestServer(t *testing.T, paymentProcessor processor.Processor) (*gin.Engine, *paymentHandler) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+
+	registry, err := apikey.NewRegistry(
+		apikey.Key{Name: "booking", Hash: apikey.Hash(bookingKey), Scopes: []string{apikey.ScopeAll}},
+		apikey.Key{Name: "cinema", Hash: apikey.Hash(cinemaKey), Scopes: []string{apikey.ScopeAll}},
+		apikey.Key{Name: "readonly", Hash: apikey.Hash(readonlyKey), Scopes: []string{scopePaymentsRead}},
+	)
+	require.NoError(t, err)
+
+	handler := &paymentHandler{
+		validator:           validator.NewStrictValidator(validator.WithCurrencies("USD", "USD")),
+		processor:           paymentProcessor,
+		idempotency:         idempotency.NewStore(time.Hour),
+		transactions:        repository.NewMemoryRepository(),
+		authorizationWindow: 15 * time.Minute,
+		defaultCurrency:     "USD",
+		webhooks:            webhook.NewDispatcher(webhook.NewMemoryStore(), webhook.DefaultDispatcherConfig(), log),
+		workers:             worker.NewPool(1, 1),
+		notifier:            worker.NewNotifier(),
+		challenges:          threeds.NewService(10 * time.Minute),
+		publicURL:           "https://pay.example.com",
+		ledger:              ledger.New(ledger.FeeSchedule{}),
+	}
+
+	router := gin.New()
+	router.Use(tracing.Middleware(), problem.Recovery())
+	registerChallengeRoutes(router.Group("/"), handler)
+	protected := router.Group("/")
+	protected.Use(apikey.Middleware(registry, nil, log))
+	registerPaymentRoutes(protected, handler)
+	return router, handler
+}
+
+func serve(router *gin.Engine, method, path, key, body string) *httptest.ResponseRecorder {
+	return serveWithHeaders(router, method, path, key, body, nil)
+}
+
+func serveWithHeaders(router *gin.Engine, method, path, key, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(apikey.Header, key)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decodeJSON(t *testing.T, recorder *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body), recorder.Body.String())
+	return body
+}
+
+// createPayment makes a payment as key and returns its transaction ID.
+func createPayment(t *testing.T, router *gin.Engine, path, key string) string {
+	t.Helper()
+	recorder := serve(router, http.MethodPost, path, key, paymentBody)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	return decodeJSON(t, recorder)["transaction_id"].(string)
+}
+
+// assertProblem checks that recorder holds a problem+json body of typ and
+// status for path, and returns the body.
+func assertProblem(t *testing.T, recorder *httptest.ResponseRecorder, status int, typ problem.Type, path string) map[string]any {
+	t.Helper()
+	require.Equal(t, status, recorder.Code, recorder.Body.String())
+	assert.Equal(t, problem.ContentType, recorder.Header().Get("Content-Type"))
+
+	body := decodeJSON(t, recorder)
+	assert.Equal(t, string(typ), body["type"])
+	assert.Equal(t, float64(status), body["status"])
+	assert.NotEmpty(t, body["title"])
+	assert.Equal(t, path, body["instance"])
+	assert.Equal(t, recorder.Header().Get(tracing.RequestIDHeader), body["request_id"])
+	return body
+}
+
+func assertNotFound(t *testing.T, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	assert.Equal(t, http.StatusNotFound, recorder.Code, recorder.Body.String())
+	assert.Equal(t, problem.ContentType, recorder.Header().Get("Content-Type"))
+	body := decodeJSON(t, recorder)
+	assert.Equal(t, string(problem.TypeNotFound), body["type"])
+	assert.Equal(t, "Transaction with requested ID not found", body["detail"])
+}
+
+// panicOnce panics on its first payment and approves every one after it.
+type panicOnce struct {
+	processor.Processor
+	panicked bool
+}
+
+func (p *panicOnce) ProcessPayment(ctx context.Context, req types.PaymentRequest) (string, error) {
+	if !p.panicked {
+		p.panicked = true
+		panic("processor exploded")
+	}
+	return p.Processor.ProcessPayment(ctx, req)
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	router, _ := newTestServer(t, &panicOnce{Processor: processor.NewAlwaysSucceed()})
+
+	pay := func() *httptest.ResponseRecorder {
+		return serveWithHeaders(router, http.MethodPost, "/payment", bookingKey, paymentBody,
+			map[string]string{idempotencyKeyHeader: "order-1"})
+	}
+
+	assertProblem(t, pay(), http.StatusInternalServerError, problem.TypeInternal, "/payment")
+
+	// The retry is processed instead of being told the key is in progress
+	recorder := pay()
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, types.StatusSuccess, decodeJSON(t, recorder)["status"])
+}
+
+// interruptOnce is cut off on its first payment and approves every one
+// after it.
+type interruptOnce struct {
+	processor.Processor
+	interrupted bool
+}
+
+func (p *interruptOnce) ProcessPayment(ctx context.Context, req types.PaymentRequest) (string, error) {
+	if !p.interrupted {
+		p.interrupted = true
+		return types.StatusPending, fmt.Errorf("%w: %w", processor.ErrInterrupted, context.Canceled)
+	}
+	return p.Processor.ProcessPayment(ctx, req)
+}
+
+func TestInterruptedPaymentStaysPending(t *testing.T) {
+	router, handler := newTestServer(t, &interruptOnce{Processor: processor.NewAlwaysSucceed()})
+	pay := func() *httptest.ResponseRecorder {
+		return serveWithHeaders(router, http.MethodPost, "/payment", bookingKey, paymentBody,
+			map[string]string{idempotencyKeyHeader: "order-1"})
+	}
+
+	recorder := pay()
+	body := assertProblem(t, recorder, http.StatusServiceUnavailable, problem.TypeServiceUnavailable, "/payment")
+	assert.Equal(t, types.StatusPending, body["transaction_status"])
+	assert.Equal(t, true, body["retryable"])
+	assert.Nil(t, body["decline_code"])
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	txn, err := handler.transactions.FindByID(body["transaction_id"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusPending, txn.Status)
+	assert.Empty(t, txn.DeclineCode)
+
+	// The key was released, so the retry is processed rather than replayed
+	retry := pay()
+	require.Equal(t, http.StatusOK, retry.Code, retry.Body.String())
+	assert.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, types.StatusSuccess, decodeJSON(t, retry)["status"])
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+	pay := func(key, idempotencyKey, body string) *httptest.ResponseRecorder {
+		return serveWithHeaders(router, http.MethodPost, "/payment", key, body,
+			map[string]string{idempotencyKeyHeader: idempotencyKey})
+	}
+
+	first := pay(bookingKey, "order-1", paymentBody)
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	replay := pay(bookingKey, "order-1", paymentBody)
+	require.Equal(t, http.StatusOK, replay.Code, replay.Body.String())
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, first.Body.String(), replay.Body.String())
+
+	transactions, err := handler.transactions.List()
+	require.NoError(t, err)
+	assert.Len(t, transactions, 1)
+
+	// A different body under the same key is refused
+	changed := strings.Replace(paymentBody, `"amount":100`, `"amount":200`, 1)
+	body := assertProblem(t, pay(bookingKey, "order-1", changed), http.StatusUnprocessableEntity, problem.TypeIdempotencyKeyReused, "/payment")
+	assert.Equal(t, idempotency.ErrKeyReused.Error(), body["detail"])
+
+	// Keys are scoped per API key, so another tenant's order-1 is a new payment
+	other := pay(cinemaKey, "order-1", paymentBody)
+	require.Equal(t, http.StatusOK, other.Code, other.Body.String())
+	assert.Empty(t, other.Header().Get("Idempotent-Replayed"))
+	assert.NotEqual(t, decodeJSON(t, first)["transaction_id"], decodeJSON(t, other)["transaction_id"])
+}
+
+func TestScopesAreEnforced(t *testing.T) {
+	router, _ := newTestServer(t, processor.NewAlwaysSucceed())
+	transactionID := createPayment(t, router, "/payment", bookingKey)
+
+	body := assertProblem(t, serve(router, http.MethodPost, "/payment", readonlyKey, paymentBody),
+		http.StatusForbidden, problem.TypeForbidden, "/payment")
+	assert.Equal(t, "API key does not have the "+scopePaymentsWrite+" scope", body["detail"])
+
+	path := "/payment/" + transactionID + "/refund"
+	body = assertProblem(t, serve(router, http.MethodPost, path, readonlyKey, ""),
+		http.StatusForbidden, problem.TypeForbidden, path)
+	assert.Equal(t, "API key does not have the "+scopePaymentsRefund+" scope", body["detail"])
+
+	// Reading is allowed, but only the caller's own transactions
+	assertNotFound(t, serve(router, http.MethodGet, "/payment/"+transactionID, readonlyKey, ""))
+
+	assertProblem(t, serve(router, http.MethodGet, "/payment/"+transactionID, "", ""),
+		http.StatusForbidden, problem.TypeForbidden, "/payment/"+transactionID)
+}
+
+func TestLifecycleStatusMapping(t *testing.T) {
+	router, _ := newTestServer(t, processor.NewAlwaysSucceed())
+	sold := createPayment(t, router, "/payment", bookingKey)
+	authorized := createPayment(t, router, "/payment/authorize", bookingKey)
+	voided := createPayment(t, router, "/payment/authorize", bookingKey)
+	require.Equal(t, http.StatusOK, serve(router, http.MethodPost, "/payment/"+voided+"/void", bookingKey, "").Code)
+
+	tests := []struct {
+		name          string
+		transactionID string
+		action        string
+		body          string
+		status        int
+		typ           problem.Type
+	}{
+		{"capture a sale", sold, "capture", "", http.StatusConflict, problem.TypeConflict},
+		{"void a sale", sold, "void", "", http.StatusConflict, problem.TypeConflict},
+		{"capture a void", voided, "capture", "", http.StatusConflict, problem.TypeConflict},
+		{"void twice", voided, "void", "", http.StatusConflict, problem.TypeConflict},
+		{"refund an authorization", authorized, "refund", "", http.StatusConflict, problem.TypeConflict},
+		{"capture over the authorization", authorized, "capture", `{"amount":100.01}`, http.StatusUnprocessableEntity, problem.TypeValidation},
+		{"capture nothing", authorized, "capture", `{"amount":0}`, http.StatusUnprocessableEntity, problem.TypeValidation},
+		{"refund over the capture", sold, "refund", `{"amount":100.01}`, http.StatusUnprocessableEntity, problem.TypeValidation},
+		{"refund nothing", sold, "refund", `{"amount":0}`, http.StatusUnprocessableEntity, problem.TypeValidation},
+		{"malformed refund", sold, "refund", `{"amount":"lots"}`, http.StatusBadRequest, problem.TypeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/payment/" + tt.transactionID + "/" + tt.action
+			body := assertProblem(t, serve(router, http.MethodPost, path, bookingKey, tt.body), tt.status, tt.typ, path)
+			if tt.typ == problem.TypeValidation {
+				errors := body["errors"].([]any)
+				require.Len(t, errors, 1)
+				assert.Equal(t, "amount", errors[0].(map[string]any)["field"])
+			}
+		})
+	}
+
+	// The rejected requests changed nothing, so the happy paths still work
+	recorder := serve(router, http.MethodPost, "/payment/"+authorized+"/capture", bookingKey, `{"amount":40}`)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, types.StatusCaptured, decodeJSON(t, recorder)["status"])
+
+	recorder = serve(router, http.MethodPost, "/payment/"+sold+"/refund", bookingKey, "")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, types.StatusRefunded, decodeJSON(t, recorder)["transaction_status"])
+	path := "/payment/" + sold + "/refund"
+	assertProblem(t, serve(router, http.MethodPost, path, bookingKey, ""), http.StatusConflict, problem.TypeConflict, path)
+}
+
+func TestTransactionsAreScopedToOwner(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+
+	authorized := createPayment(t, router, "/payment/authorize", bookingKey)
+	sold := createPayment(t, router, "/payment", bookingKey)
+
+	assertNotFound(t, serve(router, http.MethodGet, "/payment/"+authorized, cinemaKey, ""))
+	assertNotFound(t, serve(router, http.MethodPost, "/payment/"+authorized+"/capture", cinemaKey, ""))
+	assertNotFound(t, serve(router, http.MethodPost, "/payment/"+authorized+"/void", cinemaKey, ""))
+	assertNotFound(t, serve(router, http.MethodPost, "/payment/"+sold+"/refund", cinemaKey, ""))
+
+	// Another tenant's attempts change nothing
+	txn, err := handler.transactions.FindByID(authorized)
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusAuthorized, txn.Status)
+	txn, err = handler.transactions.FindByID(sold)
+	require.NoError(t, err)
+	assert.True(t, txn.RefundedAmount.IsZero())
+
+	recorder := serve(router, http.MethodGet, "/payment/"+authorized, bookingKey, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = serve(router, http.MethodPost, "/payment/"+authorized+"/capture", bookingKey, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = serve(router, http.MethodPost, "/payment/"+sold+"/refund", bookingKey, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestChallengeCompletionIsScopedToOwner(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+
+	body := strings.Replace(paymentBody, "4242424242424242", processor.TestCardThreeDSChallenge, 1)
+	recorder := serve(router, http.MethodPost, "/payment", bookingKey, body)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	transactionID := decodeJSON(t, recorder)["transaction_id"].(string)
+
+	assertNotFound(t, serve(router, http.MethodPost, "/payment/"+transactionID+"/complete", cinemaKey, ""))
+
+	txn, err := handler.transactions.FindByID(transactionID)
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusRequiresAction, txn.Status)
+}
+
+func TestChallengeCompletionKeepsPaymentRequestID(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+
+	body := strings.Replace(paymentBody, "4242424242424242", processor.TestCardThreeDSChallenge, 1)
+	recorder := serve(router, http.MethodPost, "/payment", bookingKey, body)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	payment := decodeJSON(t, recorder)
+	transactionID := payment["transaction_id"].(string)
+	url := payment["next_action"].(map[string]any)["url"].(string)
+	challengeID := url[strings.LastIndex(url, "/")+1:]
+
+	recorder = serve(router, http.MethodGet, "/3ds/"+challengeID, "", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "Verify your payment")
+
+	challenge, err := handler.challenges.Get(challengeID)
+	require.NoError(t, err)
+	recorder = serve(router, http.MethodPost, "/3ds/"+challengeID, "", `{"code":"`+challenge.Code+`"}`)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	recorder = serve(router, http.MethodPost, "/payment/"+transactionID+"/complete", bookingKey, "")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	completionID := decodeJSON(t, recorder)["request_id"].(string)
+	assert.NotEqual(t, payment["request_id"], completionID)
+
+	txn, err := handler.transactions.FindByID(transactionID)
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusSuccess, txn.Status)
+	assert.Equal(t, payment["request_id"], txn.RequestID)
+	assert.Equal(t, completionID, txn.CompletionRequestID)
+}
+
+func TestLostChallengeDeclinesPayment(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+
+	body := strings.Replace(paymentBody, "4242424242424242", processor.TestCardThreeDSChallenge, 1)
+	recorder := serve(router, http.MethodPost, "/payment/authorize", bookingKey, body)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	transactionID := decodeJSON(t, recorder)["transaction_id"].(string)
+
+	// A restart loses the challenge, and with it the payment's mode
+	handler.challenges = threeds.NewService(10 * time.Minute)
+	declines := paymentOutcomes.WithLabelValues(string(paymentModeAuthorize), types.StatusFailed, string(types.DeclineThreeDS))
+	before := testutil.ToFloat64(declines)
+
+	path := "/payment/" + transactionID + "/complete"
+	recorder = serve(router, http.MethodPost, path, bookingKey, "")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, types.StatusFailed, decodeJSON(t, recorder)["status"])
+	assert.Equal(t, before+1, testutil.ToFloat64(declines))
+
+	txn, err := handler.transactions.FindByID(transactionID)
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusFailed, txn.Status)
+	assert.Equal(t, types.DeclineThreeDS, txn.DeclineCode)
+	assert.NotEmpty(t, txn.CompletionRequestID)
+
+	assertProblem(t, serve(router, http.MethodPost, path, bookingKey, ""), http.StatusConflict, problem.TypeConflict, path)
+}
+
+func TestChallengeURLIgnoresForwardedHeaders(t *testing.T) {
+	router, _ := newTestServer(t, processor.NewAlwaysSucceed())
+
+	body := strings.Replace(paymentBody, "4242424242424242", processor.TestCardThreeDSChallenge, 1)
+	recorder := serveWithHeaders(router, http.MethodPost, "/payment", bookingKey, body, map[string]string{
+		"X-Forwarded-Host":  "attacker.example",
+		"X-Forwarded-Proto": "javascript",
+	})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	url := decodeJSON(t, recorder)["next_action"].(map[string]any)["url"].(string)
+	assert.True(t, strings.HasPrefix(url, "https://pay.example.com/3ds/"), url)
+}
+
+func TestRefundRejectsAmountsFinerThanCurrency(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+	transactionID := createPayment(t, router, "/payment", bookingKey)
+
+	recorder := serve(router, http.MethodPost, "/payment/"+transactionID+"/refund", bookingKey, `{"amount":10.005}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code, recorder.Body.String())
+
+	recorder = serve(router, http.MethodPost, "/payment/"+transactionID+"/refund", bookingKey, `{"amount":10.5}`)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "10.50", decodeJSON(t, recorder)["amount"])
+	assert.Len(t, handler.ledger.Entries(transactionID), 2)
+}
+
+func TestWebhookEventsCarryOnlyPaymentSummary(t *testing.T) {
+	router, handler := newTestServer(t, processor.NewAlwaysSucceed())
+	_, err := handler.webhooks.Register("booking", "http://receiver.example", []string{webhook.EventPaymentSucceeded})
+	require.NoError(t, err)
+
+	transactionID := createPayment(t, router, "/payment", bookingKey)
+
+	deliveries := handler.webhooks.Store().Deliveries("booking", "")
+	require.Len(t, deliveries, 1)
+	var event struct {
+		Data map[string]any `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(deliveries[0].Payload, &event))
+	assert.Equal(t, map[string]any{
+		"transaction_id": transactionID,
+		"status":         types.StatusSuccess,
+		"amount":         "100.00",
+		"currency":       "USD",
+		"card_brand":     "visa",
+		"card_last4":     "4242",
+	}, event.Data)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/ledger"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+)
+
+type ledgerHandler struct {
+	ledger       *ledger.Ledger
+	transactions repository.TransactionRepository
+}
+
+func registerLedgerRoutes(group *gin.RouterGroup, h *ledgerHandler) {
+	routes := group.Group("/ledger", requireScope(scopeLedger))
+	routes.GET("/balances", h.listBalances)
+	routes.GET("/entries/:transaction_id", h.listEntries)
+}
+
+// listBalances returns the balance of each of the caller's accounts.
+func (h *ledgerHandler) listBalances(c *gin.Context) {
+	balances := h.ledger.Balances(callerID(c))
+	if balances == nil {
+		balances = []ledger.Balance{}
+	}
+	c.JSON(http.StatusOK, balances)
+}
+
+// listEntries returns the journal entries of one of the caller's
+// transactions.
+func (h *ledgerHandler) listEntries(c *gin.Context) {
+	txn, err := h.transactions.FindByID(c.Param("transaction_id"))
+	if err != nil || txn.Owner != callerID(c) {
+		problem.NotFound(c, "Transaction with requested ID not found")
+		return
+	}
+
+	entries := h.ledger.Entries(txn.TransactionID)
+	if entries == nil {
+		entries = []ledger.Entry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
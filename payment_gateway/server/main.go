@@ -2,17 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/idempotency"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/ledger"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/lifecycle"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/processor"
-	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/redact"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/risk"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/settlement"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/threeds"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/validator"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/vault"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/webhook"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/worker"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/apikey"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/metrics"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/mtls"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/ratelimit"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,6 +44,8 @@ var log = logrus.New()
 func init() {
 	log.SetFormatter(&logrus.JSONFormatter{})
 	log.SetOutput(os.Stdout)
+	log.AddHook(redact.NewHook())
+	log.AddHook(tracing.NewLogHook())
 
 	if os.Getenv("LOG_LEVEL") == "debug" {
 		log.SetLevel(logrus.DebugLevel)
@@ -29,57 +54,245 @@ func init() {
 	}
 }
 
-func apiKeyAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		apiKey := getEnvWithDefault("API_KEY", "")
-		if apiKey == "" {
-			log.Warn("API_KEY environment variable not set")
-			c.Next()
-			return
-		}
-		requestApiKey := c.GetHeader("x-api-key")
-		if requestApiKey == "" {
-			log.WithField("client_ip", c.ClientIP()).Warn("Missing API key in request")
-			c.JSON(http.StatusForbidden, gin.H{
-				"status":  "FORBIDDEN",
-				"message": "API key is required",
-			})
-			c.Abort()
-			return
-		}
-		if requestApiKey != apiKey {
-			log.WithField("client_ip", c.ClientIP()).Warn("Invalid API key provided")
-			c.JSON(http.StatusForbidden, gin.H{
-				"status":  "FORBIDDEN",
-				"message": "Invalid API key",
-			})
-			c.Abort()
-			return
-		}
-		c.Next()
+// callerID is the name of the API key that made the request. Transactions,
+// webhooks and card tokens are owned by this name, so a key rotated under
+// the same name keeps access to them. Requests on an unprotected gateway
+// share one identity.
+func callerID(c *gin.Context) string {
+	if name := apikey.Name(c); name != "" {
+		return name
+	}
+	return "anonymous"
+}
+
+// requireScope limits a route to API keys granted scope.
+func requireScope(scope string) gin.HandlerFunc {
+	return apikey.RequireScope(scope, log)
+}
+
+// rateLimitKey gives each API key its own rate limit bucket. Requests on an
+// unprotected gateway are limited per client IP instead.
+func rateLimitKey(c *gin.Context) string {
+	if apikey.Name(c) == "" {
+		return "ip:" + c.ClientIP()
 	}
+	return "key:" + callerID(c)
 }
 
 func main() {
 	port := getEnvWithDefault("PORT", "8082")
-	apiKey := getEnvWithDefault("API_KEY", "")
+
+	apiKeys, err := newAPIKeyRegistry()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load API keys")
+	}
+	signatures, err := newSignatureVerifier(apiKeys)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid request signing configuration")
+	}
+	certs, err := newTLSReloader()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load TLS certificates")
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:    "payment-gateway",
+		ServiceVersion: getEnvWithDefault("APP_VERSION", "dev"),
+		Exporter:       getEnvWithDefault("OTEL_TRACES_EXPORTER", tracing.ExporterNone),
+	})
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
 
 	logFields := logrus.Fields{
 		"port": port,
 	}
 
-	if apiKey != "" {
+	if apiKeys != nil {
 		logFields["api_key_protected"] = true
+		logFields["api_keys"] = apiKeys.Len()
+		logFields["signature_required"] = signatures.Required()
 	} else {
 		logFields["api_key_protected"] = false
-		log.Warn("No API_KEY set. API endpoints are unprotected!")
+		log.Warn("No API_KEY or API_KEYS_FILE set. API endpoints are unprotected!")
 	}
 
 	log.WithFields(logFields).Info("Starting payment gateway service")
 
-	router := gin.Default()
-	validator := validator.NewStrictValidator()
-	paymentProcessor := processor.NewPaymentProcessor()
+	// gin's default logger writes raw query strings, so access logs go through
+	// the redacting logger instead
+	router := gin.New()
+	// Metrics and tracing sit outside Recovery so panics are counted as 500s
+	router.Use(redact.GinLogger(log), metrics.GinMiddleware(metricsRegistry), tracing.Middleware(), problem.Recovery())
+	idempotencyTTL, err := time.ParseDuration(getEnvWithDefault("IDEMPOTENCY_TTL", "24h"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid IDEMPOTENCY_TTL")
+	}
+
+	transactions, err := repository.New(
+		getEnvWithDefault("TRANSACTION_STORE", "memory"),
+		getEnvWithDefault("TRANSACTION_STORE_PATH", "data/transactions.json"),
+	)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize transaction store")
+	}
+
+	authorizationWindow, err := time.ParseDuration(getEnvWithDefault("AUTHORIZATION_WINDOW", "15m"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid AUTHORIZATION_WINDOW")
+	}
+
+	paymentProcessor, err := newProcessor()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize payment processor")
+	}
+
+	acceptedBrands, err := validator.ParseBrands(getEnvWithDefault("ACCEPTED_CARD_BRANDS", "visa,mastercard,amex,discover,diners,jcb,maestro,rupay"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid ACCEPTED_CARD_BRANDS")
+	}
+
+	defaultCurrency := currency.Normalize(getEnvWithDefault("DEFAULT_CURRENCY", "USD"))
+	supportedCurrencies, err := currency.ParseList(getEnvWithDefault("SUPPORTED_CURRENCIES", defaultCurrency))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid SUPPORTED_CURRENCIES")
+	}
+	if !slices.Contains(supportedCurrencies, defaultCurrency) {
+		log.WithField("default_currency", defaultCurrency).Fatal("DEFAULT_CURRENCY must be one of SUPPORTED_CURRENCIES")
+	}
+	minAmounts, maxAmounts, err := newAmountLimits(defaultCurrency, supportedCurrencies)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid transaction amount limits")
+	}
+
+	paymentLedger, err := newLedger(transactions, defaultCurrency)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize ledger")
+	}
+
+	var webhookStore *webhook.Store
+	if getEnvWithDefault("WEBHOOK_STORE", "memory") == "file" {
+		webhookStore, err = webhook.NewFileStore(getEnvWithDefault("WEBHOOK_STORE_PATH", "data/webhooks.json"))
+		if err != nil {
+			log.WithError(err).Fatal("Failed to initialize webhook store")
+		}
+	} else {
+		webhookStore = webhook.NewMemoryStore()
+	}
+
+	webhookConfig := webhook.DefaultDispatcherConfig()
+	if value := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); value != "" {
+		webhookConfig.MaxAttempts, err = strconv.Atoi(value)
+		if err != nil || webhookConfig.MaxAttempts < 1 {
+			log.WithField("value", value).Fatal("Invalid WEBHOOK_MAX_ATTEMPTS")
+		}
+	}
+	webhookConfig.BaseBackoff, err = time.ParseDuration(getEnvWithDefault("WEBHOOK_BASE_BACKOFF", webhookConfig.BaseBackoff.String()))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid WEBHOOK_BASE_BACKOFF")
+	}
+	webhookConfig.Retention, err = time.ParseDuration(getEnvWithDefault("WEBHOOK_RETENTION", webhookConfig.Retention.String()))
+	if err != nil || webhookConfig.Retention < 0 {
+		log.WithField("value", os.Getenv("WEBHOOK_RETENTION")).Fatal("Invalid WEBHOOK_RETENTION")
+	}
+	webhookConfig.AllowPrivateTargets, err = strconv.ParseBool(getEnvWithDefault("WEBHOOK_ALLOW_PRIVATE_TARGETS", "false"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid WEBHOOK_ALLOW_PRIVATE_TARGETS")
+	}
+	webhooks := webhook.NewDispatcher(webhookStore, webhookConfig, log)
+
+	asyncWorkers, err := strconv.Atoi(getEnvWithDefault("ASYNC_WORKERS", "8"))
+	if err != nil || asyncWorkers < 1 {
+		log.Fatal("ASYNC_WORKERS must be a positive integer")
+	}
+	asyncQueueSize, err := strconv.Atoi(getEnvWithDefault("ASYNC_QUEUE_SIZE", "100"))
+	if err != nil || asyncQueueSize < 0 {
+		log.Fatal("ASYNC_QUEUE_SIZE must be a non-negative integer")
+	}
+	workers := worker.NewPool(asyncWorkers, asyncQueueSize)
+
+	threeDSThreshold, err := parseOptionalDecimal("THREEDS_AMOUNT_THRESHOLD")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid THREEDS_AMOUNT_THRESHOLD")
+	}
+	threeDSChallengeTTL, err := time.ParseDuration(getEnvWithDefault("THREEDS_CHALLENGE_TTL", "10m"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid THREEDS_CHALLENGE_TTL")
+	}
+	publicURL, err := parsePublicURL(getEnvWithDefault("PUBLIC_URL", "http://localhost:"+port))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid PUBLIC_URL")
+	}
+
+	riskEngine, err := newRiskEngine()
+	if err != nil {
+		log.WithError(err).Fatal("Invalid risk configuration")
+	}
+
+	cardVault, err := newVault()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize card vault")
+	}
+
+	paymentValidator := validator.NewStrictValidator(
+		validator.WithAcceptedBrands(acceptedBrands...),
+		validator.WithAmountLimits(minAmounts, maxAmounts),
+		validator.WithCurrencies(defaultCurrency, supportedCurrencies...),
+	)
+
+	handler := &paymentHandler{
+		validator:           paymentValidator,
+		processor:           paymentProcessor,
+		idempotency:         idempotency.NewStore(idempotencyTTL),
+		transactions:        transactions,
+		authorizationWindow: authorizationWindow,
+		defaultCurrency:     defaultCurrency,
+		webhooks:            webhooks,
+		workers:             workers,
+		notifier:            worker.NewNotifier(),
+		threeDSPolicy:       threeds.Policy{AmountThreshold: threeDSThreshold},
+		challenges:          threeds.NewService(threeDSChallengeTTL),
+		publicURL:           publicURL,
+		vault:               cardVault,
+		risk:                riskEngine,
+		ledger:              paymentLedger,
+	}
+	settlementLocation, err := time.LoadLocation(getEnvWithDefault("SETTLEMENT_TIMEZONE", "UTC"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid SETTLEMENT_TIMEZONE")
+	}
+	settlements, err := settlement.New(
+		getEnvWithDefault("SETTLEMENT_STORE", "memory"),
+		getEnvWithDefault("SETTLEMENT_STORE_PATH", "data/settlements.json"),
+	)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize settlement store")
+	}
+
+	webhookRoutes := &webhookHandler{dispatcher: webhooks}
+	tokenRoutes := &tokenHandler{vault: cardVault, validator: paymentValidator}
+	settlementRoutes := &settlementHandler{store: settlements, location: settlementLocation}
+	ledgerRoutes := &ledgerHandler{ledger: paymentLedger, transactions: transactions}
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if apiKeysFile := os.Getenv("API_KEYS_FILE"); apiKeysFile != "" {
+		reloadInterval, err := time.ParseDuration(getEnvWithDefault("API_KEYS_RELOAD_INTERVAL", "30s"))
+		if err != nil || reloadInterval <= 0 {
+			log.Fatal("API_KEYS_RELOAD_INTERVAL must be a positive duration")
+		}
+		go apiKeys.Watch(backgroundCtx, reloadInterval, log)
+	}
+	if certs != nil {
+		reloadInterval, err := time.ParseDuration(getEnvWithDefault("TLS_RELOAD_INTERVAL", "30s"))
+		if err != nil || reloadInterval <= 0 {
+			log.Fatal("TLS_RELOAD_INTERVAL must be a positive duration")
+		}
+		go certs.Watch(backgroundCtx, reloadInterval, log)
+	}
+	go runAuthorizationExpiry(backgroundCtx, transactions, paymentLedger, time.Minute)
+	go runSettlement(backgroundCtx, settlements, transactions, settlementLocation, time.Hour)
+	go webhooks.Run(backgroundCtx)
+	workers.Start(backgroundCtx)
 
 	router.GET("/pshealth", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -98,178 +311,85 @@ func main() {
 		})
 	})
 
-	protected := router.Group("/")
-	protected.Use(apiKeyAuthMiddleware())
-	{
-		protected.POST("/payment", func(c *gin.Context) {
-			requestID := uuid.New().String()
-			startTime := time.Now()
-
-			requestLogger := log.WithFields(logrus.Fields{
-				"request_id": requestID,
-				"client_ip":  c.ClientIP(),
-				"method":     c.Request.Method,
-				"path":       c.Request.URL.Path,
-			})
-
-			requestLogger.Info("Received payment request")
-
-			var req types.PaymentRequest
-			if err := c.ShouldBindJSON(&req); err != nil {
-				requestLogger.WithError(err).Warn("Invalid request format")
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error":      "Invalid request format",
-					"request_id": requestID,
-				})
-				return
-			}
-
-			req.Timestamp = time.Now()
-			errors := validator.Validate(req)
-			if len(errors) > 0 {
-				requestLogger.WithField("validation_errors", errors).Warn("Validation failed")
-				c.JSON(http.StatusUnprocessableEntity, gin.H{
-					"status":     "REJECT",
-					"errors":     errors,
-					"request_id": requestID,
-				})
-				return
-			}
-
-			transactionID := uuid.New().String()
-			requestLogger = requestLogger.WithField("transaction_id", transactionID)
-
-			status, err := paymentProcessor.ProcessPayment(req)
-
-			processingTime := time.Since(startTime).Milliseconds()
-
-			if err != nil {
-				requestLogger.WithFields(logrus.Fields{
-					"error":              err.Error(),
-					"status":             status,
-					"processing_time_ms": processingTime,
-				}).Error("Transaction processing failed")
-
-				c.JSON(http.StatusOK, gin.H{
-					"status":         status,
-					"message":        err.Error(),
-					"transaction_id": transactionID,
-					"request_id":     requestID,
-				})
-				return
-			}
-
-			requestLogger.WithFields(logrus.Fields{
-				"status":             status,
-				"processing_time_ms": processingTime,
-			}).Info("Transaction completed successfully")
-
-			c.JSON(http.StatusOK, gin.H{
-				"status":         status,
-				"message":        "Transaction processed successfully",
-				"transaction_id": transactionID,
-				"request_id":     requestID,
-			})
-		})
+	// Limiters are shared by both route prefixes so a caller cannot double its
+	// allowance by switching between them
+	rateLimit := func(group string, key ratelimit.KeyFunc) gin.HandlerFunc {
+		limiter, err := newRateLimiter(group)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid rate limit")
+		}
+		return ratelimit.Middleware(limiter, key, log)
 	}
+	// The client limit runs ahead of authentication so failed API key and
+	// signature checks are throttled too
+	clientLimit := rateLimit("clients", ratelimit.ClientIP)
+	challengeLimit := rateLimit("challenges", ratelimit.ClientIP)
+	paymentLimit := rateLimit("payments", rateLimitKey)
+	webhookLimit := rateLimit("webhooks", rateLimitKey)
+	tokenLimit := rateLimit("tokens", rateLimitKey)
+	settlementLimit := rateLimit("settlements", rateLimitKey)
+	ledgerLimit := rateLimit("ledger", rateLimitKey)
+
+	// Metrics reveal traffic and decline rates, so scraping them takes a key
+	// with the metrics scope and is limited per client IP
+	metricsRoute := []gin.HandlerFunc{clientLimit, apikey.Middleware(apiKeys, signatures, log), requireScope(scopeMetrics), metrics.GinHandler(metricsRegistry)}
+	router.GET("/metrics", metricsRoute...)
+	router.GET("/payment-service/metrics", metricsRoute...)
+
+	// 3-D Secure challenge pages are opened by the customer, not the API client
+	registerChallengeRoutes(router.Group("/", challengeLimit), handler)
+	registerChallengeRoutes(router.Group("/payment-service", challengeLimit), handler)
+
+	protected := router.Group("/")
+	protected.Use(clientLimit, apikey.Middleware(apiKeys, signatures, log))
+	registerPaymentRoutes(protected.Group("", paymentLimit), handler)
+	registerWebhookRoutes(protected.Group("", webhookLimit), webhookRoutes)
+	registerTokenRoutes(protected.Group("", tokenLimit), tokenRoutes)
+	registerSettlementRoutes(protected.Group("", settlementLimit), settlementRoutes)
+	registerLedgerRoutes(protected.Group("", ledgerLimit), ledgerRoutes)
 
 	// Added for production routes
 	protectedProd := router.Group("/payment-service")
-	protectedProd.Use(apiKeyAuthMiddleware())
-	{
-		protectedProd.POST("/payment", func(c *gin.Context) {
-			requestID := uuid.New().String()
-			startTime := time.Now()
-
-			requestLogger := log.WithFields(logrus.Fields{
-				"request_id": requestID,
-				"client_ip":  c.ClientIP(),
-				"method":     c.Request.Method,
-				"path":       c.Request.URL.Path,
-			})
-
-			requestLogger.Info("Received payment request")
-
-			var req types.PaymentRequest
-			if err := c.ShouldBindJSON(&req); err != nil {
-				requestLogger.WithError(err).Warn("Invalid request format")
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error":      "Invalid request format",
-					"request_id": requestID,
-				})
-				return
-			}
-
-			req.Timestamp = time.Now()
-			errors := validator.Validate(req)
-			if len(errors) > 0 {
-				requestLogger.WithField("validation_errors", errors).Warn("Validation failed")
-				c.JSON(http.StatusUnprocessableEntity, gin.H{
-					"status":     "REJECT",
-					"errors":     errors,
-					"request_id": requestID,
-				})
-				return
-			}
-
-			transactionID := uuid.New().String()
-			requestLogger = requestLogger.WithField("transaction_id", transactionID)
-
-			status, err := paymentProcessor.ProcessPayment(req)
-
-			processingTime := time.Since(startTime).Milliseconds()
-
-			if err != nil {
-				requestLogger.WithFields(logrus.Fields{
-					"error":              err.Error(),
-					"status":             status,
-					"processing_time_ms": processingTime,
-				}).Error("Transaction processing failed")
-
-				c.JSON(http.StatusOK, gin.H{
-					"status":         status,
-					"message":        err.Error(),
-					"transaction_id": transactionID,
-					"request_id":     requestID,
-				})
-				return
-			}
-
-			requestLogger.WithFields(logrus.Fields{
-				"status":             status,
-				"processing_time_ms": processingTime,
-			}).Info("Transaction completed successfully")
-
-			c.JSON(http.StatusOK, gin.H{
-				"status":         status,
-				"message":        "Transaction processed successfully",
-				"transaction_id": transactionID,
-				"request_id":     requestID,
-			})
-		})
-	}
+	protectedProd.Use(clientLimit, apikey.Middleware(apiKeys, signatures, log))
+	registerPaymentRoutes(protectedProd.Group("", paymentLimit), handler)
+	registerWebhookRoutes(protectedProd.Group("", webhookLimit), webhookRoutes)
+	registerTokenRoutes(protectedProd.Group("", tokenLimit), tokenRoutes)
+	registerSettlementRoutes(protectedProd.Group("", settlementLimit), settlementRoutes)
+	registerLedgerRoutes(protectedProd.Group("", ledgerLimit), ledgerRoutes)
 
 	router.NoRoute(func(c *gin.Context) {
-		log.WithFields(logrus.Fields{
+		log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 			"client_ip": c.ClientIP(),
 			"method":    c.Request.Method,
 			"path":      c.Request.URL.Path,
 		}).Warn("Route not found")
 
-		c.JSON(http.StatusNotFound, gin.H{
-			"status": "NOT_FOUND",
-			"error":  "There is nothing to do here! 404!",
-		})
+		problem.NotFound(c, "No route matches "+c.Request.Method+" "+c.Request.URL.Path)
 	})
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: router,
 	}
+	if certs != nil {
+		srv.TLSConfig = certs.TLSConfig()
+	}
 
 	go func() {
-		log.WithField("port", port).Info("Server is running")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithFields(logrus.Fields{
+			"port":       port,
+			"tls":        certs != nil,
+			"mutual_tls": certs != nil && certs.MutualTLS(),
+		}).Info("Server is running")
+
+		var err error
+		if certs != nil {
+			// The certificate comes from srv.TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -287,9 +407,406 @@ func main() {
 		log.WithError(err).Fatal("Server forced to shutdown")
 	}
 
+	if err := workers.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("Async payments still in progress at shutdown")
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.WithError(err).Error("Failed to flush traces")
+	}
+
 	log.Info("Server exited gracefully")
 }
 
+func newProcessor() (processor.Processor, error) {
+	cfg := processor.DefaultSimulatorConfig()
+
+	if value := os.Getenv("PROCESSOR_FAILURE_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("PROCESSOR_FAILURE_RATE must be between 0 and 1, got %q", value)
+		}
+		cfg.FailureRate = rate
+	}
+
+	if value := os.Getenv("PROCESSOR_LATENCY_MS"); value != "" {
+		minLatency, maxLatency, err := processor.ParseLatencyRange(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROCESSOR_LATENCY_MS: %w", err)
+		}
+		cfg.MinLatency, cfg.MaxLatency = minLatency, maxLatency
+	}
+
+	if value := os.Getenv("PROCESSOR_SEED"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROCESSOR_SEED: %w", err)
+		}
+		cfg.Seed = seed
+	}
+
+	if value := os.Getenv("PROCESSOR_DECLINE_CODES"); value != "" {
+		declines, err := processor.ParseDeclineDistribution(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROCESSOR_DECLINE_CODES: %w", err)
+		}
+		cfg.Declines = declines
+	}
+
+	kind := getEnvWithDefault("PROCESSOR", "simulator")
+	log.WithFields(logrus.Fields{
+		"processor":    kind,
+		"failure_rate": cfg.FailureRate,
+		"min_latency":  cfg.MinLatency.String(),
+		"max_latency":  cfg.MaxLatency.String(),
+		"seed":         cfg.Seed,
+	}).Info("Configured payment processor")
+
+	return processor.New(kind, cfg)
+}
+
+// newAPIKeyRegistry loads API keys from API_KEYS_FILE. A single API_KEY is
+// still accepted as a key named "default" with every scope. It returns nil
+// when neither is set.
+func newAPIKeyRegistry() (*apikey.Registry, error) {
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		if os.Getenv("API_KEY") != "" {
+			log.Warn("API_KEY is ignored because API_KEYS_FILE is set")
+		}
+		return apikey.LoadFile(path)
+	}
+	if key := os.Getenv("API_KEY"); key != "" {
+		return apikey.NewRegistry(apikey.Key{
+			Name:   "default",
+			Hash:   apikey.Hash(key),
+			Scopes: []string{apikey.ScopeAll},
+		})
+	}
+	return nil, nil
+}
+
+// newSignatureVerifier configures signed request checks for registry. It
+// returns nil when authentication is disabled.
+func newSignatureVerifier(registry *apikey.Registry) (*apikey.Verifier, error) {
+	if registry == nil {
+		return nil, nil
+	}
+
+	cfg := apikey.DefaultSignatureConfig()
+	var err error
+
+	if value := os.Getenv("API_SIGNATURE_MAX_SKEW"); value != "" {
+		cfg.MaxSkew, err = time.ParseDuration(value)
+		if err != nil || cfg.MaxSkew <= 0 {
+			return nil, errors.New("API_SIGNATURE_MAX_SKEW must be a positive duration")
+		}
+	}
+	if value := os.Getenv("API_SIGNATURE_MAX_BODY_BYTES"); value != "" {
+		cfg.MaxBodyBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || cfg.MaxBodyBytes <= 0 {
+			return nil, errors.New("API_SIGNATURE_MAX_BODY_BYTES must be a positive integer")
+		}
+	}
+	cfg.Required, err = strconv.ParseBool(getEnvWithDefault("API_REQUIRE_SIGNATURE", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_REQUIRE_SIGNATURE: %w", err)
+	}
+
+	return apikey.NewVerifier(registry, cfg), nil
+}
+
+// newTLSReloader configures TLS from TLS_* variables. It returns nil when no
+// certificate is set, in which case the server speaks plain HTTP.
+func newTLSReloader() (*mtls.Reloader, error) {
+	cfg := mtls.DefaultConfig()
+	cfg.CertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.KeyFile = os.Getenv("TLS_KEY_FILE")
+	cfg.ClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	cfg.ClientAuth = getEnvWithDefault("TLS_CLIENT_AUTH", cfg.ClientAuth)
+
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		if cfg.ClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	if value := os.Getenv("TLS_MIN_VERSION"); value != "" {
+		version, err := mtls.ParseVersion(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS_MIN_VERSION: %w", err)
+		}
+		cfg.MinVersion = version
+	}
+
+	return mtls.NewReloader(cfg)
+}
+
+// newRateLimiter configures the limit for a route group from
+// RATE_LIMIT_<GROUP>, falling back to RATE_LIMIT.
+func newRateLimiter(group string) (*ratelimit.Limiter, error) {
+	key := "RATE_LIMIT_" + strings.ToUpper(group)
+	value := getEnvWithDefault(key, getEnvWithDefault("RATE_LIMIT", "600/m"))
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"group": group,
+		"limit": limit.String(),
+	}).Info("Configured rate limit")
+
+	return ratelimit.New(limit), nil
+}
+
+// newRiskEngine configures fraud screening from RISK_* variables. It returns
+// nil when RISK_ENABLED is false.
+func newRiskEngine() (*risk.Engine, error) {
+	enabled, err := strconv.ParseBool(getEnvWithDefault("RISK_ENABLED", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RISK_ENABLED: %w", err)
+	}
+	if !enabled {
+		log.Warn("Risk screening is disabled")
+		return nil, nil
+	}
+
+	cfg := risk.DefaultConfig()
+
+	if value := os.Getenv("RISK_WINDOW"); value != "" {
+		cfg.Window, err = time.ParseDuration(value)
+		if err != nil || cfg.Window <= 0 {
+			return nil, fmt.Errorf("RISK_WINDOW must be a positive duration, got %q", value)
+		}
+	}
+
+	limits := []struct {
+		key   string
+		value *int
+	}{
+		{"RISK_CARD_LIMIT", &cfg.CardAttempts},
+		{"RISK_IP_LIMIT", &cfg.IPAttempts},
+		{"RISK_CALLER_LIMIT", &cfg.CallerAttempts},
+		{"RISK_DECLINE_LIMIT", &cfg.Declines},
+		{"RISK_NAME_LIMIT", &cfg.CardNames},
+		{"RISK_REVIEW_SCORE", &cfg.ReviewScore},
+		{"RISK_BLOCK_SCORE", &cfg.BlockScore},
+	}
+	for _, limit := range limits {
+		value := os.Getenv(limit.key)
+		if value == "" {
+			continue
+		}
+		*limit.value, err = strconv.Atoi(value)
+		if err != nil || *limit.value < 1 {
+			return nil, fmt.Errorf("%s must be a positive integer, got %q", limit.key, value)
+		}
+	}
+
+	if value := os.Getenv("RISK_OUTLIER_FACTOR"); value != "" {
+		cfg.OutlierFactor, err = strconv.ParseFloat(value, 64)
+		if err != nil || cfg.OutlierFactor <= 1 {
+			return nil, fmt.Errorf("RISK_OUTLIER_FACTOR must be greater than 1, got %q", value)
+		}
+	}
+
+	if cfg.ReviewScore > cfg.BlockScore {
+		return nil, errors.New("RISK_REVIEW_SCORE must not exceed RISK_BLOCK_SCORE")
+	}
+
+	log.WithFields(logrus.Fields{
+		"window":       cfg.Window.String(),
+		"review_score": cfg.ReviewScore,
+		"block_score":  cfg.BlockScore,
+	}).Info("Configured risk engine")
+
+	return risk.NewEngine(cfg), nil
+}
+
+// newVault opens the card vault. The key comes from VAULT_KEY or
+// VAULT_KEY_FILE; without one an in-memory vault gets a random key, and its
+// tokens do not outlive the process.
+func newVault() (*vault.Vault, error) {
+	keyValue := os.Getenv("VAULT_KEY")
+	if path := os.Getenv("VAULT_KEY_FILE"); keyValue == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read VAULT_KEY_FILE: %w", err)
+		}
+		keyValue = string(data)
+	}
+
+	kind := getEnvWithDefault("VAULT_STORE", "memory")
+
+	var key []byte
+	var err error
+	switch {
+	case keyValue != "":
+		key, err = vault.ParseKey(keyValue)
+	case kind == "file":
+		return nil, errors.New("VAULT_KEY or VAULT_KEY_FILE is required for the file vault store")
+	default:
+		log.Warn("No VAULT_KEY set. Card tokens will not survive a restart")
+		key, err = vault.GenerateKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var store *vault.Store
+	switch kind {
+	case "memory":
+		store = vault.NewMemoryStore()
+	case "file":
+		store, err = vault.NewFileStore(getEnvWithDefault("VAULT_STORE_PATH", "data/vault.json"))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown VAULT_STORE %q", kind)
+	}
+
+	return vault.New(key, store)
+}
+
+// newLedger charges the fees in LEDGER_FEES and posts the entries of every
+// stored transaction, since the ledger itself is kept in memory. A fixed fee
+// without a currency is charged in the default currency only.
+func newLedger(transactions repository.TransactionRepository, defaultCurrency string) (*ledger.Ledger, error) {
+	fees, err := ledger.ParseFeeSchedule(getEnvWithDefault("LEDGER_FEES", "2.9%+USD:0.30"), defaultCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LEDGER_FEES: %w", err)
+	}
+
+	paymentLedger := ledger.New(fees)
+	posted, err := paymentLedger.SyncAll(transactions)
+	if err != nil {
+		return nil, err
+	}
+	if err := paymentLedger.Verify(); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{
+		"fee_rate":  fees.Rate.String(),
+		"fee_fixed": fees.Fixed,
+		"entries":   posted,
+	}).Info("Configured ledger")
+
+	return paymentLedger, nil
+}
+
+func runAuthorizationExpiry(ctx context.Context, transactions repository.TransactionRepository, paymentLedger *ledger.Ledger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expired, err := lifecycle.ExpireAuthorizations(transactions, now)
+			if err != nil {
+				log.WithError(err).Error("Failed to expire authorizations")
+				continue
+			}
+			if expired > 0 {
+				log.WithField("expired", expired).Info("Expired uncaptured authorizations")
+				if _, err := paymentLedger.SyncAll(transactions); err != nil {
+					log.WithError(err).Error("Failed to post ledger entries for expired authorizations")
+				}
+			}
+		}
+	}
+}
+
+// runSettlement closes each finished day into settlement batches. It runs
+// once at startup so days that ended while the gateway was down are closed
+// straight away.
+func runSettlement(ctx context.Context, store *settlement.Store, transactions repository.TransactionRepository, loc *time.Location, interval time.Duration) {
+	closeDays := func(now time.Time) {
+		batches, err := settlement.Close(store, transactions, now, loc)
+		if err != nil {
+			log.WithError(err).Error("Failed to close settlement batches")
+			return
+		}
+		if len(batches) > 0 {
+			log.WithFields(logrus.Fields{
+				"batches":        len(batches),
+				"closed_through": store.ClosedThrough(),
+			}).Info("Closed settlement batches")
+		}
+	}
+
+	closeDays(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			closeDays(now)
+		}
+	}
+}
+
+// newAmountLimits reads MIN_TRANSACTION_AMOUNT and MAX_TRANSACTION_AMOUNT.
+// Limits are per currency, since one number means very different sums in
+// USD and JPY; a bare amount is a limit in the default currency.
+func newAmountLimits(defaultCurrency string, supported []string) (validator.AmountLimits, validator.AmountLimits, error) {
+	minAmounts, err := validator.ParseAmountLimits(os.Getenv("MIN_TRANSACTION_AMOUNT"), defaultCurrency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid MIN_TRANSACTION_AMOUNT: %w", err)
+	}
+	maxAmounts, err := validator.ParseAmountLimits(os.Getenv("MAX_TRANSACTION_AMOUNT"), defaultCurrency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid MAX_TRANSACTION_AMOUNT: %w", err)
+	}
+
+	for _, limits := range []validator.AmountLimits{minAmounts, maxAmounts} {
+		for code := range limits {
+			if !slices.Contains(supported, code) {
+				return nil, nil, fmt.Errorf("amount limit set for %s, which is not in SUPPORTED_CURRENCIES", code)
+			}
+		}
+	}
+	for code, max := range maxAmounts {
+		if min, ok := minAmounts[code]; ok && min.Cmp(max) > 0 {
+			return nil, nil, fmt.Errorf("minimum %s amount %s is above the maximum %s", code, min, max)
+		}
+	}
+	return minAmounts, maxAmounts, nil
+}
+
+// parsePublicURL checks that value is an absolute http or https URL and drops
+// any trailing slash, so paths can be appended to it.
+func parsePublicURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q is not an absolute http or https URL", value)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+func parseOptionalDecimal(key string) (*decimal.Decimal, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+	d, err := decimal.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
 func getEnvWithDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	paymentOutcomes = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_outcomes_total",
+		Help: "Payments by mode, final status and decline reason.",
+	}, []string{"mode", "status", "decline_reason"})
+	paymentProcessingSeconds = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "payment_processing_seconds",
+		Help:    "Time from receiving a payment to its final status, by mode and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mode", "status"})
+	validationFailures = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_validation_failures_total",
+		Help: "Rejected request fields by endpoint and field.",
+	}, []string{"endpoint", "field"})
+)
+
+// recordOutcome counts a payment that reached a final status. code is
+// empty unless the payment failed.
+func recordOutcome(mode paymentMode, status string, code types.DeclineCode, startTime time.Time) {
+	if status != types.StatusFailed {
+		code = ""
+	}
+	paymentOutcomes.WithLabelValues(string(mode), status, string(code)).Inc()
+	paymentProcessingSeconds.WithLabelValues(string(mode), status).Observe(time.Since(startTime).Seconds())
+}
+
+func recordValidationFailures(endpoint string, errors []types.ValidationError) {
+	for _, err := range errors {
+		validationFailures.WithLabelValues(endpoint, err.Field).Inc()
+	}
+}
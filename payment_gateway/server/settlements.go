@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/settlement"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/sirupsen/logrus"
+)
+
+type settlementHandler struct {
+	store    *settlement.Store
+	location *time.Location
+}
+
+func registerSettlementRoutes(group *gin.RouterGroup, h *settlementHandler) {
+	settlements := group.Group("/settlements", requireScope(scopeSettlements))
+	settlements.GET("", h.listBatches)
+	settlements.GET("/report", h.downloadReport)
+	settlements.GET("/:batch_id", h.getBatch)
+}
+
+// dateRange reads the from and to query parameters. Missing dates are
+// returned as "" unless required.
+func dateRange(c *gin.Context, required bool) (string, string, []types.ValidationError) {
+	var errs []types.ValidationError
+	parse := func(field string) string {
+		value := c.Query(field)
+		if value == "" {
+			if required {
+				errs = append(errs, types.ValidationError{Field: field, Message: field + " is required"})
+			}
+			return ""
+		}
+		date, err := settlement.ParseDate(value)
+		if err != nil {
+			errs = append(errs, types.ValidationError{Field: field, Message: err.Error()})
+		}
+		return date
+	}
+
+	from, to := parse("from"), parse("to")
+	if from != "" && to != "" && from > to {
+		errs = append(errs, types.ValidationError{Field: "to", Message: "to must not be before from"})
+	}
+	return from, to, errs
+}
+
+// listBatches lists the caller's closed batches without their entries.
+func (h *settlementHandler) listBatches(c *gin.Context) {
+	from, to, errs := dateRange(c, false)
+	if len(errs) > 0 {
+		problem.Respond(c, problem.Validation(errs))
+		return
+	}
+
+	batches := h.store.Batches(settlement.Filter{Merchant: callerID(c), From: from, To: to})
+	for i := range batches {
+		batches[i].Entries = nil
+	}
+	if batches == nil {
+		batches = []settlement.Batch{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"closed_through": h.store.ClosedThrough(),
+		"batches":        batches,
+	})
+}
+
+func (h *settlementHandler) getBatch(c *gin.Context) {
+	batch, err := h.store.Batch(c.Param("batch_id"))
+	// Batches of other merchants are reported as missing
+	if errors.Is(err, settlement.ErrNotFound) || (err == nil && batch.Merchant != callerID(c)) {
+		problem.NotFound(c, "Settlement batch with requested ID not found")
+		return
+	}
+	if err != nil {
+		log.WithContext(c.Request.Context()).WithError(err).Error("Failed to load settlement batch")
+		problem.Internal(c, "Failed to load settlement batch")
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// downloadReport returns a reconciliation report of the caller's closed
+// batches as a JSON or CSV attachment.
+func (h *settlementHandler) downloadReport(c *gin.Context) {
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip": c.ClientIP(),
+		"key_name":  callerID(c),
+		"method":    c.Request.Method,
+		"path":      c.Request.URL.Path,
+	})
+
+	from, to, errs := dateRange(c, true)
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		errs = append(errs, types.ValidationError{Field: "format", Message: `format must be "json" or "csv"`})
+	}
+	level, err := settlement.ParseLevel(c.DefaultQuery("level", string(settlement.LevelBatch)))
+	if err != nil {
+		errs = append(errs, types.ValidationError{Field: "level", Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		requestLogger.Warn("Invalid settlement report request")
+		problem.Respond(c, problem.Validation(errs))
+		return
+	}
+
+	batches := h.store.Batches(settlement.Filter{Merchant: callerID(c), From: from, To: to})
+	report, err := settlement.NewReport(from, to, h.location, level, batches, time.Now())
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to build settlement report")
+		problem.Internal(c, "Failed to build settlement report")
+		return
+	}
+	report.ClosedThrough = h.store.ClosedThrough()
+
+	requestLogger.WithFields(logrus.Fields{
+		"from":    from,
+		"to":      to,
+		"format":  format,
+		"level":   level,
+		"batches": len(report.Batches),
+	}).Info("Settlement report generated")
+
+	if format == "csv" {
+		var body bytes.Buffer
+		if err := report.WriteCSV(&body); err != nil {
+			requestLogger.WithError(err).Error("Failed to write settlement report")
+			problem.Internal(c, "Failed to build settlement report")
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+report.Filename("csv")+`"`)
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", body.Bytes())
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+report.Filename("json")+`"`)
+	c.JSON(http.StatusOK, report)
+}
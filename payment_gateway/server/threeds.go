@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/lifecycle"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/threeds"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/webhook"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+const nextActionRedirect = "redirect_to_url"
+
+// challengePage stands in for the issuer's authentication page. Since the
+// flow is simulated, the one-time code is shown on the page itself.
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Skyfox 3-D Secure</title></head>
+<body>
+<h1>Verify your payment</h1>
+{{if eq .Status "PENDING"}}
+<p>Simulated issuer: your one-time code is <strong>{{.Code}}</strong></p>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<form method="POST">
+<input name="code" autocomplete="one-time-code" autofocus>
+<button type="submit">Verify</button>
+</form>
+{{else if eq .Status "FAILED"}}
+<p>Authentication failed. {{.Error}} You may close this window.</p>
+{{else}}
+<p>Authentication complete. You may close this window.</p>
+{{end}}
+</body>
+</html>
+`))
+
+type challengeView struct {
+	Status string
+	Code   string
+	Error  string
+}
+
+// registerChallengeRoutes serves the challenge page. These routes are opened
+// by the customer's browser, so they sit outside the API key middleware and
+// are guarded by the unguessable challenge ID instead.
+func registerChallengeRoutes(group *gin.RouterGroup, h *paymentHandler) {
+	group.GET("/3ds/:challenge_id", h.showChallenge)
+	group.POST("/3ds/:challenge_id", h.submitChallenge)
+}
+
+// requireChallenge parks a payment that needs 3-D Secure in REQUIRES_ACTION
+// and points the customer at the challenge page.
+func (h *paymentHandler) requireChallenge(c *gin.Context, requestLogger *logrus.Entry, mode paymentMode, req types.PaymentRequest, txn types.Transaction, scope, idempotencyKey string) {
+	txn.Status = types.StatusRequiresAction
+	txn.Message = "3-D Secure authentication required"
+
+	challenge, err := h.challenges.Create(txn.TransactionID, string(mode), req)
+	if err == nil {
+		err = h.transactions.Save(txn)
+	}
+	if err != nil {
+		if idempotencyKey != "" {
+			h.idempotency.Release(scope, idempotencyKey)
+		}
+		requestLogger.WithError(err).Error("Failed to start 3-D Secure authentication")
+		problem.Internal(c, "Failed to start 3-D Secure authentication")
+		return
+	}
+
+	requestLogger.WithField("challenge_id", challenge.ID).Info("3-D Secure challenge required")
+
+	response := types.PaymentResponse{
+		Status:        txn.Status,
+		Message:       txn.Message,
+		TransactionID: txn.TransactionID,
+		RequestID:     txn.RequestID,
+		CardBrand:     txn.CardBrand,
+		Amount:        &txn.Amount,
+		Currency:      txn.Currency,
+		ExpiresAt:     &challenge.ExpiresAt,
+		Risk:          txn.Risk,
+		NextAction: &types.NextAction{
+			Type: nextActionRedirect,
+			URL:  h.challengeURL(c, challenge.ID),
+		},
+	}
+
+	if idempotencyKey != "" {
+		h.idempotency.Complete(scope, idempotencyKey, http.StatusOK, response)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// challengeURL builds an absolute link to the challenge page under the
+// configured public URL, keeping the /payment-service prefix for production
+// routes. Request headers such as Host and X-Forwarded-Host are not trusted,
+// so a client cannot make the gateway hand out links to another site.
+func (h *paymentHandler) challengeURL(c *gin.Context, challengeID string) string {
+	prefix := ""
+	if strings.HasPrefix(c.Request.URL.Path, "/payment-service/") {
+		prefix = "/payment-service"
+	}
+
+	return h.publicURL + prefix + "/3ds/" + challengeID
+}
+
+func (h *paymentHandler) showChallenge(c *gin.Context) {
+	challenge, err := h.challenges.Get(c.Param("challenge_id"))
+	if err != nil {
+		problem.NotFound(c, "Challenge with requested ID not found")
+		return
+	}
+
+	view := challengeView{Status: challenge.Status, Code: challenge.Code}
+	if challenge.Status == threeds.ChallengePending && time.Now().After(challenge.ExpiresAt) {
+		view.Status = threeds.ChallengeFailed
+		view.Error = threeds.ErrExpired.Error() + "."
+	}
+	renderChallenge(c, log.WithContext(c.Request.Context()).WithField("challenge_id", challenge.ID), view)
+}
+
+// renderChallenge renders the page before writing anything, so a template
+// error can still be answered with a problem instead of a truncated page.
+func renderChallenge(c *gin.Context, requestLogger *logrus.Entry, view challengeView) {
+	var page bytes.Buffer
+	if err := challengePage.Execute(&page, view); err != nil {
+		requestLogger.WithError(err).Error("Failed to render challenge page")
+		problem.Internal(c, "Failed to render challenge page")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+func (h *paymentHandler) submitChallenge(c *gin.Context) {
+	challengeID := c.Param("challenge_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip":    c.ClientIP(),
+		"method":       c.Request.Method,
+		"path":         c.Request.URL.Path,
+		"challenge_id": challengeID,
+	})
+
+	wantsJSON := c.ContentType() == "application/json"
+	var code string
+	if wantsJSON {
+		var body struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			requestLogger.WithError(err).Warn("Invalid request format")
+			problem.BadRequest(c, "Request body is malformed or missing required fields")
+			return
+		}
+		code = body.Code
+	} else {
+		code = c.PostForm("code")
+	}
+
+	challenge, err := h.challenges.Verify(challengeID, strings.TrimSpace(code))
+	if errors.Is(err, threeds.ErrNotFound) {
+		requestLogger.Warn("Challenge not found")
+		problem.NotFound(c, "Challenge with requested ID not found")
+		return
+	}
+
+	requestLogger = requestLogger.WithFields(logrus.Fields{
+		"transaction_id":   challenge.TransactionID,
+		"challenge_status": challenge.Status,
+		"attempts":         challenge.Attempts,
+	})
+	if err != nil {
+		requestLogger.WithError(err).Warn("3-D Secure verification rejected")
+	} else {
+		requestLogger.Info("3-D Secure challenge authenticated")
+	}
+
+	if wantsJSON {
+		if err != nil {
+			problem.Respond(c, problem.Validation([]types.ValidationError{{Field: "code", Message: err.Error()}}).
+				With("challenge_status", challenge.Status).
+				With("transaction_id", challenge.TransactionID))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":         challenge.Status,
+			"transaction_id": challenge.TransactionID,
+		})
+		return
+	}
+
+	view := challengeView{Status: challenge.Status, Code: challenge.Code}
+	if err != nil {
+		view.Error = err.Error() + "."
+	}
+	renderChallenge(c, requestLogger, view)
+}
+
+// completeChallenge resumes a payment once its 3-D Secure challenge has been
+// answered. Authenticated payments go on to the processor; failed or expired
+// challenges decline the transaction without contacting it.
+func (h *paymentHandler) completeChallenge(c *gin.Context) {
+	requestID := tracing.RequestID(c)
+	startTime := time.Now()
+	transactionID := c.Param("transaction_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"request_id":     requestID,
+		"client_ip":      c.ClientIP(),
+		"key_name":       callerID(c),
+		"method":         c.Request.Method,
+		"path":           c.Request.URL.Path,
+		"transaction_id": transactionID,
+	})
+	requestLogger.Info("Received 3-D Secure completion request")
+
+	txn, err := h.findOwned(transactionID, callerID(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		requestLogger.Warn("Transaction not found")
+		problem.NotFound(c, "Transaction with requested ID not found")
+		return
+	}
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to look up transaction")
+		problem.Internal(c, "Failed to look up transaction")
+		return
+	}
+	if txn.Status != types.StatusRequiresAction {
+		requestLogger.WithField("status", txn.Status).Warn("Transaction does not require action")
+		problem.Respond(c, problem.New(problem.TypeConflict, http.StatusConflict, "transaction is not awaiting 3-D Secure authentication"))
+		return
+	}
+
+	challenge, err := h.challenges.Complete(transactionID)
+	switch {
+	case errors.Is(err, threeds.ErrNotAuthenticated):
+		requestLogger.Warn("3-D Secure challenge not yet authenticated")
+		problem.Respond(c, problem.New(problem.TypeConflict, http.StatusConflict, err.Error()).
+			With("transaction_status", types.StatusRequiresAction))
+		return
+	case err != nil:
+		// Challenges live in memory, so one lost to a restart can never be
+		// answered; decline the payment rather than leave it hanging.
+		requestLogger.WithError(err).Warn("3-D Secure challenge unavailable")
+		challenge.Status = threeds.ChallengeFailed
+	}
+
+	requestLogger = requestLogger.WithField("challenge_id", challenge.ID)
+	mode := challengeMode(challenge, txn)
+
+	if challenge.Status == threeds.ChallengeFailed {
+		owner := callerID(c)
+		txn, err = h.transactions.Update(transactionID, func(txn *types.Transaction) error {
+			if err := checkOwner(txn, owner); err != nil {
+				return err
+			}
+			// Another completion may have finished the payment meanwhile
+			if txn.Status != types.StatusRequiresAction {
+				return lifecycle.ErrInvalidTransition
+			}
+			txn.CompletionRequestID = requestID
+			txn.Status = types.StatusFailed
+			txn.Message = "3-D Secure authentication failed"
+			txn.DeclineCode = types.DeclineThreeDS
+			txn.UpdatedAt = time.Now()
+			return nil
+		})
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			requestLogger.Warn("Transaction not found")
+			problem.NotFound(c, "Transaction with requested ID not found")
+			return
+		case errors.Is(err, lifecycle.ErrInvalidTransition):
+			requestLogger.Warn("Transaction no longer requires action")
+			problem.Respond(c, problem.New(problem.TypeConflict, http.StatusConflict, "transaction is not awaiting 3-D Secure authentication"))
+			return
+		case err != nil:
+			requestLogger.WithError(err).Error("Failed to record transaction")
+			problem.Internal(c, "Failed to record transaction")
+			return
+		}
+		h.notifier.Notify(txn.TransactionID)
+		h.publishEvent(requestLogger, webhook.EventPaymentFailed, txn)
+		if h.risk != nil {
+			h.risk.RecordOutcome(txn.TransactionID, true)
+		}
+		recordOutcome(mode, txn.Status, txn.DeclineCode, startTime)
+		requestLogger.Warn("3-D Secure authentication failed")
+
+		response := types.PaymentResponse{
+			Status:        txn.Status,
+			Message:       txn.Message,
+			TransactionID: txn.TransactionID,
+			RequestID:     requestID,
+			CardBrand:     txn.CardBrand,
+			Amount:        &txn.Amount,
+			Currency:      txn.Currency,
+			Risk:          txn.Risk,
+		}
+		response.Decline(txn.DeclineCode)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	txn.CompletionRequestID = requestID
+	_, response := h.completePayment(c.Request.Context(), requestLogger, mode, challenge.Request, txn, startTime)
+	response.RequestID = requestID
+	c.JSON(http.StatusOK, response)
+}
+
+// challengeMode is the mode of the payment a challenge belongs to. A
+// challenge lost to a restart has none, so the transaction's is used, and
+// records from before the mode was kept are sales.
+func challengeMode(challenge threeds.Challenge, txn types.Transaction) paymentMode {
+	switch {
+	case challenge.Mode != "":
+		return paymentMode(challenge.Mode)
+	case txn.Mode != "":
+		return paymentMode(txn.Mode)
+	}
+	return paymentModeSale
+}
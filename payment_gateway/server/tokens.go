@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/redact"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/validator"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/vault"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+type tokenHandler struct {
+	vault     *vault.Vault
+	validator validator.PaymentValidator
+}
+
+func registerTokenRoutes(group *gin.RouterGroup, h *tokenHandler) {
+	tokens := group.Group("/tokens", requireScope(scopeTokens))
+	tokens.POST("", h.createToken)
+	tokens.GET("/:token", h.getToken)
+	tokens.DELETE("/:token", h.deleteToken)
+}
+
+func (h *tokenHandler) createToken(c *gin.Context) {
+	requestID := tracing.RequestID(c)
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"request_id": requestID,
+		"client_ip":  c.ClientIP(),
+		"key_name":   callerID(c),
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+	})
+	requestLogger.Info("Received tokenization request")
+
+	var card types.CardDetails
+	if err := c.ShouldBindJSON(&card); err != nil {
+		requestLogger.WithError(err).Warn("Invalid request format")
+		problem.BadRequest(c, "Request body is malformed or missing required fields")
+		return
+	}
+
+	if errors := h.validator.ValidateCard(card); len(errors) > 0 {
+		recordValidationFailures("tokens", errors)
+		requestLogger.WithField("validation_errors", errors).Warn("Validation failed")
+		problem.Respond(c, problem.Validation(errors))
+		return
+	}
+
+	stored, err := h.vault.Tokenize(callerID(c), card, time.Now())
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to tokenize card")
+		problem.Internal(c, "Failed to tokenize card")
+		return
+	}
+
+	requestLogger.WithFields(logrus.Fields{
+		"token":       stored.Token,
+		"masked_card": redact.CardNumber(card.CardNumber),
+	}).Info("Card tokenized successfully")
+
+	response := tokenResponse(stored)
+	response.RequestID = requestID
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *tokenHandler) getToken(c *gin.Context) {
+	stored, err := h.vault.Get(callerID(c), c.Param("token"))
+	if err != nil {
+		h.respondTokenError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(stored))
+}
+
+func (h *tokenHandler) deleteToken(c *gin.Context) {
+	token := c.Param("token")
+	if err := h.vault.Delete(callerID(c), token); err != nil {
+		h.respondTokenError(c, err)
+		return
+	}
+
+	log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip": c.ClientIP(),
+		"key_name":  callerID(c),
+		"token":     token,
+	}).Info("Card token deleted")
+	c.Status(http.StatusNoContent)
+}
+
+func (h *tokenHandler) respondTokenError(c *gin.Context, err error) {
+	if errors.Is(err, vault.ErrNotFound) {
+		problem.NotFound(c, "Card token with requested ID not found")
+		return
+	}
+	log.WithError(err).Error("Card vault operation failed")
+	problem.Internal(c, "Failed to access card vault")
+}
+
+func tokenResponse(card vault.Card) types.TokenResponse {
+	return types.TokenResponse{
+		Token:     card.Token,
+		CardBrand: card.CardBrand,
+		Last4:     card.Last4,
+		Expiry:    card.Expiry,
+		CreatedAt: card.CreatedAt,
+	}
+}
+
+// resolveCardToken swaps req.CardToken for the card it stands for. It writes
+// the error response and returns false when the token cannot be used.
+func (h *paymentHandler) resolveCardToken(c *gin.Context, requestLogger *logrus.Entry, req *types.PaymentRequest) bool {
+	if req.CardNumber != "" || req.Expiry != "" || req.Name != "" {
+		requestLogger.Warn("Card token combined with card details")
+		problem.BadRequest(c, "card_token cannot be combined with card_number, expiry or name")
+		return false
+	}
+
+	card, err := h.vault.Detokenize(callerID(c), req.CardToken)
+	if errors.Is(err, vault.ErrNotFound) {
+		requestLogger.Warn("Card token not found")
+		problem.Respond(c, problem.Validation([]types.ValidationError{{Field: "card_token", Message: "Card token not found"}}))
+		return false
+	}
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to read card token")
+		problem.Internal(c, "Failed to access card vault")
+		return false
+	}
+
+	req.CardNumber = card.CardNumber
+	req.Expiry = card.Expiry
+	req.Name = card.Name
+	return true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/webhook"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/sirupsen/logrus"
+)
+
+type webhookHandler struct {
+	dispatcher *webhook.Dispatcher
+}
+
+type registerWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+}
+
+func registerWebhookRoutes(group *gin.RouterGroup, h *webhookHandler) {
+	webhooks := group.Group("/webhooks", requireScope(scopeWebhooks))
+	webhooks.POST("", h.registerEndpoint)
+	webhooks.GET("", h.listEndpoints)
+	webhooks.DELETE("/:webhook_id", h.deleteEndpoint)
+	webhooks.GET("/deliveries", h.listDeliveries)
+	webhooks.POST("/deliveries/:delivery_id/replay", h.replayDelivery)
+}
+
+func (h *webhookHandler) registerEndpoint(c *gin.Context) {
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip": c.ClientIP(),
+		"key_name":  callerID(c),
+		"method":    c.Request.Method,
+		"path":      c.Request.URL.Path,
+	})
+	requestLogger.Info("Received webhook registration")
+
+	var req registerWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLogger.WithError(err).Warn("Invalid request format")
+		problem.BadRequest(c, "Request body is malformed or missing required fields")
+		return
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		requestLogger.Warn("Invalid webhook URL")
+		problem.Respond(c, problem.Validation([]types.ValidationError{{Field: "url", Message: "URL must be an absolute http or https URL"}}))
+		return
+	}
+
+	endpoint, err := h.dispatcher.Register(callerID(c), req.URL, req.Events)
+	switch {
+	case errors.Is(err, webhook.ErrUnknownEvent):
+		requestLogger.WithError(err).Warn("Webhook registration rejected")
+		problem.Respond(c, problem.Validation([]types.ValidationError{{Field: "events", Message: err.Error()}}))
+		return
+	case err != nil:
+		requestLogger.WithError(err).Error("Failed to register webhook endpoint")
+		problem.Internal(c, "Failed to register webhook endpoint")
+		return
+	}
+
+	requestLogger.WithField("webhook_id", endpoint.ID).Info("Webhook endpoint registered")
+	c.JSON(http.StatusCreated, endpoint)
+}
+
+func (h *webhookHandler) listEndpoints(c *gin.Context) {
+	endpoints := h.dispatcher.Store().Endpoints(callerID(c))
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	if endpoints == nil {
+		endpoints = []webhook.Endpoint{}
+	}
+	c.JSON(http.StatusOK, endpoints)
+}
+
+func (h *webhookHandler) deleteEndpoint(c *gin.Context) {
+	webhookID := c.Param("webhook_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip":  c.ClientIP(),
+		"key_name":   callerID(c),
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+		"webhook_id": webhookID,
+	})
+
+	err := h.dispatcher.Store().DeleteEndpoint(callerID(c), webhookID)
+	if errors.Is(err, webhook.ErrNotFound) {
+		requestLogger.Warn("Webhook endpoint not found")
+		problem.NotFound(c, "Webhook with requested ID not found")
+		return
+	}
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to delete webhook endpoint")
+		problem.Internal(c, "Failed to delete webhook")
+		return
+	}
+
+	requestLogger.Info("Webhook endpoint deleted")
+	c.Status(http.StatusNoContent)
+}
+
+func (h *webhookHandler) listDeliveries(c *gin.Context) {
+	deliveries := h.dispatcher.Store().Deliveries(callerID(c), c.Query("status"))
+	if deliveries == nil {
+		deliveries = []webhook.Delivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *webhookHandler) replayDelivery(c *gin.Context) {
+	deliveryID := c.Param("delivery_id")
+
+	requestLogger := log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"client_ip":   c.ClientIP(),
+		"key_name":    callerID(c),
+		"method":      c.Request.Method,
+		"path":        c.Request.URL.Path,
+		"delivery_id": deliveryID,
+	})
+
+	delivery, err := h.dispatcher.Replay(callerID(c), deliveryID)
+	if errors.Is(err, webhook.ErrNotFound) {
+		requestLogger.Warn("Webhook delivery not found")
+		problem.NotFound(c, "Delivery with requested ID not found")
+		return
+	}
+	if err != nil {
+		requestLogger.WithError(err).Error("Failed to replay webhook delivery")
+		problem.Internal(c, "Failed to replay delivery")
+		return
+	}
+
+	requestLogger.Info("Webhook delivery queued for replay")
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,149 @@
+package settlement
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/govalues/decimal"
+)
+
+// Level picks how much detail a report carries.
+type Level string
+
+const (
+	// LevelBatch reports one row per batch.
+	LevelBatch Level = "batch"
+	// LevelEntry reports every capture and refund in each batch.
+	LevelEntry Level = "entry"
+)
+
+func ParseLevel(value string) (Level, error) {
+	switch Level(value) {
+	case LevelBatch, LevelEntry:
+		return Level(value), nil
+	default:
+		return "", fmt.Errorf("report level must be %q or %q, got %q", LevelBatch, LevelEntry, value)
+	}
+}
+
+// Total sums a merchant's batches in one currency over a report's range.
+type Total struct {
+	Merchant     string          `json:"merchant"`
+	Currency     string          `json:"currency"`
+	BatchCount   int             `json:"batch_count"`
+	CaptureCount int             `json:"capture_count"`
+	RefundCount  int             `json:"refund_count"`
+	Gross        decimal.Decimal `json:"gross"`
+	Refunds      decimal.Decimal `json:"refunds"`
+	Net          decimal.Decimal `json:"net"`
+}
+
+// Report is a reconciliation report over the batches between From and To.
+// ClosedThrough, when set, is the last day that had been closed when the
+// report was generated; later days in the range are not in the report yet.
+type Report struct {
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Timezone      string    `json:"timezone"`
+	Level         Level     `json:"level"`
+	ClosedThrough string    `json:"closed_through,omitempty"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Totals        []Total   `json:"totals"`
+	Batches       []Batch   `json:"batches"`
+}
+
+// NewReport totals batches per merchant and currency. Batch level reports
+// leave out the entries of each batch.
+func NewReport(from, to string, loc *time.Location, level Level, batches []Batch, now time.Time) (Report, error) {
+	report := Report{
+		From:        from,
+		To:          to,
+		Timezone:    loc.String(),
+		Level:       level,
+		GeneratedAt: now,
+		Totals:      []Total{},
+		Batches:     make([]Batch, 0, len(batches)),
+	}
+
+	totals := make(map[[2]string]*Total)
+	for _, batch := range batches {
+		key := [2]string{batch.Merchant, batch.Currency}
+		total, ok := totals[key]
+		if !ok {
+			total = &Total{Merchant: batch.Merchant, Currency: batch.Currency}
+			totals[key] = total
+		}
+		total.BatchCount++
+		total.CaptureCount += batch.CaptureCount
+		total.RefundCount += batch.RefundCount
+
+		var err error
+		if total.Gross, err = total.Gross.Add(batch.Gross); err != nil {
+			return Report{}, err
+		}
+		if total.Refunds, err = total.Refunds.Add(batch.Refunds); err != nil {
+			return Report{}, err
+		}
+		if total.Net, err = total.Net.Add(batch.Net); err != nil {
+			return Report{}, err
+		}
+
+		if level == LevelBatch {
+			batch.Entries = nil
+		}
+		report.Batches = append(report.Batches, batch)
+	}
+
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+	sort.Slice(report.Totals, func(i, j int) bool {
+		a, b := report.Totals[i], report.Totals[j]
+		if a.Merchant != b.Merchant {
+			return a.Merchant < b.Merchant
+		}
+		return a.Currency < b.Currency
+	})
+	return report, nil
+}
+
+// WriteCSV writes the report as CSV with a header row. Batch level reports
+// have a row per batch and entry level reports a row per capture or refund,
+// with refunds as negative amounts.
+func (r Report) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+
+	if r.Level == LevelEntry {
+		out.Write([]string{"batch_id", "date", "merchant", "currency", "type", "transaction_id", "refund_id", "amount", "occurred_at"})
+		for _, batch := range r.Batches {
+			for _, entry := range batch.Entries {
+				out.Write([]string{
+					batch.BatchID, batch.Date, batch.Merchant, batch.Currency,
+					entry.Type, entry.TransactionID, entry.RefundID,
+					entry.Amount.String(), entry.OccurredAt.Format(time.RFC3339),
+				})
+			}
+		}
+	} else {
+		out.Write([]string{"batch_id", "date", "merchant", "currency", "capture_count", "refund_count", "gross", "refunds", "net"})
+		for _, batch := range r.Batches {
+			out.Write([]string{
+				batch.BatchID, batch.Date, batch.Merchant, batch.Currency,
+				strconv.Itoa(batch.CaptureCount), strconv.Itoa(batch.RefundCount),
+				batch.Gross.String(), batch.Refunds.String(), batch.Net.String(),
+			})
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// Filename is the name reports are downloaded as.
+func (r Report) Filename(extension string) string {
+	return "settlement_" + r.From + "_" + r.To + "." + extension
+}
@@ -0,0 +1,274 @@
+package settlement
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/repository"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecimal(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(s)
+	require.NoError(t, err)
+	return d
+}
+
+func at(day, hour int) time.Time {
+	return time.Date(2025, time.March, day, hour, 0, 0, 0, time.UTC)
+}
+
+func captured(t *testing.T, id, owner, currency, amount string, when time.Time) types.Transaction {
+	return types.Transaction{
+		TransactionID:  id,
+		Owner:          owner,
+		Currency:       currency,
+		Amount:         mustDecimal(t, amount),
+		CapturedAmount: mustDecimal(t, amount),
+		Status:         types.StatusSuccess,
+		CapturedAt:     &when,
+		CreatedAt:      when,
+		UpdatedAt:      when,
+	}
+}
+
+func withRefund(t *testing.T, txn types.Transaction, id, amount string, when time.Time) types.Transaction {
+	txn.Refunds = append(txn.Refunds, types.Refund{RefundID: id, Amount: mustDecimal(t, amount), CreatedAt: when})
+	txn.RefundedAmount, _ = txn.RefundedAmount.Add(mustDecimal(t, amount))
+	txn.Status = types.StatusPartiallyRefunded
+	return txn
+}
+
+func sampleTransactions(t *testing.T) []types.Transaction {
+	failed := types.Transaction{TransactionID: "txn-failed", Owner: "movies", Currency: "USD", Amount: mustDecimal(t, "9.00"), Status: types.StatusFailed, CreatedAt: at(1, 11)}
+	voided := types.Transaction{TransactionID: "txn-voided", Owner: "movies", Currency: "USD", Amount: mustDecimal(t, "9.00"), Status: types.StatusVoided, CreatedAt: at(1, 12)}
+
+	return []types.Transaction{
+		withRefund(t, captured(t, "txn-1", "movies", "USD", "20.00", at(1, 10)), "ref-1", "5.00", at(2, 9)),
+		captured(t, "txn-2", "movies", "USD", "12.50", at(1, 15)),
+		captured(t, "txn-3", "movies", "INR", "450.00", at(1, 16)),
+		captured(t, "txn-4", "snacks", "USD", "4.00", at(1, 17)),
+		captured(t, "txn-5", "movies", "USD", "30.00", at(2, 18)),
+		failed,
+		voided,
+	}
+}
+
+func TestBuildGroupsByDayMerchantAndCurrency(t *testing.T) {
+	batches, err := Build(sampleTransactions(t), "2025-03-01", "2025-03-02", time.UTC)
+	require.NoError(t, err)
+	require.Len(t, batches, 4)
+
+	type summary struct {
+		date, merchant, currency string
+		captures, refunds        int
+		gross, refunded, net     string
+	}
+	var got []summary
+	for _, batch := range batches {
+		got = append(got, summary{batch.Date, batch.Merchant, batch.Currency, batch.CaptureCount, batch.RefundCount,
+			batch.Gross.String(), batch.Refunds.String(), batch.Net.String()})
+		assert.Equal(t, BatchID(batch.Date, batch.Merchant, batch.Currency), batch.BatchID)
+	}
+	assert.Equal(t, []summary{
+		{"2025-03-01", "movies", "INR", 1, 0, "450.00", "0.00", "450.00"},
+		{"2025-03-01", "movies", "USD", 2, 0, "32.50", "0.00", "32.50"},
+		{"2025-03-01", "snacks", "USD", 1, 0, "4.00", "0.00", "4.00"},
+		// The refund of txn-1 lands on the day it was issued
+		{"2025-03-02", "movies", "USD", 1, 1, "30.00", "5.00", "25.00"},
+	}, got)
+
+	refundDay := batches[3]
+	require.Len(t, refundDay.Entries, 2)
+	assert.Equal(t, Entry{Type: EntryRefund, TransactionID: "txn-1", RefundID: "ref-1", Amount: mustDecimal(t, "-5.00"), OccurredAt: at(2, 9)}, refundDay.Entries[0])
+	assert.Equal(t, EntryCapture, refundDay.Entries[1].Type)
+}
+
+func TestBuildRange(t *testing.T) {
+	batches, err := Build(sampleTransactions(t), "2025-03-02", "2025-03-02", time.UTC)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "2025-03-02", batches[0].Date)
+
+	batches, err = Build(sampleTransactions(t), "2025-03-03", "2025-03-31", time.UTC)
+	require.NoError(t, err)
+	assert.Empty(t, batches)
+}
+
+func TestBuildUsesSettlementTimezone(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+
+	// 20:00 UTC is already the next morning in India
+	txn := captured(t, "txn-1", "movies", "INR", "300.00", at(1, 20))
+	batches, err := Build([]types.Transaction{txn}, "", "2025-03-31", kolkata)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "2025-03-02", batches[0].Date)
+}
+
+func TestBuildFallsBackToCreationTime(t *testing.T) {
+	txn := captured(t, "txn-1", "movies", "USD", "10.00", at(1, 10))
+	txn.CapturedAt = nil
+	txn.CreatedAt = at(3, 10)
+
+	batches, err := Build([]types.Transaction{txn}, "", "2025-03-31", time.UTC)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "2025-03-03", batches[0].Date)
+}
+
+func TestCloseOnlyClosesFinishedDays(t *testing.T) {
+	transactions := repository.NewMemoryRepository()
+	for _, txn := range sampleTransactions(t) {
+		require.NoError(t, transactions.Save(txn))
+	}
+	store := NewMemoryStore()
+
+	// Midday on the 2nd only the 1st has finished
+	closed, err := Close(store, transactions, at(2, 12), time.UTC)
+	require.NoError(t, err)
+	assert.Len(t, closed, 3)
+	assert.Equal(t, "2025-03-01", store.ClosedThrough())
+
+	closed, err = Close(store, transactions, at(2, 23), time.UTC)
+	require.NoError(t, err)
+	assert.Empty(t, closed)
+
+	// A late refund of a day 1 payment goes into day 3 and leaves day 1 alone
+	_, err = transactions.Update("txn-2", func(txn *types.Transaction) error {
+		*txn = withRefund(t, *txn, "ref-2", "12.50", at(3, 8))
+		return nil
+	})
+	require.NoError(t, err)
+
+	closed, err = Close(store, transactions, at(5, 0), time.UTC)
+	require.NoError(t, err)
+	require.Len(t, closed, 2)
+	assert.Equal(t, "2025-03-02", closed[0].Date)
+	assert.Equal(t, "2025-03-03", closed[1].Date)
+	assert.Equal(t, "-12.50", closed[1].Net.String())
+	assert.Equal(t, "2025-03-04", store.ClosedThrough())
+
+	day1, err := store.Batch(BatchID("2025-03-01", "movies", "USD"))
+	require.NoError(t, err)
+	assert.Equal(t, "32.50", day1.Net.String())
+	require.NotNil(t, day1.ClosedAt)
+	assert.True(t, day1.ClosedAt.Equal(at(2, 12)))
+
+	assert.Len(t, store.Batches(Filter{Merchant: "movies"}), 4)
+	assert.Len(t, store.Batches(Filter{From: "2025-03-02", To: "2025-03-03"}), 2)
+
+	_, err = store.Batch("stl_missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, store.Close("2025-03-04", nil), ErrAlreadyClosed)
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settlements.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	batches, err := Build(sampleTransactions(t), "", "2025-03-02", time.UTC)
+	require.NoError(t, err)
+	require.NoError(t, store.Close("2025-03-02", batches))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-03-02", reopened.ClosedThrough())
+	assert.Equal(t, store.Batches(Filter{}), reopened.Batches(Filter{}))
+}
+
+func TestFailedCloseLeavesDayOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	store, err := NewFileStore(filepath.Join(dir, "settlements.json"))
+	require.NoError(t, err)
+
+	// A file where the store's directory should be makes the write fail
+	require.NoError(t, os.WriteFile(dir, nil, 0o600))
+
+	batches, err := Build(sampleTransactions(t), "", "2025-03-01", time.UTC)
+	require.NoError(t, err)
+	require.NotEmpty(t, batches)
+	assert.Error(t, store.Close("2025-03-01", batches))
+	assert.Empty(t, store.ClosedThrough())
+	assert.Empty(t, store.Batches(Filter{}))
+
+	// Once the store can be written the same day closes
+	require.NoError(t, os.Remove(dir))
+	require.NoError(t, store.Close("2025-03-01", batches))
+	assert.Equal(t, "2025-03-01", store.ClosedThrough())
+}
+
+func TestReport(t *testing.T) {
+	batches, err := Build(sampleTransactions(t), "2025-03-01", "2025-03-02", time.UTC)
+	require.NoError(t, err)
+
+	report, err := NewReport("2025-03-01", "2025-03-02", time.UTC, LevelBatch, batches, at(3, 0))
+	require.NoError(t, err)
+	require.Len(t, report.Totals, 3)
+	movies := report.Totals[1]
+	assert.Equal(t, "movies", movies.Merchant)
+	assert.Equal(t, "USD", movies.Currency)
+	assert.Equal(t, 2, movies.BatchCount)
+	assert.Equal(t, 3, movies.CaptureCount)
+	assert.Equal(t, "62.50", movies.Gross.String())
+	assert.Equal(t, "5.00", movies.Refunds.String())
+	assert.Equal(t, "57.50", movies.Net.String())
+	for _, batch := range report.Batches {
+		assert.Nil(t, batch.Entries)
+	}
+	assert.NotNil(t, batches[0].Entries, "the report must not change the batches it was built from")
+
+	var csv bytes.Buffer
+	require.NoError(t, report.WriteCSV(&csv))
+	assert.Equal(t, "batch_id,date,merchant,currency,capture_count,refund_count,gross,refunds,net\n"+
+		BatchID("2025-03-01", "movies", "INR")+",2025-03-01,movies,INR,1,0,450.00,0.00,450.00\n"+
+		BatchID("2025-03-01", "movies", "USD")+",2025-03-01,movies,USD,2,0,32.50,0.00,32.50\n"+
+		BatchID("2025-03-01", "snacks", "USD")+",2025-03-01,snacks,USD,1,0,4.00,0.00,4.00\n"+
+		BatchID("2025-03-02", "movies", "USD")+",2025-03-02,movies,USD,1,1,30.00,5.00,25.00\n", csv.String())
+}
+
+func TestEntryReportAddsUpToNet(t *testing.T) {
+	batches, err := Build(sampleTransactions(t), "2025-03-02", "2025-03-02", time.UTC)
+	require.NoError(t, err)
+	report, err := NewReport("2025-03-02", "2025-03-02", time.UTC, LevelEntry, batches, at(3, 0))
+	require.NoError(t, err)
+
+	var csv bytes.Buffer
+	require.NoError(t, report.WriteCSV(&csv))
+	id := BatchID("2025-03-02", "movies", "USD")
+	assert.Equal(t, "batch_id,date,merchant,currency,type,transaction_id,refund_id,amount,occurred_at\n"+
+		id+",2025-03-02,movies,USD,refund,txn-1,ref-1,-5.00,2025-03-02T09:00:00Z\n"+
+		id+",2025-03-02,movies,USD,capture,txn-5,,30.00,2025-03-02T18:00:00Z\n", csv.String())
+
+	sum := decimal.Zero
+	for _, entry := range report.Batches[0].Entries {
+		sum, err = sum.Add(entry.Amount)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, report.Batches[0].Net.String(), sum.String())
+}
+
+func TestBatchID(t *testing.T) {
+	assert.Equal(t, "stl_20250301_USD_12ddeacb8577f813e00b7746", BatchID("2025-03-01", "booking-app", "USD"))
+	assert.NotEqual(t, BatchID("2025-03-01", "movies", "USD"), BatchID("2025-03-01", "snacks", "USD"))
+}
+
+func TestParseDate(t *testing.T) {
+	date, err := ParseDate("2025-03-01")
+	require.NoError(t, err)
+	assert.Equal(t, "2025-03-01", date)
+
+	for _, value := range []string{"2025-3-1", "01/03/2025", "2025-02-30", ""} {
+		_, err := ParseDate(value)
+		assert.Error(t, err, value)
+	}
+}
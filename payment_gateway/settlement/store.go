@@ -0,0 +1,174 @@
+package settlement
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/atomicfile"
+)
+
+var (
+	ErrNotFound      = errors.New("settlement batch not found")
+	ErrAlreadyClosed = errors.New("settlement day is already closed")
+)
+
+// Store holds closed batches and the last day that was closed. When created
+// with NewFileStore every change is written to disk, so closed batches
+// survive a restart and are never recomputed.
+type Store struct {
+	mu            sync.Mutex
+	path          string
+	batches       map[string]Batch
+	closedThrough string
+}
+
+type storeFile struct {
+	ClosedThrough string  `json:"closed_through,omitempty"`
+	Batches       []Batch `json:"batches"`
+}
+
+// New returns the store backend selected by kind. An empty kind selects the
+// in-memory backend; "file" persists batches to path.
+func New(kind, path string) (*Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		return NewFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown settlement store %q", kind)
+	}
+}
+
+func NewMemoryStore() *Store {
+	return &Store{batches: make(map[string]Batch)}
+}
+
+func NewFileStore(path string) (*Store, error) {
+	s := NewMemoryStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement store: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse settlement store: %w", err)
+	}
+	s.closedThrough = file.ClosedThrough
+	for _, batch := range file.Batches {
+		s.batches[batch.BatchID] = batch
+	}
+	return s, nil
+}
+
+// ClosedThrough returns the last closed day, or "" when nothing has been
+// closed yet.
+func (s *Store) ClosedThrough() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closedThrough
+}
+
+// Close records batches and marks every day up to and including through as
+// closed.
+func (s *Store) Close(through string, batches []Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closedThrough != "" && through <= s.closedThrough {
+		return ErrAlreadyClosed
+	}
+	for _, batch := range batches {
+		if batch.Date <= s.closedThrough || batch.Date > through {
+			return fmt.Errorf("batch %s for %s is outside the days being closed", batch.BatchID, batch.Date)
+		}
+	}
+
+	// The day is only closed in memory once it is written
+	closed := make(map[string]Batch, len(s.batches)+len(batches))
+	for id, batch := range s.batches {
+		closed[id] = batch
+	}
+	for _, batch := range batches {
+		closed[batch.BatchID] = batch
+	}
+	if err := s.persist(through, closed); err != nil {
+		return err
+	}
+	s.batches = closed
+	s.closedThrough = through
+	return nil
+}
+
+func (s *Store) Batch(id string) (Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return Batch{}, ErrNotFound
+	}
+	return batch, nil
+}
+
+// Filter narrows a batch listing. Empty fields match every batch; From and
+// To are inclusive dates.
+type Filter struct {
+	Merchant string
+	From     string
+	To       string
+}
+
+func (f Filter) matches(batch Batch) bool {
+	return (f.Merchant == "" || batch.Merchant == f.Merchant) &&
+		(f.From == "" || batch.Date >= f.From) &&
+		(f.To == "" || batch.Date <= f.To)
+}
+
+// Batches lists the closed batches matching filter, ordered by date,
+// merchant and currency.
+func (s *Store) Batches(filter Filter) []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batches []Batch
+	for _, batch := range s.batches {
+		if filter.matches(batch) {
+			batches = append(batches, batch)
+		}
+	}
+	sortBatches(batches)
+	return batches
+}
+
+func (s *Store) persist(closedThrough string, batches map[string]Batch) error {
+	if s.path == "" {
+		return nil
+	}
+
+	file := storeFile{ClosedThrough: closedThrough, Batches: make([]Batch, 0, len(batches))}
+	for _, batch := range batches {
+		file.Batches = append(file.Batches, batch)
+	}
+	sortBatches(file.Batches)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to encode settlement store: %w", err)
+	}
+
+	if err := atomicfile.Write(s.path, data, 0o755); err != nil {
+		return fmt.Errorf("failed to write settlement store: %w", err)
+	}
+	return nil
+}
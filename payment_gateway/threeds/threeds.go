@@ -0,0 +1,195 @@
+package threeds
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/processor"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+)
+
+const (
+	ChallengePending       = "PENDING"
+	ChallengeAuthenticated = "AUTHENTICATED"
+	ChallengeFailed        = "FAILED"
+	ChallengeCompleted     = "COMPLETED"
+)
+
+const (
+	codeLength  = 6
+	maxAttempts = 3
+)
+
+var (
+	ErrNotFound         = errors.New("challenge not found")
+	ErrExpired          = errors.New("challenge has expired")
+	ErrInvalidCode      = errors.New("one-time code is incorrect")
+	ErrNotPending       = errors.New("challenge is no longer awaiting a code")
+	ErrNotAuthenticated = errors.New("challenge has not been authenticated")
+)
+
+// Challenge is a simulated issuer authentication step. It holds the original
+// payment request in memory only, so card details are never persisted while
+// the customer completes the challenge.
+type Challenge struct {
+	ID            string
+	TransactionID string
+	Mode          string
+	Request       types.PaymentRequest
+	Code          string
+	Status        string
+	Attempts      int
+	ExpiresAt     time.Time
+}
+
+type Policy struct {
+	// AmountThreshold challenges every payment at or above this amount. A nil
+	// threshold only challenges processor.TestCardThreeDSChallenge.
+	AmountThreshold *decimal.Decimal
+}
+
+func (p Policy) Requires(req types.PaymentRequest) bool {
+	if req.CardNumber == processor.TestCardThreeDSChallenge {
+		return true
+	}
+	return p.AmountThreshold != nil && req.Amount.Cmp(*p.AmountThreshold) >= 0
+}
+
+type Service struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	challenges    map[string]*Challenge
+	byTransaction map[string]string
+	now           func() time.Time
+}
+
+func NewService(ttl time.Duration) *Service {
+	return &Service{
+		ttl:           ttl,
+		challenges:    make(map[string]*Challenge),
+		byTransaction: make(map[string]string),
+		now:           time.Now,
+	}
+}
+
+func (s *Service) Create(transactionID, mode string, req types.PaymentRequest) (Challenge, error) {
+	code, err := generateCode()
+	if err != nil {
+		return Challenge{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired()
+
+	challenge := &Challenge{
+		ID:            uuid.New().String(),
+		TransactionID: transactionID,
+		Mode:          mode,
+		Request:       req,
+		Code:          code,
+		Status:        ChallengePending,
+		ExpiresAt:     s.now().Add(s.ttl),
+	}
+	s.challenges[challenge.ID] = challenge
+	s.byTransaction[transactionID] = challenge.ID
+	return *challenge, nil
+}
+
+func (s *Service) Get(id string) (Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[id]
+	if !ok {
+		return Challenge{}, ErrNotFound
+	}
+	return *challenge, nil
+}
+
+// Verify checks a one-time code entered on the challenge page. After
+// maxAttempts wrong codes the challenge fails for good.
+func (s *Service) Verify(id, code string) (Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[id]
+	if !ok {
+		return Challenge{}, ErrNotFound
+	}
+	if challenge.Status != ChallengePending {
+		return *challenge, ErrNotPending
+	}
+	if s.now().After(challenge.ExpiresAt) {
+		challenge.Status = ChallengeFailed
+		return *challenge, ErrExpired
+	}
+
+	challenge.Attempts++
+	if subtle.ConstantTimeCompare([]byte(code), []byte(challenge.Code)) != 1 {
+		if challenge.Attempts >= maxAttempts {
+			challenge.Status = ChallengeFailed
+		}
+		return *challenge, ErrInvalidCode
+	}
+
+	challenge.Status = ChallengeAuthenticated
+	return *challenge, nil
+}
+
+// Complete hands the held payment request back for processing exactly once.
+// A failed challenge is completed too, so the caller can record the decline.
+func (s *Service) Complete(transactionID string) (Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.byTransaction[transactionID]
+	if !ok {
+		return Challenge{}, ErrNotFound
+	}
+	challenge := s.challenges[id]
+
+	switch {
+	case challenge.Status == ChallengePending && s.now().After(challenge.ExpiresAt):
+		challenge.Status = ChallengeFailed
+	case challenge.Status == ChallengePending, challenge.Status == ChallengeCompleted:
+		return *challenge, ErrNotAuthenticated
+	}
+
+	result := *challenge
+	challenge.Status = ChallengeCompleted
+	challenge.Request = types.PaymentRequest{}
+	return result, nil
+}
+
+func (s *Service) purgeExpired() {
+	// Keep finished challenges around for a while so the page can still show
+	// the outcome; drop them once they are well past their expiry.
+	cutoff := s.now().Add(-s.ttl)
+	for id, challenge := range s.challenges {
+		if challenge.ExpiresAt.Before(cutoff) {
+			delete(s.challenges, id)
+			delete(s.byTransaction, challenge.TransactionID)
+		}
+	}
+}
+
+func generateCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < codeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate one-time code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", codeLength, n), nil
+}
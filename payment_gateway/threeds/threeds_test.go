@@ -0,0 +1,108 @@
+package threeds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/processor"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRequest(cardNumber, amount string) types.PaymentRequest {
+	return types.PaymentRequest{
+		CardNumber: cardNumber,
+		CVV:        "123",
+		Expiry:     "12/30",
+		Name:       "John Doe",
+		Amount:     decimal.MustParse(amount),
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	threshold := decimal.MustParse("100.00")
+	policy := Policy{AmountThreshold: &threshold}
+
+	assert.True(t, policy.Requires(testRequest(processor.TestCardThreeDSChallenge, "1.00")))
+	assert.True(t, policy.Requires(testRequest("4242424242424242", "100.00")))
+	assert.False(t, policy.Requires(testRequest("4242424242424242", "99.99")))
+	assert.False(t, Policy{}.Requires(testRequest("4242424242424242", "5000.00")))
+}
+
+func TestSuccessfulChallenge(t *testing.T) {
+	service := NewService(10 * time.Minute)
+	req := testRequest(processor.TestCardThreeDSChallenge, "10.00")
+
+	challenge, err := service.Create("txn-1", "sale", req)
+	require.NoError(t, err)
+	assert.Len(t, challenge.Code, 6)
+
+	_, err = service.Complete("txn-1")
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+
+	verified, err := service.Verify(challenge.ID, challenge.Code)
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeAuthenticated, verified.Status)
+
+	_, err = service.Verify(challenge.ID, challenge.Code)
+	assert.ErrorIs(t, err, ErrNotPending)
+
+	completed, err := service.Complete("txn-1")
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeAuthenticated, completed.Status)
+	assert.Equal(t, req.CardNumber, completed.Request.CardNumber)
+
+	_, err = service.Complete("txn-1")
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+
+	stored, err := service.Get(challenge.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Request.CardNumber)
+}
+
+func TestChallengeFailsAfterTooManyAttempts(t *testing.T) {
+	service := NewService(10 * time.Minute)
+	challenge, err := service.Create("txn-1", "sale", testRequest(processor.TestCardThreeDSChallenge, "10.00"))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = service.Verify(challenge.ID, "not-the-code")
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	}
+
+	_, err = service.Verify(challenge.ID, challenge.Code)
+	assert.ErrorIs(t, err, ErrNotPending)
+
+	completed, err := service.Complete("txn-1")
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeFailed, completed.Status)
+}
+
+func TestExpiredChallenge(t *testing.T) {
+	service := NewService(time.Minute)
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	challenge, err := service.Create("txn-1", "sale", testRequest(processor.TestCardThreeDSChallenge, "10.00"))
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = service.Verify(challenge.ID, challenge.Code)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	completed, err := service.Complete("txn-1")
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeFailed, completed.Status)
+}
+
+func TestUnknownChallenge(t *testing.T) {
+	service := NewService(time.Minute)
+
+	_, err := service.Verify("missing", "123456")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = service.Complete("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
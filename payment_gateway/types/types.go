@@ -4,25 +4,221 @@ import (
 	"time"
 
 	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
 )
 
+const (
+	StatusPending           = "PENDING"
+	StatusSuccess           = "SUCCESS"
+	StatusFailed            = "FAILED"
+	StatusPartiallyRefunded = "PARTIALLY_REFUNDED"
+	StatusRefunded          = "REFUNDED"
+	StatusAuthorized        = "AUTHORIZED"
+	StatusCaptured          = "CAPTURED"
+	StatusVoided            = "VOIDED"
+	StatusExpired           = "EXPIRED"
+	StatusRequiresAction    = "REQUIRES_ACTION"
+)
+
+// DeclineCode says why a payment failed, so clients can tell a customer
+// whether to retry or use another card.
+type DeclineCode string
+
+// Decline codes returned by the issuer.
+const (
+	DeclineInsufficientFunds DeclineCode = "insufficient_funds"
+	DeclineDoNotHonor        DeclineCode = "do_not_honor"
+	DeclineExpiredCard       DeclineCode = "expired_card"
+	DeclineIncorrectCVV      DeclineCode = "incorrect_cvv"
+	DeclineLostOrStolen      DeclineCode = "lost_or_stolen"
+	DeclineSuspectedFraud    DeclineCode = "suspected_fraud"
+	DeclineProcessingError   DeclineCode = "processing_error"
+	DeclineIssuerUnavailable DeclineCode = "issuer_unavailable"
+)
+
+// Decline codes for payments the gateway fails itself, before or instead of
+// asking the issuer.
+const (
+	DeclineRiskBlocked DeclineCode = "risk_blocked"
+	DeclineThreeDS     DeclineCode = "threeds_failed"
+	DeclineGatewayBusy DeclineCode = "gateway_busy"
+)
+
+// Retryable reports whether the same payment may succeed if it is simply
+// sent again later. The other codes need the customer to act, usually by
+// using another card.
+func (c DeclineCode) Retryable() bool {
+	switch c {
+	case DeclineProcessingError, DeclineIssuerUnavailable, DeclineGatewayBusy:
+		return true
+	default:
+		return false
+	}
+}
+
+// PaymentRequest carries either raw card details or a CardToken issued by
+// POST /tokens. With a token the CVV is optional, since it is never stored.
 type PaymentRequest struct {
-	CardNumber string          `json:"card_number" binding:"required"`
-	CVV        string          `json:"cvv" binding:"required"`
-	Expiry     string          `json:"expiry" binding:"required"`
-	Name       string          `json:"name" binding:"required"`
+	CardNumber string          `json:"card_number" binding:"required_without=CardToken"`
+	CVV        string          `json:"cvv" binding:"required_without=CardToken"`
+	Expiry     string          `json:"expiry" binding:"required_without=CardToken"`
+	Name       string          `json:"name" binding:"required_without=CardToken"`
+	CardToken  string          `json:"card_token,omitempty"`
 	Amount     decimal.Decimal `json:"amount" binding:"required"`
+	Currency   string          `json:"currency"`
 	Timestamp  time.Time       `json:"-"`
 }
 
-type ValidationError struct {
-	Field   string `json:"field"`
-	Message string `json:"message"`
+type CardDetails struct {
+	CardNumber string `json:"card_number" binding:"required"`
+	CVV        string `json:"cvv" binding:"required"`
+	Expiry     string `json:"expiry" binding:"required"`
+	Name       string `json:"name" binding:"required"`
+}
+
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	CardBrand string    `json:"card_brand"`
+	Last4     string    `json:"last4"`
+	Expiry    string    `json:"expiry"`
+	CreatedAt time.Time `json:"created_at"`
+	RequestID string    `json:"request_id,omitempty"`
 }
 
+// ValidationError is the field error of problem responses, which the
+// validator returns directly.
+type ValidationError = problem.ValidationError
+
 type PaymentResponse struct {
-	Status        string `json:"status"`
-	Message       string `json:"message"`
+	Status         string           `json:"status"`
+	Message        string           `json:"message"`
+	TransactionID  string           `json:"transaction_id"`
+	RequestID      string           `json:"request_id"`
+	CardBrand      string           `json:"card_brand,omitempty"`
+	Amount         *decimal.Decimal `json:"amount,omitempty"`
+	Currency       string           `json:"currency,omitempty"`
+	CapturedAmount *decimal.Decimal `json:"captured_amount,omitempty"`
+	ExpiresAt      *time.Time       `json:"expires_at,omitempty"`
+	NextAction     *NextAction      `json:"next_action,omitempty"`
+	Risk           *RiskAssessment  `json:"risk,omitempty"`
+	DeclineCode    DeclineCode      `json:"decline_code,omitempty"`
+	Retryable      *bool            `json:"retryable,omitempty"`
+}
+
+// Decline sets the decline code and retryable flag of a failed payment.
+func (r *PaymentResponse) Decline(code DeclineCode) {
+	if code == "" {
+		return
+	}
+	retryable := code.Retryable()
+	r.DeclineCode = code
+	r.Retryable = &retryable
+}
+
+// RiskAssessment is the fraud screening result for a payment. Decision is
+// ALLOW, REVIEW or BLOCK; Reasons lists the rules that contributed to Score.
+type RiskAssessment struct {
+	Score    int      `json:"score"`
+	Decision string   `json:"decision"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+// NextAction tells the client what the customer must do before a payment in
+// REQUIRES_ACTION can continue.
+type NextAction struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Transaction struct {
 	TransactionID string `json:"transaction_id"`
 	RequestID     string `json:"request_id"`
+	// CompletionRequestID is the request that completed 3-D Secure
+	// authentication; RequestID stays that of the original payment.
+	CompletionRequestID string          `json:"completion_request_id,omitempty"`
+	Owner               string          `json:"owner,omitempty"`
+	IdempotencyKey      string          `json:"idempotency_key,omitempty"`
+	ClientIP            string          `json:"client_ip"`
+	CardholderName      string          `json:"cardholder_name"`
+	MaskedCard          string          `json:"masked_card"`
+	CardToken           string          `json:"card_token,omitempty"`
+	CardBrand           string          `json:"card_brand"`
+	Amount              decimal.Decimal `json:"amount"`
+	Currency            string          `json:"currency"`
+	CapturedAmount      decimal.Decimal `json:"captured_amount"`
+	RefundedAmount      decimal.Decimal `json:"refunded_amount"`
+	Refunds             []Refund        `json:"refunds,omitempty"`
+	Status              string          `json:"status"`
+	Message             string          `json:"message"`
+	DeclineCode         DeclineCode     `json:"decline_code,omitempty"`
+	ExpiresAt           *time.Time      `json:"expires_at,omitempty"`
+	Risk                *RiskAssessment `json:"risk,omitempty"`
+	AuthorizedAt        *time.Time      `json:"authorized_at,omitempty"`
+	CapturedAt          *time.Time      `json:"captured_at,omitempty"`
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
+	// CaptureFee is the ledger fee on CapturedAmount, fixed when the payment
+	// is captured so later fee changes leave it alone.
+	CaptureFee *decimal.Decimal `json:"capture_fee,omitempty"`
+	// Mode is "sale" or "authorize", depending on the endpoint the payment
+	// was made with.
+	Mode string `json:"mode,omitempty"`
+}
+
+// PaymentEvent is the data of payment webhooks. It carries what a receiver
+// needs to act on the event and leaves out the rest of the record, such as
+// the client IP, card token and risk assessment.
+type PaymentEvent struct {
+	TransactionID string          `json:"transaction_id"`
+	Status        string          `json:"status"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	DeclineCode   DeclineCode     `json:"decline_code,omitempty"`
+	CardBrand     string          `json:"card_brand,omitempty"`
+	CardLast4     string          `json:"card_last4,omitempty"`
+}
+
+// NewPaymentEvent returns the webhook data for txn.
+func NewPaymentEvent(txn Transaction) PaymentEvent {
+	event := PaymentEvent{
+		TransactionID: txn.TransactionID,
+		Status:        txn.Status,
+		Amount:        txn.Amount,
+		Currency:      txn.Currency,
+		DeclineCode:   txn.DeclineCode,
+		CardBrand:     txn.CardBrand,
+	}
+	if len(txn.MaskedCard) >= 4 {
+		event.CardLast4 = txn.MaskedCard[len(txn.MaskedCard)-4:]
+	}
+	return event
+}
+
+type CaptureRequest struct {
+	Amount *decimal.Decimal `json:"amount"`
+}
+
+type RefundRequest struct {
+	Amount *decimal.Decimal `json:"amount"`
+	Reason string           `json:"reason"`
+}
+
+type Refund struct {
+	RefundID  string          `json:"refund_id"`
+	RequestID string          `json:"request_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Reason    string          `json:"reason,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type RefundResponse struct {
+	Status            string          `json:"status"`
+	Message           string          `json:"message"`
+	RefundID          string          `json:"refund_id"`
+	TransactionID     string          `json:"transaction_id"`
+	Amount            decimal.Decimal `json:"amount"`
+	RefundedAmount    decimal.Decimal `json:"refunded_amount"`
+	Currency          string          `json:"currency"`
+	TransactionStatus string          `json:"transaction_status"`
+	RequestID         string          `json:"request_id"`
 }
@@ -0,0 +1,141 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+)
+
+type CardBrand string
+
+const (
+	BrandUnknown    CardBrand = ""
+	BrandVisa       CardBrand = "visa"
+	BrandMastercard CardBrand = "mastercard"
+	BrandAmex       CardBrand = "amex"
+	BrandDiscover   CardBrand = "discover"
+	BrandDiners     CardBrand = "diners"
+	BrandJCB        CardBrand = "jcb"
+	BrandMaestro    CardBrand = "maestro"
+	BrandRuPay      CardBrand = "rupay"
+)
+
+// AllBrands is the default accepted brand list.
+var AllBrands = []CardBrand{
+	BrandVisa,
+	BrandMastercard,
+	BrandAmex,
+	BrandDiscover,
+	BrandDiners,
+	BrandJCB,
+	BrandMaestro,
+	BrandRuPay,
+}
+
+type iinRange struct {
+	low  string
+	high string
+}
+
+type brandRule struct {
+	brand     CardBrand
+	ranges    []iinRange
+	minLength int
+	maxLength int
+	lengths   []int
+	cvvLength int
+}
+
+func prefix(p string) iinRange {
+	return iinRange{low: p, high: p}
+}
+
+// brandRules are matched by longest IIN prefix, so narrow ranges such as
+// RuPay's 652150-653149 win over the broader Discover 65 range.
+var brandRules = []brandRule{
+	{brand: BrandVisa, ranges: []iinRange{prefix("4")}, lengths: []int{13, 16, 19}, cvvLength: 3},
+	{brand: BrandMastercard, ranges: []iinRange{{"51", "55"}, {"2221", "2720"}}, lengths: []int{16}, cvvLength: 3},
+	{brand: BrandAmex, ranges: []iinRange{prefix("34"), prefix("37")}, lengths: []int{15}, cvvLength: 4},
+	{brand: BrandDiscover, ranges: []iinRange{prefix("6011"), {"644", "649"}, prefix("65"), {"622126", "622925"}}, minLength: 16, maxLength: 19, cvvLength: 3},
+	{brand: BrandDiners, ranges: []iinRange{{"300", "305"}, prefix("36"), prefix("38"), prefix("39")}, minLength: 14, maxLength: 19, cvvLength: 3},
+	{brand: BrandJCB, ranges: []iinRange{{"3528", "3589"}}, minLength: 16, maxLength: 19, cvvLength: 3},
+	{brand: BrandMaestro, ranges: []iinRange{prefix("50"), {"56", "58"}, prefix("639"), prefix("67")}, minLength: 12, maxLength: 19, cvvLength: 3},
+	{brand: BrandRuPay, ranges: []iinRange{{"508500", "508999"}, {"606985", "607984"}, {"608001", "608500"}, {"652150", "653149"}, prefix("81"), prefix("82")}, lengths: []int{16}, cvvLength: 3},
+}
+
+func (r iinRange) matches(cardNumber string) bool {
+	if len(cardNumber) < len(r.low) {
+		return false
+	}
+	head := cardNumber[:len(r.low)]
+	return head >= r.low && head <= r.high
+}
+
+func (r brandRule) validLength(n int) bool {
+	for _, length := range r.lengths {
+		if n == length {
+			return true
+		}
+	}
+	return r.maxLength > 0 && n >= r.minLength && n <= r.maxLength
+}
+
+func (r brandRule) lengthDescription() string {
+	if r.maxLength > 0 {
+		return fmt.Sprintf("between %d and %d", r.minLength, r.maxLength)
+	}
+	parts := make([]string, len(r.lengths))
+	for i, length := range r.lengths {
+		parts[i] = fmt.Sprint(length)
+	}
+	return "exactly " + strings.Join(parts, " or ")
+}
+
+func detectRule(cardNumber string) (brandRule, bool) {
+	var best brandRule
+	bestLength := 0
+	for _, rule := range brandRules {
+		for _, r := range rule.ranges {
+			if r.matches(cardNumber) && len(r.low) > bestLength {
+				best = rule
+				bestLength = len(r.low)
+			}
+		}
+	}
+	return best, bestLength > 0
+}
+
+// DetectBrand identifies the card brand from the number's IIN prefix. It
+// returns BrandUnknown when no known range matches.
+func DetectBrand(cardNumber string) CardBrand {
+	rule, ok := detectRule(cardNumber)
+	if !ok {
+		return BrandUnknown
+	}
+	return rule.brand
+}
+
+// ParseBrands parses a comma separated brand list such as "visa,amex".
+func ParseBrands(value string) ([]CardBrand, error) {
+	var brands []CardBrand
+	for _, part := range strings.Split(value, ",") {
+		name := CardBrand(strings.ToLower(strings.TrimSpace(part)))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, brand := range AllBrands {
+			if brand == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown card brand %q", name)
+		}
+		brands = append(brands, name)
+	}
+	if len(brands) == 0 {
+		return nil, fmt.Errorf("no card brands configured")
+	}
+	return brands, nil
+}
@@ -1,41 +1,174 @@
 package validator
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/govalues/decimal"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/currency"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
 )
 
 type PaymentValidator interface {
 	Validate(req types.PaymentRequest) []types.ValidationError
+	ValidateCard(card types.CardDetails) []types.ValidationError
 }
 
-type StrictValidator struct{}
+type StrictValidator struct {
+	acceptedBrands  map[CardBrand]bool
+	minAmounts      AmountLimits
+	maxAmounts      AmountLimits
+	currencies      map[string]bool
+	defaultCurrency string
+}
+
+type Option func(*StrictValidator)
+
+// WithAcceptedBrands restricts the card brands the validator accepts. By
+// default every brand in AllBrands is accepted.
+func WithAcceptedBrands(brands ...CardBrand) Option {
+	return func(v *StrictValidator) {
+		v.acceptedBrands = make(map[CardBrand]bool, len(brands))
+		for _, brand := range brands {
+			v.acceptedBrands[brand] = true
+		}
+	}
+}
+
+// AmountLimits maps a currency code to a bound on the amount of a single
+// transaction in that currency.
+type AmountLimits map[string]decimal.Decimal
+
+// ParseAmountLimits parses limits such as "USD:1.00,JPY:100". An amount
+// without a currency is a limit in defaultCurrency. Every limit must be
+// positive and fit its currency's minor units.
+func ParseAmountLimits(value, defaultCurrency string) (AmountLimits, error) {
+	limits := make(AmountLimits)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, amount := defaultCurrency, part
+		if before, after, found := strings.Cut(part, ":"); found {
+			code, amount = currency.Normalize(before), strings.TrimSpace(after)
+		}
+
+		minorUnits, ok := currency.MinorUnits(code)
+		if !ok {
+			return nil, fmt.Errorf("unknown currency %q", code)
+		}
+		limit, err := decimal.Parse(amount)
+		if err != nil || !limit.IsPos() {
+			return nil, fmt.Errorf("invalid amount limit %q", part)
+		}
+		if limit.MinScale() > minorUnits {
+			return nil, fmt.Errorf("amount limit %q has more than %d decimal places for %s", part, minorUnits, code)
+		}
+		if _, ok := limits[code]; ok {
+			return nil, fmt.Errorf("more than one amount limit for %s", code)
+		}
+		limits[code] = limit.Trim(minorUnits).Pad(minorUnits)
+	}
+	return limits, nil
+}
+
+// WithAmountLimits bounds the amount of a single transaction. Amounts are
+// only compared with the limits of their own currency; a currency without
+// a limit is not bounded.
+func WithAmountLimits(min, max AmountLimits) Option {
+	return func(v *StrictValidator) {
+		v.minAmounts = min
+		v.maxAmounts = max
+	}
+}
 
-func NewStrictValidator() *StrictValidator {
-	return &StrictValidator{}
+// WithCurrencies sets the currencies the validator accepts. Requests without
+// a currency are validated as defaultCurrency.
+func WithCurrencies(defaultCurrency string, supported ...string) Option {
+	return func(v *StrictValidator) {
+		v.defaultCurrency = defaultCurrency
+		v.currencies = make(map[string]bool, len(supported))
+		for _, code := range supported {
+			v.currencies[code] = true
+		}
+	}
+}
+
+func NewStrictValidator(opts ...Option) *StrictValidator {
+	v := &StrictValidator{}
+	WithAcceptedBrands(AllBrands...)(v)
+	WithCurrencies("USD", currency.Known()...)(v)
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
 }
 
+// Validate checks a payment request. Card details resolved from a token come
+// without a CVV, so the CVV is only checked when one was supplied.
 func (v *StrictValidator) Validate(req types.PaymentRequest) []types.ValidationError {
 	var errors []types.ValidationError
 
-	errors = append(errors, validateCardNumber(req.CardNumber)...)
-	errors = append(errors, validateCVV(req.CVV)...)
+	rule, known := detectRule(req.CardNumber)
+
+	errors = append(errors, v.validateCardNumber(req.CardNumber, rule, known)...)
+	if req.CardToken == "" || req.CVV != "" {
+		errors = append(errors, validateCVV(req.CVV, rule, known)...)
+	}
 	errors = append(errors, validateExpiry(req.Expiry)...)
 	errors = append(errors, validateName(req.Name)...)
+	code := req.Currency
+	if code == "" {
+		code = v.defaultCurrency
+	}
+	currencyErrors := v.validateCurrency(code)
+	errors = append(errors, currencyErrors...)
+	if len(currencyErrors) == 0 {
+		errors = append(errors, v.validateAmount(req.Amount, code)...)
+	}
+
+	return errors
+}
+
+// ValidateCard applies the card rules of Validate to card details submitted
+// for tokenization, where there is no amount or currency.
+func (v *StrictValidator) ValidateCard(card types.CardDetails) []types.ValidationError {
+	var errors []types.ValidationError
+
+	rule, known := detectRule(card.CardNumber)
+
+	errors = append(errors, v.validateCardNumber(card.CardNumber, rule, known)...)
+	errors = append(errors, validateCVV(card.CVV, rule, known)...)
+	errors = append(errors, validateExpiry(card.Expiry)...)
+	errors = append(errors, validateName(card.Name)...)
 
 	return errors
 }
 
-func validateCardNumber(cardNumber string) []types.ValidationError {
+func (v *StrictValidator) validateCardNumber(cardNumber string, rule brandRule, known bool) []types.ValidationError {
 	var errs []types.ValidationError
-	if !regexp.MustCompile(`^\d{16}$`).MatchString(cardNumber) {
+	if !regexp.MustCompile(`^\d{12,19}$`).MatchString(cardNumber) {
+		errs = append(errs, types.ValidationError{
+			Field:   "card_number",
+			Message: "Card number must be between 12 and 19 digits",
+		})
+		return errs
+	}
+	if !known || !v.acceptedBrands[rule.brand] {
+		errs = append(errs, types.ValidationError{
+			Field:   "card_number",
+			Message: "Card brand is not supported",
+		})
+		return errs
+	}
+	if !rule.validLength(len(cardNumber)) {
 		errs = append(errs, types.ValidationError{
 			Field:   "card_number",
-			Message: "Card number must be exactly 16 digits",
+			Message: fmt.Sprintf("Card number for %s must be %s digits", rule.brand, rule.lengthDescription()),
 		})
 		return errs
 	}
@@ -73,7 +206,7 @@ func reverseString(s string) string {
 	return reversed.String()
 }
 
-func validateCVV(cvv string) []types.ValidationError {
+func validateCVV(cvv string, rule brandRule, known bool) []types.ValidationError {
 	var errs []types.ValidationError
 
 	if !regexp.MustCompile(`^\d+$`).MatchString(cvv) {
@@ -83,18 +216,21 @@ func validateCVV(cvv string) []types.ValidationError {
 		})
 		return errs
 	}
-	if len(cvv) != 3 {
+	cvvLength := 3
+	if known {
+		cvvLength = rule.cvvLength
+	}
+	if len(cvv) != cvvLength {
 		errs = append(errs, types.ValidationError{
 			Field:   "cvv",
-			Message: "CVV must be exactly 3 digits",
+			Message: fmt.Sprintf("CVV must be exactly %d digits", cvvLength),
 		})
 		return errs
 	}
-	cvvInt := atoi(cvv)
-	if cvvInt < 1 || cvvInt > 999 {
+	if atoi(cvv) < 1 {
 		errs = append(errs, types.ValidationError{
 			Field:   "cvv",
-			Message: "CVV must be between 001 and 999",
+			Message: fmt.Sprintf("CVV must be between %s1 and %s", strings.Repeat("0", cvvLength-1), strings.Repeat("9", cvvLength)),
 		})
 	}
 	return errs
@@ -176,3 +312,60 @@ func validateName(name string) []types.ValidationError {
 	}
 	return errs
 }
+
+func (v *StrictValidator) validateCurrency(code string) []types.ValidationError {
+	var errs []types.ValidationError
+
+	if !regexp.MustCompile(`^[A-Z]{3}$`).MatchString(code) {
+		errs = append(errs, types.ValidationError{
+			Field:   "currency",
+			Message: "Currency must be a 3-letter ISO 4217 code",
+		})
+		return errs
+	}
+	if !v.currencies[code] {
+		errs = append(errs, types.ValidationError{
+			Field:   "currency",
+			Message: fmt.Sprintf("Currency %s is not supported", code),
+		})
+	}
+	return errs
+}
+
+func (v *StrictValidator) validateAmount(amount decimal.Decimal, code string) []types.ValidationError {
+	var errs []types.ValidationError
+
+	minorUnits, _ := currency.MinorUnits(code)
+
+	if !amount.IsPos() {
+		errs = append(errs, types.ValidationError{
+			Field:   "amount",
+			Message: "Amount must be greater than zero",
+		})
+		return errs
+	}
+	if amount.MinScale() > minorUnits {
+		message := fmt.Sprintf("Amount must have at most %d decimal places for %s", minorUnits, code)
+		if minorUnits == 0 {
+			message = fmt.Sprintf("Amount must be a whole number for %s", code)
+		}
+		errs = append(errs, types.ValidationError{
+			Field:   "amount",
+			Message: message,
+		})
+		return errs
+	}
+	if min, ok := v.minAmounts[code]; ok && amount.Cmp(min) < 0 {
+		errs = append(errs, types.ValidationError{
+			Field:   "amount",
+			Message: fmt.Sprintf("Amount must be at least %s %s", min, code),
+		})
+	}
+	if max, ok := v.maxAmounts[code]; ok && amount.Cmp(max) > 0 {
+		errs = append(errs, types.ValidationError{
+			Field:   "amount",
+			Message: fmt.Sprintf("Amount must not exceed %s %s", max, code),
+		})
+	}
+	return errs
+}
@@ -4,10 +4,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/govalues/decimal"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
 	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/validator"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEmptyCardNumber(t *testing.T) {
@@ -207,3 +209,303 @@ func TestNameValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectBrand(t *testing.T) {
+	tests := []struct {
+		name       string
+		cardNumber string
+		want       validator.CardBrand
+	}{
+		{name: "Visa", cardNumber: "4242424242424242", want: validator.BrandVisa},
+		{name: "MastercardClassic", cardNumber: "5555555555554444", want: validator.BrandMastercard},
+		{name: "MastercardTwoSeries", cardNumber: "2223003122003222", want: validator.BrandMastercard},
+		{name: "Amex", cardNumber: "378282246310005", want: validator.BrandAmex},
+		{name: "Discover", cardNumber: "6011111111111117", want: validator.BrandDiscover},
+		{name: "Diners", cardNumber: "30569309025904", want: validator.BrandDiners},
+		{name: "JCB", cardNumber: "3530111333300000", want: validator.BrandJCB},
+		{name: "Maestro", cardNumber: "6759649826438453", want: validator.BrandMaestro},
+		{name: "RuPay", cardNumber: "6521500000000006", want: validator.BrandRuPay},
+		{name: "Unknown", cardNumber: "9999999999999995", want: validator.BrandUnknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, validator.DetectBrand(tc.cardNumber))
+		})
+	}
+}
+
+func TestBrandSpecificRules(t *testing.T) {
+	v := validator.NewStrictValidator()
+
+	tests := []struct {
+		name           string
+		cardNumber     string
+		cvv            string
+		wantCardErrors int
+		wantCVVErrors  int
+	}{
+		{name: "AmexWithFourDigitCID", cardNumber: "378282246310005", cvv: "1234", wantCardErrors: 0, wantCVVErrors: 0},
+		{name: "AmexWithThreeDigitCVV", cardNumber: "378282246310005", cvv: "123", wantCardErrors: 0, wantCVVErrors: 1},
+		{name: "AmexWrongLength", cardNumber: "3782822463100052", cvv: "1234", wantCardErrors: 1, wantCVVErrors: 0},
+		{name: "DinersFourteenDigits", cardNumber: "30569309025904", cvv: "123", wantCardErrors: 0, wantCVVErrors: 0},
+		{name: "MaestroTwelveDigits", cardNumber: "501800000009", cvv: "123", wantCardErrors: 0, wantCVVErrors: 0},
+		{name: "MaestroNineteenDigits", cardNumber: "6759649826438453003", cvv: "123", wantCardErrors: 0, wantCVVErrors: 0},
+		{name: "RuPay", cardNumber: "6521500000000006", cvv: "123", wantCardErrors: 0, wantCVVErrors: 0},
+		{name: "VisaThirteenDigits", cardNumber: "4222222222222", cvv: "123", wantCardErrors: 0, wantCVVErrors: 0},
+		{name: "MastercardFifteenDigits", cardNumber: "555555555555442", cvv: "123", wantCardErrors: 1, wantCVVErrors: 0},
+		{name: "UnknownBrand", cardNumber: "9999999999999995", cvv: "123", wantCardErrors: 1, wantCVVErrors: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := types.PaymentRequest{
+				CardNumber: tc.cardNumber,
+				CVV:        tc.cvv,
+				Expiry:     "12/30",
+				Name:       "John Doe",
+				Timestamp:  time.Now(),
+			}
+
+			var cardErrors, cvvErrors int
+			for _, err := range v.Validate(req) {
+				switch err.Field {
+				case "card_number":
+					cardErrors++
+				case "cvv":
+					cvvErrors++
+				}
+			}
+
+			assert.Equal(t, tc.wantCardErrors, cardErrors, "card_number errors")
+			assert.Equal(t, tc.wantCVVErrors, cvvErrors, "cvv errors")
+		})
+	}
+}
+
+func TestAcceptedBrands(t *testing.T) {
+	v := validator.NewStrictValidator(validator.WithAcceptedBrands(validator.BrandVisa))
+
+	req := types.PaymentRequest{
+		CardNumber: "5555555555554444",
+		CVV:        "123",
+		Expiry:     "12/30",
+		Name:       "John Doe",
+		Amount:     decimal.One,
+		Timestamp:  time.Now(),
+	}
+	errors := v.Validate(req)
+	assert.Len(t, errors, 1)
+	assert.Equal(t, "Card brand is not supported", errors[0].Message)
+
+	req.CardNumber = "4242424242424242"
+	assert.Empty(t, v.Validate(req))
+}
+
+func TestParseBrands(t *testing.T) {
+	brands, err := validator.ParseBrands("visa, Mastercard ,amex")
+	assert.NoError(t, err)
+	assert.Equal(t, []validator.CardBrand{validator.BrandVisa, validator.BrandMastercard, validator.BrandAmex}, brands)
+
+	_, err = validator.ParseBrands("visa,bitcoin")
+	assert.Error(t, err)
+
+	_, err = validator.ParseBrands("")
+	assert.Error(t, err)
+}
+
+func TestAmountValidation(t *testing.T) {
+	v := validator.NewStrictValidator()
+
+	tests := []struct {
+		name       string
+		amount     string
+		wantErrors int
+	}{
+		{name: "ValidAmount", amount: "24.23", wantErrors: 0},
+		{name: "WholeAmount", amount: "100", wantErrors: 0},
+		{name: "TrailingZeros", amount: "10.5000", wantErrors: 0},
+		{name: "ZeroAmount", amount: "0", wantErrors: 1},
+		{name: "NegativeAmount", amount: "-5.00", wantErrors: 1},
+		{name: "ThreeDecimals", amount: "10.123", wantErrors: 1},
+		{name: "SevenDecimals", amount: "0.0000001", wantErrors: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			amount, err := decimal.Parse(tc.amount)
+			assert.NoError(t, err)
+
+			req := types.PaymentRequest{
+				CardNumber: "4242424242424242",
+				CVV:        "123",
+				Expiry:     "12/30",
+				Name:       "John Doe",
+				Amount:     amount,
+				Timestamp:  time.Now(),
+			}
+
+			foundAmountErrors := 0
+			for _, err := range v.Validate(req) {
+				if err.Field == "amount" {
+					foundAmountErrors++
+				}
+			}
+
+			if foundAmountErrors != tc.wantErrors {
+				t.Errorf("Expected %d errors for amount '%s', got %d",
+					tc.wantErrors, tc.amount, foundAmountErrors)
+			}
+		})
+	}
+}
+
+func TestAmountLimits(t *testing.T) {
+	min, err := validator.ParseAmountLimits("1.00, JPY:100", "USD")
+	require.NoError(t, err)
+	max, err := validator.ParseAmountLimits("USD:500", "USD")
+	require.NoError(t, err)
+	v := validator.NewStrictValidator(validator.WithAmountLimits(min, max))
+
+	tests := []struct {
+		name        string
+		amount      string
+		currency    string
+		wantMessage string
+	}{
+		{name: "BelowMinimum", amount: "0.99", currency: "USD", wantMessage: "Amount must be at least 1.00 USD"},
+		{name: "AtMinimum", amount: "1.00", currency: "USD"},
+		{name: "AtMaximum", amount: "500.00", currency: "USD"},
+		{name: "AboveMaximum", amount: "500.01", currency: "USD", wantMessage: "Amount must not exceed 500.00 USD"},
+		{name: "BelowCurrencyMinimum", amount: "99", currency: "JPY", wantMessage: "Amount must be at least 100 JPY"},
+		{name: "NoCurrencyMaximum", amount: "100000", currency: "JPY"},
+		{name: "UnlimitedCurrency", amount: "0.01", currency: "EUR"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := types.PaymentRequest{
+				CardNumber: "4242424242424242",
+				CVV:        "123",
+				Expiry:     "12/30",
+				Name:       "John Doe",
+				Amount:     decimal.MustParse(tc.amount),
+				Currency:   tc.currency,
+				Timestamp:  time.Now(),
+			}
+
+			errors := v.Validate(req)
+			if tc.wantMessage == "" {
+				assert.Empty(t, errors)
+				return
+			}
+			assert.Equal(t, []types.ValidationError{{Field: "amount", Message: tc.wantMessage}}, errors)
+		})
+	}
+}
+
+func TestParseAmountLimits(t *testing.T) {
+	limits, err := validator.ParseAmountLimits("25, jpy:1000 ,KWD:0.5", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, "25.00", limits["USD"].String())
+	assert.Equal(t, "1000", limits["JPY"].String())
+	assert.Equal(t, "0.500", limits["KWD"].String())
+
+	limits, err = validator.ParseAmountLimits("", "USD")
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+
+	for _, value := range []string{"JPY:0.5", "USD:0.001", "XYZ:10", "USD:0", "USD:-1", "USD:ten", "10,USD:20"} {
+		_, err := validator.ParseAmountLimits(value, "USD")
+		assert.Error(t, err, value)
+	}
+}
+
+func TestCurrencyValidation(t *testing.T) {
+	v := validator.NewStrictValidator(validator.WithCurrencies("USD", "USD", "JPY", "KWD"))
+
+	tests := []struct {
+		name             string
+		currency         string
+		amount           string
+		wantCurrencyErrs int
+		wantAmountErrs   int
+	}{
+		{name: "DefaultCurrency", currency: "", amount: "10.50"},
+		{name: "SupportedCurrency", currency: "USD", amount: "10.50"},
+		{name: "LowercaseCode", currency: "usd", amount: "10.50", wantCurrencyErrs: 1},
+		{name: "NotISOFormat", currency: "DOLLAR", amount: "10.50", wantCurrencyErrs: 1},
+		{name: "UnsupportedCurrency", currency: "EUR", amount: "10.50", wantCurrencyErrs: 1},
+		{name: "YenWholeNumber", currency: "JPY", amount: "1500"},
+		{name: "YenWithFraction", currency: "JPY", amount: "1500.5", wantAmountErrs: 1},
+		{name: "DinarThreeDecimals", currency: "KWD", amount: "2.125"},
+		{name: "DinarFourDecimals", currency: "KWD", amount: "2.1255", wantAmountErrs: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := types.PaymentRequest{
+				CardNumber: "4242424242424242",
+				CVV:        "123",
+				Expiry:     "12/30",
+				Name:       "John Doe",
+				Amount:     decimal.MustParse(tc.amount),
+				Currency:   tc.currency,
+				Timestamp:  time.Now(),
+			}
+
+			var currencyErrs, amountErrs int
+			for _, err := range v.Validate(req) {
+				switch err.Field {
+				case "currency":
+					currencyErrs++
+				case "amount":
+					amountErrs++
+				}
+			}
+
+			assert.Equal(t, tc.wantCurrencyErrs, currencyErrs, "currency errors")
+			assert.Equal(t, tc.wantAmountErrs, amountErrs, "amount errors")
+		})
+	}
+}
+
+func TestValidateCard(t *testing.T) {
+	v := validator.NewStrictValidator()
+
+	card := types.CardDetails{
+		CardNumber: "4242424242424242",
+		CVV:        "123",
+		Expiry:     "12/30",
+		Name:       "John Doe",
+	}
+	assert.Empty(t, v.ValidateCard(card), "Tokenization needs no amount or currency")
+
+	card.CVV = ""
+	errors := v.ValidateCard(card)
+	assert.Len(t, errors, 1)
+	assert.Equal(t, "cvv", errors[0].Field)
+}
+
+func TestTokenizedPaymentCVV(t *testing.T) {
+	v := validator.NewStrictValidator()
+
+	req := types.PaymentRequest{
+		CardNumber: "4242424242424242",
+		Expiry:     "12/30",
+		Name:       "John Doe",
+		CardToken:  "tok_test",
+		Amount:     decimal.One,
+		Timestamp:  time.Now(),
+	}
+	assert.Empty(t, v.Validate(req), "CVV is optional with a card token")
+
+	req.CVV = "12"
+	errors := v.Validate(req)
+	assert.Len(t, errors, 1)
+	assert.Equal(t, "cvv", errors[0].Field)
+
+	req.CardToken = ""
+	req.CVV = ""
+	assert.NotEmpty(t, v.Validate(req), "CVV is required without a card token")
+}
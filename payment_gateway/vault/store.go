@@ -0,0 +1,110 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/atomicfile"
+)
+
+// Store holds tokenized cards. When created with NewFileStore every change is
+// written to disk; card numbers only ever reach the file encrypted.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	cards map[string]Card
+}
+
+// storedCard keeps the fields that are hidden from API responses when the
+// vault is written to disk.
+type storedCard struct {
+	Card
+	Owner      string `json:"owner"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func NewMemoryStore() *Store {
+	return &Store{cards: make(map[string]Card)}
+}
+
+func NewFileStore(path string) (*Store, error) {
+	s := NewMemoryStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault store: %w", err)
+	}
+
+	var cards []storedCard
+	if err := json.Unmarshal(data, &cards); err != nil {
+		return nil, fmt.Errorf("failed to parse vault store: %w", err)
+	}
+	for _, stored := range cards {
+		card := stored.Card
+		card.Owner = stored.Owner
+		card.Ciphertext = stored.Ciphertext
+		s.cards[card.Token] = card
+	}
+	return s, nil
+}
+
+func (s *Store) Save(card Card) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cards[card.Token] = card
+	return s.persist()
+}
+
+// Get returns the card behind token. Tokens created by another owner are
+// reported as not found.
+func (s *Store) Get(owner, token string) (Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.cards[token]
+	if !ok || card.Owner != owner {
+		return Card{}, ErrNotFound
+	}
+	return card, nil
+}
+
+func (s *Store) Delete(owner, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.cards[token]
+	if !ok || card.Owner != owner {
+		return ErrNotFound
+	}
+	delete(s.cards, token)
+	return s.persist()
+}
+
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	cards := make([]storedCard, 0, len(s.cards))
+	for _, card := range s.cards {
+		cards = append(cards, storedCard{Card: card, Owner: card.Owner, Ciphertext: card.Ciphertext})
+	}
+
+	data, err := json.Marshal(cards)
+	if err != nil {
+		return fmt.Errorf("failed to encode vault store: %w", err)
+	}
+
+	if err := atomicfile.Write(s.path, data, 0o700); err != nil {
+		return fmt.Errorf("failed to write vault store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/validator"
+)
+
+// KeySize is the length of the AES-256 key that encrypts stored cards.
+const KeySize = 32
+
+const tokenPrefix = "tok_"
+
+var (
+	ErrNotFound   = errors.New("card token not found")
+	ErrInvalidKey = fmt.Errorf("vault key must be %d bytes, base64 or hex encoded", KeySize)
+)
+
+// Card is a tokenized card. Only the brand, last four digits and expiry are
+// kept in the clear; the card number and cardholder name live in Ciphertext.
+type Card struct {
+	Token      string    `json:"token"`
+	Owner      string    `json:"-"`
+	CardBrand  string    `json:"card_brand"`
+	Last4      string    `json:"last4"`
+	Expiry     string    `json:"expiry"`
+	Ciphertext []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// sealedCard is the plaintext encrypted into Card.Ciphertext. The CVV is
+// deliberately absent: it must never be stored after authorization.
+type sealedCard struct {
+	CardNumber string `json:"card_number"`
+	Expiry     string `json:"expiry"`
+	Name       string `json:"name"`
+}
+
+type Vault struct {
+	aead  cipher.AEAD
+	store *Store
+}
+
+func New(key []byte, store *Store) (*Vault, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize vault cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize vault cipher: %w", err)
+	}
+	return &Vault{aead: aead, store: store}, nil
+}
+
+// ParseKey decodes a key given as base64 or hex, as found in VAULT_KEY or a
+// key file.
+func ParseKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(value); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// GenerateKey returns a random key for vaults that only need to live as long
+// as the process.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate vault key: %w", err)
+	}
+	return key, nil
+}
+
+// Tokenize encrypts card and stores it for owner. The card is expected to
+// have been validated already.
+func (v *Vault) Tokenize(owner string, card types.CardDetails, now time.Time) (Card, error) {
+	token, err := newToken()
+	if err != nil {
+		return Card{}, err
+	}
+
+	plaintext, err := json.Marshal(sealedCard{
+		CardNumber: card.CardNumber,
+		Expiry:     card.Expiry,
+		Name:       card.Name,
+	})
+	if err != nil {
+		return Card{}, fmt.Errorf("failed to encode card: %w", err)
+	}
+
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Card{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	stored := Card{
+		Token:     token,
+		Owner:     owner,
+		CardBrand: string(validator.DetectBrand(card.CardNumber)),
+		Last4:     card.CardNumber[len(card.CardNumber)-4:],
+		Expiry:    card.Expiry,
+		CreatedAt: now,
+	}
+	// Binding the token and owner as additional data stops a ciphertext from
+	// being decrypted under another token or caller.
+	stored.Ciphertext = v.aead.Seal(nonce, nonce, plaintext, additionalData(stored))
+
+	if err := v.store.Save(stored); err != nil {
+		return Card{}, err
+	}
+	return stored, nil
+}
+
+// Detokenize returns the card details behind token for use in a payment. The
+// CVV is always empty.
+func (v *Vault) Detokenize(owner, token string) (types.CardDetails, error) {
+	stored, err := v.store.Get(owner, token)
+	if err != nil {
+		return types.CardDetails{}, err
+	}
+
+	nonceSize := v.aead.NonceSize()
+	if len(stored.Ciphertext) < nonceSize {
+		return types.CardDetails{}, errors.New("stored card is corrupt")
+	}
+	plaintext, err := v.aead.Open(nil, stored.Ciphertext[:nonceSize], stored.Ciphertext[nonceSize:], additionalData(stored))
+	if err != nil {
+		return types.CardDetails{}, fmt.Errorf("failed to decrypt card: %w", err)
+	}
+
+	var card sealedCard
+	if err := json.Unmarshal(plaintext, &card); err != nil {
+		return types.CardDetails{}, fmt.Errorf("failed to decode card: %w", err)
+	}
+	return types.CardDetails{
+		CardNumber: card.CardNumber,
+		Expiry:     card.Expiry,
+		Name:       card.Name,
+	}, nil
+}
+
+func (v *Vault) Get(owner, token string) (Card, error) {
+	return v.store.Get(owner, token)
+}
+
+func (v *Vault) Delete(owner, token string) error {
+	return v.store.Delete(owner, token)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate card token: %w", err)
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
+
+func additionalData(card Card) []byte {
+	return []byte(card.Token + "|" + card.Owner)
+}
@@ -0,0 +1,121 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCard = types.CardDetails{
+	CardNumber: "4242424242424242",
+	CVV:        "123",
+	Expiry:     "12/30",
+	Name:       "John Doe",
+}
+
+func newTestVault(t *testing.T, store *Store) *Vault {
+	t.Helper()
+	v, err := New(bytes.Repeat([]byte{7}, KeySize), store)
+	require.NoError(t, err)
+	return v
+}
+
+func TestTokenizeAndDetokenize(t *testing.T) {
+	v := newTestVault(t, NewMemoryStore())
+
+	card, err := v.Tokenize("owner-a", testCard, time.Now())
+	require.NoError(t, err)
+	assert.Regexp(t, `^tok_[0-9a-f]{32}$`, card.Token)
+	assert.Equal(t, "visa", card.CardBrand)
+	assert.Equal(t, "4242", card.Last4)
+	assert.Equal(t, "12/30", card.Expiry)
+	assert.NotContains(t, string(card.Ciphertext), testCard.CardNumber)
+
+	details, err := v.Detokenize("owner-a", card.Token)
+	require.NoError(t, err)
+	assert.Equal(t, testCard.CardNumber, details.CardNumber)
+	assert.Equal(t, testCard.Name, details.Name)
+	assert.Empty(t, details.CVV, "CVV must never be stored")
+}
+
+func TestTokensAreScopedToOwner(t *testing.T) {
+	v := newTestVault(t, NewMemoryStore())
+
+	card, err := v.Tokenize("owner-a", testCard, time.Now())
+	require.NoError(t, err)
+
+	_, err = v.Detokenize("owner-b", card.Token)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, v.Delete("owner-b", card.Token), ErrNotFound)
+
+	require.NoError(t, v.Delete("owner-a", card.Token))
+	_, err = v.Detokenize("owner-a", card.Token)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCiphertextIsBoundToToken(t *testing.T) {
+	store := NewMemoryStore()
+	v := newTestVault(t, store)
+
+	first, err := v.Tokenize("owner-a", testCard, time.Now())
+	require.NoError(t, err)
+	second, err := v.Tokenize("owner-a", testCard, time.Now())
+	require.NoError(t, err)
+
+	second.Ciphertext = first.Ciphertext
+	require.NoError(t, store.Save(second))
+
+	_, err = v.Detokenize("owner-a", second.Token)
+	assert.Error(t, err)
+}
+
+func TestFileStoreKeepsCardsEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	card, err := newTestVault(t, store).Tokenize("owner-a", testCard, time.Now())
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), testCard.CardNumber)
+	assert.NotContains(t, string(data), testCard.Name)
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	details, err := newTestVault(t, reopened).Detokenize("owner-a", card.Token)
+	require.NoError(t, err)
+	assert.Equal(t, testCard.CardNumber, details.CardNumber)
+
+	other, err := New(bytes.Repeat([]byte{8}, KeySize), reopened)
+	require.NoError(t, err)
+	_, err = other.Detokenize("owner-a", card.Token)
+	assert.Error(t, err, "a different key must not decrypt the vault")
+}
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+
+	parsed, err := ParseKey(base64.StdEncoding.EncodeToString(key) + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	parsed, err = ParseKey(hex.EncodeToString(key))
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	_, err = ParseKey("too-short")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = New([]byte("short"), NewMemoryStore())
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
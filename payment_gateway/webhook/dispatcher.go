@@ -0,0 +1,387 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// errDeliveryChanged stops an attempt from overwriting a delivery that
+// changed while it was being sent.
+var errDeliveryChanged = errors.New("webhook delivery changed during the attempt")
+
+type DispatcherConfig struct {
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry; each further retry
+	// doubles it up to MaxBackoff.
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	Timeout      time.Duration
+	// Retention is how long succeeded and failed deliveries are kept for
+	// listing and replay. Zero keeps them forever.
+	Retention time.Duration
+	// AllowPrivateTargets lets endpoints resolve to loopback, private and
+	// link-local addresses, for receivers on the gateway's own network.
+	AllowPrivateTargets bool
+}
+
+func DefaultDispatcherConfig() DispatcherConfig {
+	return DispatcherConfig{
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+		Timeout:      10 * time.Second,
+		Retention:    7 * 24 * time.Hour,
+	}
+}
+
+// Dispatcher fans events out to registered endpoints and delivers them from
+// the store's queue, retrying failures with exponential backoff.
+//
+// Every endpoint with due deliveries gets its own worker, which sends them
+// one at a time, oldest first, and exits once nothing is due. A slow or
+// unreachable endpoint therefore only holds up its own deliveries.
+type Dispatcher struct {
+	store  *Store
+	cfg    DispatcherConfig
+	client *http.Client
+	log    logrus.FieldLogger
+	now    func() time.Time
+	wake   chan struct{}
+
+	mu      sync.Mutex
+	workers map[string]chan struct{}
+	running sync.WaitGroup
+}
+
+func NewDispatcher(store *Store, cfg DispatcherConfig, log logrus.FieldLogger) *Dispatcher {
+	return &Dispatcher{
+		store:   store,
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout, Transport: newTransport(cfg.AllowPrivateTargets)},
+		log:     log,
+		now:     time.Now,
+		wake:    make(chan struct{}, 1),
+		workers: make(map[string]chan struct{}),
+	}
+}
+
+func (d *Dispatcher) Store() *Store {
+	return d.store
+}
+
+// Register creates an endpoint for owner with a freshly generated signing
+// secret. The returned endpoint is the only place the secret is exposed.
+func (d *Dispatcher) Register(owner, url string, events []string) (Endpoint, error) {
+	events, err := ValidateEvents(events)
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Endpoint{}, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	endpoint := Endpoint{
+		ID:        uuid.New().String(),
+		Owner:     owner,
+		URL:       url,
+		Events:    events,
+		Secret:    "whsec_" + hex.EncodeToString(secret),
+		CreatedAt: d.now(),
+	}
+	if err := d.store.AddEndpoint(endpoint); err != nil {
+		return Endpoint{}, err
+	}
+	return endpoint, nil
+}
+
+// Publish queues eventType for every endpoint owner has subscribed to it.
+func (d *Dispatcher) Publish(owner, eventType string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	now := d.now()
+	event := Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      raw,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	queued := 0
+	for _, endpoint := range d.store.Endpoints(owner) {
+		if !endpoint.subscribes(eventType) {
+			continue
+		}
+		delivery := Delivery{
+			ID:            uuid.New().String(),
+			EndpointID:    endpoint.ID,
+			Owner:         owner,
+			URL:           endpoint.URL,
+			EventID:       event.ID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := d.store.SaveDelivery(delivery); err != nil {
+			return err
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		d.notify()
+	}
+	return nil
+}
+
+// Replay queues a delivery to be sent again immediately, regardless of its
+// current status, with a fresh retry budget.
+func (d *Dispatcher) Replay(owner, deliveryID string) (Delivery, error) {
+	now := d.now()
+	delivery, err := d.store.UpdateDelivery(deliveryID, func(delivery *Delivery) error {
+		if delivery.Owner != owner {
+			return ErrNotFound
+		}
+		delivery.Status = DeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = &now
+		delivery.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d.notify()
+	return delivery, nil
+}
+
+// Run delivers queued events until ctx is cancelled, then waits for the
+// endpoint workers to stop.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	defer d.Wait()
+
+	for {
+		d.prune()
+		d.ProcessDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// ProcessDue hands every delivery that is due to the worker of its endpoint,
+// starting workers as needed, and returns how many were due. It does not
+// wait for them to be sent; Wait does.
+func (d *Dispatcher) ProcessDue(ctx context.Context) int {
+	due := d.store.Due("", d.now())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, delivery := range due {
+		if wake, ok := d.workers[delivery.EndpointID]; ok {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		wake := make(chan struct{}, 1)
+		d.workers[delivery.EndpointID] = wake
+		d.running.Add(1)
+		go d.work(ctx, delivery.EndpointID, wake)
+	}
+	return len(due)
+}
+
+// Wait blocks until every endpoint worker has run out of due deliveries.
+func (d *Dispatcher) Wait() {
+	d.running.Wait()
+}
+
+// work sends the due deliveries of one endpoint until none are left. A wake
+// signal that arrives while it is busy makes it look again before exiting.
+func (d *Dispatcher) work(ctx context.Context, endpointID string, wake chan struct{}) {
+	defer d.running.Done()
+
+	for {
+		for _, delivery := range d.store.Due(endpointID, d.now()) {
+			if ctx.Err() != nil {
+				break
+			}
+			d.attempt(ctx, delivery)
+		}
+
+		d.mu.Lock()
+		select {
+		case <-wake:
+			if ctx.Err() == nil {
+				d.mu.Unlock()
+				continue
+			}
+		default:
+		}
+		delete(d.workers, endpointID)
+		d.mu.Unlock()
+		return
+	}
+}
+
+// attempt sends delivery once and records the outcome. If the delivery was
+// replayed or otherwise changed while the request was in flight, the newer
+// state is kept and the outcome dropped.
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) {
+	deliveryLogger := d.log.WithFields(logrus.Fields{
+		"delivery_id": delivery.ID,
+		"endpoint_id": delivery.EndpointID,
+		"event_type":  delivery.EventType,
+		"attempt":     delivery.Attempts + 1,
+	})
+
+	statusCode, sendErr := d.send(ctx, delivery)
+
+	now := d.now()
+	updated, err := d.store.UpdateDelivery(delivery.ID, func(current *Delivery) error {
+		if current.Status != delivery.Status || current.Attempts != delivery.Attempts || !current.UpdatedAt.Equal(delivery.UpdatedAt) {
+			return errDeliveryChanged
+		}
+
+		current.Attempts++
+		current.LastStatusCode = statusCode
+		current.UpdatedAt = now
+		switch {
+		case sendErr == nil:
+			current.Status = DeliverySucceeded
+			current.LastError = ""
+			current.NextAttemptAt = nil
+		case current.Attempts >= d.cfg.MaxAttempts:
+			current.Status = DeliveryFailed
+			current.LastError = sendErr.Error()
+			current.NextAttemptAt = nil
+		default:
+			next := now.Add(d.backoff(current.Attempts))
+			current.LastError = sendErr.Error()
+			current.NextAttemptAt = &next
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errDeliveryChanged):
+		deliveryLogger.Info("Webhook delivery changed while it was sent, keeping the newer state")
+		return
+	case err != nil:
+		deliveryLogger.WithError(err).Error("Failed to record webhook delivery")
+		return
+	}
+
+	switch {
+	case sendErr == nil:
+		deliveryLogger.Info("Webhook delivered")
+	case updated.Status == DeliveryFailed:
+		deliveryLogger.WithError(sendErr).Error("Webhook delivery failed permanently")
+	default:
+		deliveryLogger.WithError(sendErr).WithField("next_attempt_at", *updated.NextAttemptAt).Warn("Webhook delivery failed, will retry")
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery) (int, error) {
+	endpoint, err := d.endpoint(delivery)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, d.now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) endpoint(delivery Delivery) (Endpoint, error) {
+	for _, endpoint := range d.store.Endpoints(delivery.Owner) {
+		if endpoint.ID == delivery.EndpointID {
+			return endpoint, nil
+		}
+	}
+	return Endpoint{}, fmt.Errorf("endpoint %s no longer registered", delivery.EndpointID)
+}
+
+// prune drops finished deliveries older than cfg.Retention, so the queue
+// does not grow without bound.
+func (d *Dispatcher) prune() {
+	if d.cfg.Retention <= 0 {
+		return
+	}
+	removed, err := d.store.Prune(d.now().Add(-d.cfg.Retention))
+	if err != nil {
+		d.log.WithError(err).Error("Failed to prune webhook deliveries")
+		return
+	}
+	if removed > 0 {
+		d.log.WithField("removed", removed).Info("Pruned finished webhook deliveries")
+	}
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/payment_gateway/atomicfile"
+)
+
+var ErrNotFound = errors.New("webhook resource not found")
+
+// Store holds registered endpoints and the delivery queue. When created with
+// NewFileStore every change is written to disk, so pending deliveries survive
+// a restart and are retried once the dispatcher runs again.
+type Store struct {
+	mu         sync.Mutex
+	path       string
+	endpoints  map[string]Endpoint
+	deliveries map[string]Delivery
+}
+
+type storeFile struct {
+	Endpoints  []storedEndpoint `json:"endpoints"`
+	Deliveries []storedDelivery `json:"deliveries"`
+}
+
+func NewMemoryStore() *Store {
+	return &Store{
+		endpoints:  make(map[string]Endpoint),
+		deliveries: make(map[string]Delivery),
+	}
+}
+
+func NewFileStore(path string) (*Store, error) {
+	s := NewMemoryStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook store: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook store: %w", err)
+	}
+	for _, stored := range file.Endpoints {
+		endpoint := stored.Endpoint
+		endpoint.Owner = stored.Owner
+		endpoint.Secret = stored.Secret
+		s.endpoints[endpoint.ID] = endpoint
+	}
+	for _, stored := range file.Deliveries {
+		delivery := stored.Delivery
+		delivery.Owner = stored.Owner
+		delivery.Payload = stored.Payload
+		s.deliveries[delivery.ID] = delivery
+	}
+	return s, nil
+}
+
+func (s *Store) AddEndpoint(endpoint Endpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.endpoints[endpoint.ID] = endpoint
+	return s.persist()
+}
+
+func (s *Store) DeleteEndpoint(owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoint, ok := s.endpoints[id]
+	if !ok || endpoint.Owner != owner {
+		return ErrNotFound
+	}
+	delete(s.endpoints, id)
+	return s.persist()
+}
+
+func (s *Store) Endpoints(owner string) []Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var endpoints []Endpoint
+	for _, endpoint := range s.endpoints {
+		if endpoint.Owner == owner {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+	return endpoints
+}
+
+func (s *Store) SaveDelivery(delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deliveries[delivery.ID] = delivery
+	return s.persist()
+}
+
+// UpdateDelivery applies fn to the stored delivery with id and saves the
+// result. Changes made by fn are discarded if it returns an error or the
+// store cannot be written.
+func (s *Store) UpdateDelivery(id string, fn func(delivery *Delivery) error) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	delivery := previous
+	if err := fn(&delivery); err != nil {
+		return Delivery{}, err
+	}
+
+	s.deliveries[id] = delivery
+	if err := s.persist(); err != nil {
+		s.deliveries[id] = previous
+		return Delivery{}, err
+	}
+	return delivery, nil
+}
+
+func (s *Store) Delivery(owner, id string) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, ok := s.deliveries[id]
+	if !ok || delivery.Owner != owner {
+		return Delivery{}, ErrNotFound
+	}
+	return delivery, nil
+}
+
+// Deliveries lists an owner's deliveries, newest first. An empty status
+// matches every delivery.
+func (s *Store) Deliveries(owner, status string) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range s.deliveries {
+		if delivery.Owner == owner && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	return deliveries
+}
+
+// Due returns the pending deliveries to endpointID whose next attempt is at
+// or before now, oldest first. An empty endpointID matches every endpoint.
+func (s *Store) Due(endpointID string, now time.Time) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Delivery
+	for _, delivery := range s.deliveries {
+		if endpointID != "" && delivery.EndpointID != endpointID {
+			continue
+		}
+		if delivery.Status == DeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	return due
+}
+
+// Prune removes the succeeded and failed deliveries last updated before
+// cutoff and returns how many it removed. Pending deliveries are kept however
+// old they are.
+func (s *Store) Prune(cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []Delivery
+	for id, delivery := range s.deliveries {
+		if delivery.Status != DeliveryPending && delivery.UpdatedAt.Before(cutoff) {
+			removed = append(removed, delivery)
+			delete(s.deliveries, id)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	if err := s.persist(); err != nil {
+		for _, delivery := range removed {
+			s.deliveries[delivery.ID] = delivery
+		}
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var file storeFile
+	for _, endpoint := range s.endpoints {
+		file.Endpoints = append(file.Endpoints, storedEndpoint{Endpoint: endpoint, Owner: endpoint.Owner, Secret: endpoint.Secret})
+	}
+	for _, delivery := range s.deliveries {
+		file.Deliveries = append(file.Deliveries, storedDelivery{Delivery: delivery, Owner: delivery.Owner, Payload: delivery.Payload})
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook store: %w", err)
+	}
+
+	if err := atomicfile.Write(s.path, data, 0o755); err != nil {
+		return fmt.Errorf("failed to write webhook store: %w", err)
+	}
+	return nil
+}
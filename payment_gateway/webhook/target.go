@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget is the delivery error for endpoints that resolve to an
+// address webhooks may not be sent to.
+var ErrForbiddenTarget = errors.New("webhook target address is not allowed")
+
+// Ranges that pass the netip checks below but are not reachable on the
+// public internet.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// publicAddr reports whether addr is a public unicast address. Loopback,
+// private, link-local (which holds cloud metadata services such as
+// 169.254.169.254) and multicast addresses are not.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTarget runs on the resolved address of every connection, so a host
+// name that resolves, or later rebinds, to a non-public address is refused
+// as well as a literal one.
+func checkTarget(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenTarget, address)
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s is not a public address", ErrForbiddenTarget, addrPort.Addr())
+	}
+	return nil
+}
+
+// newTransport returns the transport deliveries are sent with. Unless
+// allowPrivate is set it only connects to public addresses, so a registered
+// URL cannot be used to reach the gateway's own network.
+func newTransport(allowPrivate bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkTarget}
+		transport.DialContext = dialer.DialContext
+	}
+	return transport
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+	EventPaymentRefunded  = "payment.refunded"
+)
+
+// AllEvents is used when an endpoint does not subscribe to specific events.
+var AllEvents = []string{EventPaymentSucceeded, EventPaymentFailed, EventPaymentRefunded}
+
+var ErrUnknownEvent = errors.New("unknown event type")
+
+const (
+	SignatureHeader = "X-Skyfox-Signature"
+	EventHeader     = "X-Skyfox-Event"
+	DeliveryHeader  = "X-Skyfox-Delivery"
+)
+
+const (
+	DeliveryPending   = "PENDING"
+	DeliverySucceeded = "SUCCEEDED"
+	DeliveryFailed    = "FAILED"
+)
+
+type Endpoint struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"-"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e Endpoint) subscribes(eventType string) bool {
+	for _, event := range e.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type Delivery struct {
+	ID             string     `json:"id"`
+	EndpointID     string     `json:"endpoint_id"`
+	Owner          string     `json:"-"`
+	URL            string     `json:"url"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// storedDelivery keeps the fields that are hidden from API responses when the
+// delivery queue is written to disk.
+type storedDelivery struct {
+	Delivery
+	Owner   string `json:"owner"`
+	Payload []byte `json:"payload"`
+}
+
+type storedEndpoint struct {
+	Endpoint
+	Owner  string `json:"owner"`
+	Secret string `json:"secret"`
+}
+
+// ValidateEvents checks that every event type is known. An empty list
+// subscribes to all events.
+func ValidateEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return append([]string(nil), AllEvents...), nil
+	}
+	for _, event := range events {
+		known := false
+		for _, candidate := range AllEvents {
+			if event == candidate {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%w %q", ErrUnknownEvent, event)
+		}
+	}
+	return events, nil
+}
+
+// Sign computes the signature header value for body. Receivers recompute the
+// HMAC-SHA256 of "<timestamp>.<body>" with their endpoint secret and compare
+// it with the v1 value.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + ts + ",v1=" + computeSignature(secret, ts, body)
+}
+
+// Verify checks a signature header produced by Sign and rejects signatures
+// older than tolerance.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signature = value
+		}
+	}
+	if ts == "" || signature == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed signature timestamp")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+
+	expected := computeSignature(secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func computeSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
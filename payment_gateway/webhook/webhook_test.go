@@ -0,0 +1,358 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(status int) (*receiver, *httptest.Server) {
+	r := &receiver{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := r.status
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return r, server
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func testDispatcher(store *Store) *Dispatcher {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return NewDispatcher(store, DispatcherConfig{
+		MaxAttempts:  3,
+		BaseBackoff:  time.Minute,
+		MaxBackoff:   10 * time.Minute,
+		PollInterval: time.Second,
+		Timeout:      time.Second,
+		// The receivers are httptest servers on the loopback interface
+		AllowPrivateTargets: true,
+	}, logger)
+}
+
+// processDue runs one round of deliveries and waits for it to finish.
+func processDue(dispatcher *Dispatcher) int {
+	due := dispatcher.ProcessDue(context.Background())
+	dispatcher.Wait()
+	return due
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":"evt_1"}`)
+	header := Sign("secret", now, body)
+
+	assert.NoError(t, Verify("secret", header, body, time.Minute, now))
+	assert.Error(t, Verify("other-secret", header, body, time.Minute, now))
+	assert.Error(t, Verify("secret", header, []byte(`{"id":"evt_2"}`), time.Minute, now))
+	assert.Error(t, Verify("secret", header, body, time.Minute, now.Add(2*time.Minute)))
+	assert.Error(t, Verify("secret", "garbage", body, time.Minute, now))
+}
+
+func TestDeliverySignedAndSucceeds(t *testing.T) {
+	recv, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	dispatcher := testDispatcher(NewMemoryStore())
+	endpoint, err := dispatcher.Register("owner-a", server.URL, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, AllEvents, endpoint.Events)
+
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentSucceeded, map[string]string{"transaction_id": "txn-1"}))
+	assert.Equal(t, 1, processDue(dispatcher))
+	require.Equal(t, 1, recv.count())
+
+	req := recv.requests[0]
+	assert.Equal(t, EventPaymentSucceeded, req.Header.Get(EventHeader))
+	assert.NoError(t, Verify(endpoint.Secret, req.Header.Get(SignatureHeader), recv.bodies[0], time.Minute, time.Now()))
+
+	var event Event
+	require.NoError(t, json.Unmarshal(recv.bodies[0], &event))
+	assert.Equal(t, EventPaymentSucceeded, event.Type)
+	assert.JSONEq(t, `{"transaction_id":"txn-1"}`, string(event.Data))
+
+	deliveries := dispatcher.Store().Deliveries("owner-a", "")
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+}
+
+func TestOnlySubscribedOwnersReceiveEvents(t *testing.T) {
+	recv, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	dispatcher := testDispatcher(NewMemoryStore())
+	_, err := dispatcher.Register("owner-a", server.URL, []string{EventPaymentRefunded})
+	require.NoError(t, err)
+
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentSucceeded, nil))
+	require.NoError(t, dispatcher.Publish("owner-b", EventPaymentRefunded, nil))
+	processDue(dispatcher)
+	assert.Equal(t, 0, recv.count())
+
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentRefunded, nil))
+	processDue(dispatcher)
+	assert.Equal(t, 1, recv.count())
+}
+
+func TestRegisterRejectsUnknownEvents(t *testing.T) {
+	dispatcher := testDispatcher(NewMemoryStore())
+	_, err := dispatcher.Register("owner-a", "http://localhost", []string{"payment.exploded"})
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestSlowEndpointDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	dispatcher := testDispatcher(NewMemoryStore())
+	_, err := dispatcher.Register("owner-a", slow.URL, nil)
+	require.NoError(t, err)
+	_, err = dispatcher.Register("owner-a", server.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentSucceeded, nil))
+
+	// ProcessDue hands the deliveries to the endpoint workers and returns
+	// without waiting for the slow one
+	assert.Equal(t, 2, dispatcher.ProcessDue(context.Background()))
+	assert.Eventually(t, func() bool { return fast.count() == 1 }, time.Second, 10*time.Millisecond)
+	release <- struct{}{}
+	dispatcher.Wait()
+}
+
+func TestRetriesWithExponentialBackoff(t *testing.T) {
+	recv, server := newReceiver(http.StatusInternalServerError)
+	defer server.Close()
+
+	now := time.Now()
+	dispatcher := testDispatcher(NewMemoryStore())
+	dispatcher.now = func() time.Time { return now }
+
+	_, err := dispatcher.Register("owner-a", server.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentFailed, nil))
+
+	processDue(dispatcher)
+	delivery := dispatcher.Store().Deliveries("owner-a", "")[0]
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Equal(t, http.StatusInternalServerError, delivery.LastStatusCode)
+	assert.Equal(t, now.Add(time.Minute), *delivery.NextAttemptAt)
+
+	// Not due yet.
+	assert.Equal(t, 0, processDue(dispatcher))
+
+	now = now.Add(time.Minute)
+	processDue(dispatcher)
+	delivery = dispatcher.Store().Deliveries("owner-a", "")[0]
+	assert.Equal(t, now.Add(2*time.Minute), *delivery.NextAttemptAt)
+
+	now = now.Add(2 * time.Minute)
+	processDue(dispatcher)
+	delivery = dispatcher.Store().Deliveries("owner-a", "")[0]
+	assert.Equal(t, DeliveryFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Nil(t, delivery.NextAttemptAt)
+	assert.Equal(t, 3, recv.count())
+
+	recv.setStatus(http.StatusOK)
+	replayed, err := dispatcher.Replay("owner-a", delivery.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeliveryPending, replayed.Status)
+
+	processDue(dispatcher)
+	delivery = dispatcher.Store().Deliveries("owner-a", "")[0]
+	assert.Equal(t, DeliverySucceeded, delivery.Status)
+	assert.Equal(t, 4, recv.count())
+
+	_, err = dispatcher.Replay("owner-b", delivery.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestReplayDuringAttemptIsKept(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	recv := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recv.mu.Lock()
+		recv.requests = append(recv.requests, req)
+		first := len(recv.requests) == 1
+		recv.mu.Unlock()
+		if first {
+			received <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dispatcher := testDispatcher(NewMemoryStore())
+	_, err := dispatcher.Register("owner-a", server.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentFailed, nil))
+
+	dispatcher.ProcessDue(context.Background())
+	<-received
+	delivery := dispatcher.Store().Deliveries("owner-a", "")[0]
+	_, err = dispatcher.Replay("owner-a", delivery.ID)
+	require.NoError(t, err)
+	close(release)
+	dispatcher.Wait()
+
+	// The failed attempt did not push the replayed delivery back, so it is
+	// due again straight away
+	assert.Equal(t, 1, processDue(dispatcher))
+	assert.Equal(t, 2, recv.count())
+	delivery = dispatcher.Store().Deliveries("owner-a", "")[0]
+	assert.Equal(t, 1, delivery.Attempts)
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	dispatcher := testDispatcher(NewMemoryStore())
+
+	assert.Equal(t, time.Minute, dispatcher.backoff(1))
+	assert.Equal(t, 2*time.Minute, dispatcher.backoff(2))
+	assert.Equal(t, 8*time.Minute, dispatcher.backoff(4))
+	assert.Equal(t, 10*time.Minute, dispatcher.backoff(5))
+}
+
+func TestFileStorePersistsQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	dispatcher := testDispatcher(store)
+
+	endpoint, err := dispatcher.Register("owner-a", "http://127.0.0.1:1", nil)
+	require.NoError(t, err)
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentSucceeded, map[string]string{"id": "txn-1"}))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	endpoints := reopened.Endpoints("owner-a")
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, endpoint.Secret, endpoints[0].Secret)
+
+	due := reopened.Due("", time.Now())
+	require.Len(t, due, 1)
+	assert.Equal(t, "owner-a", due[0].Owner)
+	assert.NotEmpty(t, due[0].Payload)
+}
+
+func TestDeleteEndpoint(t *testing.T) {
+	dispatcher := testDispatcher(NewMemoryStore())
+	endpoint, err := dispatcher.Register("owner-a", "http://localhost", nil)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, dispatcher.Store().DeleteEndpoint("owner-b", endpoint.ID), ErrNotFound)
+	assert.NoError(t, dispatcher.Store().DeleteEndpoint("owner-a", endpoint.ID))
+	assert.Empty(t, dispatcher.Store().Endpoints("owner-a"))
+}
+
+func TestPruneRemovesOnlyFinishedDeliveries(t *testing.T) {
+	_, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	now := time.Now()
+	dispatcher := testDispatcher(NewMemoryStore())
+	dispatcher.now = func() time.Time { return now }
+	_, err := dispatcher.Register("owner-a", server.URL, []string{EventPaymentSucceeded})
+	require.NoError(t, err)
+	_, err = dispatcher.Register("owner-a", "http://127.0.0.1:1", []string{EventPaymentRefunded})
+	require.NoError(t, err)
+
+	// One delivery succeeds, the other fails and stays pending
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentSucceeded, nil))
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentRefunded, nil))
+	processDue(dispatcher)
+	now = now.Add(time.Hour)
+
+	removed, err := dispatcher.Store().Prune(now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	deliveries := dispatcher.Store().Deliveries("owner-a", "")
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, EventPaymentRefunded, deliveries[0].EventType)
+	assert.Equal(t, DeliveryPending, deliveries[0].Status)
+}
+
+func TestPrivateTargetsAreRefused(t *testing.T) {
+	recv, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dispatcher := NewDispatcher(NewMemoryStore(), DefaultDispatcherConfig(), logger)
+	_, err := dispatcher.Register("owner-a", server.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, dispatcher.Publish("owner-a", EventPaymentSucceeded, nil))
+
+	processDue(dispatcher)
+	assert.Equal(t, 0, recv.count())
+	delivery := dispatcher.Store().Deliveries("owner-a", "")[0]
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Contains(t, delivery.LastError, ErrForbiddenTarget.Error())
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fd00:ec2::254":    false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	} {
+		assert.Equal(t, public, publicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
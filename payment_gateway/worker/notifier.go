@@ -0,0 +1,51 @@
+package worker
+
+import "sync"
+
+// Notifier lets long-poll requests wait for a transaction to leave the
+// PENDING state. Subscribe before reading the current state so that a
+// notification sent in between is not missed.
+type Notifier struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{waiters: make(map[string][]chan struct{})}
+}
+
+// Subscribe returns a channel that is closed on the next Notify for id, and a
+// function that releases the subscription if the caller stops waiting.
+func (n *Notifier) Subscribe(id string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch := make(chan struct{})
+	n.waiters[id] = append(n.waiters[id], ch)
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		waiters := n.waiters[id]
+		for i, waiter := range waiters {
+			if waiter == ch {
+				n.waiters[id] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(n.waiters[id]) == 0 {
+			delete(n.waiters, id)
+		}
+	}
+}
+
+func (n *Notifier) Notify(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, ch := range n.waiters[id] {
+		close(ch)
+	}
+	delete(n.waiters, id)
+}
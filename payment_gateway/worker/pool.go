@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrQueueFull = errors.New("worker queue is full")
+	ErrStopped   = errors.New("worker pool is stopped")
+)
+
+type Job func(ctx context.Context)
+
+// Pool runs submitted jobs on a fixed number of goroutines. Submissions that
+// would exceed the queue size are rejected instead of blocking the caller.
+type Pool struct {
+	jobs    chan Job
+	workers int
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	stopped bool
+}
+
+func NewPool(workers, queueSize int) *Pool {
+	return &Pool{
+		jobs:    make(chan Job, queueSize),
+		workers: workers,
+	}
+}
+
+// Start launches the workers. Jobs receive ctx, which should outlive any
+// single request.
+func (p *Pool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job(ctx)
+			}
+		}()
+	}
+}
+
+func (p *Pool) Submit(job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting jobs and waits for queued and running jobs to
+// finish, or for ctx to expire.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolRunsJobs(t *testing.T) {
+	pool := NewPool(2, 10)
+	pool.Start(context.Background())
+
+	var ran atomic.Int32
+	for i := 0; i < 5; i++ {
+		require.NoError(t, pool.Submit(func(ctx context.Context) { ran.Add(1) }))
+	}
+
+	require.NoError(t, pool.Shutdown(context.Background()))
+	assert.Equal(t, int32(5), ran.Load())
+	assert.ErrorIs(t, pool.Submit(func(ctx context.Context) {}), ErrStopped)
+}
+
+func TestPoolRejectsWhenQueueFull(t *testing.T) {
+	pool := NewPool(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.Start(context.Background())
+
+	require.NoError(t, pool.Submit(func(ctx context.Context) {
+		close(started)
+		<-release
+	}))
+	<-started
+	require.NoError(t, pool.Submit(func(ctx context.Context) {}))
+	assert.ErrorIs(t, pool.Submit(func(ctx context.Context) {}), ErrQueueFull)
+
+	close(release)
+	require.NoError(t, pool.Shutdown(context.Background()))
+}
+
+func TestShutdownHonoursDeadline(t *testing.T) {
+	pool := NewPool(1, 1)
+	release := make(chan struct{})
+	defer close(release)
+	pool.Start(context.Background())
+
+	require.NoError(t, pool.Submit(func(ctx context.Context) { <-release }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestNotifier(t *testing.T) {
+	notifier := NewNotifier()
+
+	first, _ := notifier.Subscribe("txn-1")
+	second, release := notifier.Subscribe("txn-1")
+	other, _ := notifier.Subscribe("txn-2")
+	release()
+
+	notifier.Notify("txn-1")
+
+	select {
+	case <-first:
+	case <-time.After(time.Second):
+		t.Fatal("expected subscriber to be notified")
+	}
+
+	select {
+	case <-second:
+		t.Fatal("released subscription should not be notified")
+	default:
+	}
+
+	select {
+	case <-other:
+		t.Fatal("other transaction should not be notified")
+	default:
+	}
+}
@@ -0,0 +1,290 @@
+// Package apikey authenticates requests against a registry of named, scoped
+// API keys. Only SHA-256 hashes of the keys are stored.
+package apikey
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/signing"
+	"github.com/sirupsen/logrus"
+)
+
+// ScopeAll grants every scope.
+const ScopeAll = "*"
+
+const hashPrefix = "sha256:"
+
+var (
+	ErrInvalidKey         = errors.New("invalid API key")
+	ErrExpired            = errors.New("API key has expired")
+	ErrUnknownCertificate = errors.New("client certificate is not mapped to an API key")
+)
+
+// Key is a registry entry. Several entries may share a name, which is how a
+// key is rotated: add the new key under the same name, roll it out, then
+// remove or expire the old one.
+//
+// Hash authenticates the x-api-key header. Secret is the shared secret for
+// signed requests; HMAC needs it in the clear, so keep the registry file
+// readable only by the service. ClientIdentities are client certificate
+// names (common name or subject alternative name) that authenticate as this
+// key over mutual TLS. An entry needs at least one of the three.
+type Key struct {
+	Name             string     `json:"name"`
+	Hash             string     `json:"hash,omitempty"`
+	Secret           string     `json:"secret,omitempty"`
+	ClientIdentities []string   `json:"client_identities,omitempty"`
+	Scopes           []string   `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+
+	digest []byte
+}
+
+// HasScope reports whether the key grants scope, either directly, through
+// ScopeAll or through a resource wildcard such as "payments:*".
+func (k Key) HasScope(scope string) bool {
+	resource, _, _ := strings.Cut(scope, ":")
+	for _, granted := range k.Scopes {
+		if granted == ScopeAll || granted == scope || granted == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (k Key) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Hash returns the registry form of a raw API key.
+func Hash(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+type registryFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// Registry holds the accepted keys. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	keys    []Key
+	path    string
+	modTime time.Time
+	size    int64
+	now     func() time.Time
+}
+
+// NewRegistry returns a registry of fixed keys.
+func NewRegistry(keys ...Key) (*Registry, error) {
+	prepared, err := prepare(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{keys: prepared, now: time.Now}, nil
+}
+
+// LoadFile reads a JSON registry of the form {"keys": [...]}. Call Reload or
+// Watch to pick up later changes to the file.
+func LoadFile(path string) (*Registry, error) {
+	r := &Registry{path: path, now: time.Now}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the registry file if it changed since the last load. On
+// error the keys already loaded stay in use.
+func (r *Registry) Reload() (bool, error) {
+	if r.path == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read API key file: %w", err)
+	}
+
+	r.mu.RLock()
+	unchanged := info.ModTime().Equal(r.modTime) && info.Size() == r.size
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read API key file: %w", err)
+	}
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return false, fmt.Errorf("failed to parse API key file: %w", err)
+	}
+	keys, err := prepare(file.Keys)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the registry file every interval until ctx is done. Failed
+// reloads are logged and the previous keys stay in use.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if err != nil {
+				logger.WithError(err).Error("Failed to reload API keys")
+				continue
+			}
+			if changed {
+				logger.WithField("keys", r.Len()).Info("Reloaded API keys")
+			}
+		}
+	}
+}
+
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.keys)
+}
+
+// Authenticate finds the key matching rawKey. Every entry is compared in
+// constant time, so response times do not reveal how close a guess was.
+func (r *Registry) Authenticate(rawKey string) (Key, error) {
+	sum := sha256.Sum256([]byte(rawKey))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var match Key
+	found := 0
+	for _, key := range r.keys {
+		if key.digest == nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(sum[:], key.digest) == 1 {
+			match = key
+			found = 1
+		}
+	}
+	if found == 0 {
+		return Key{}, ErrInvalidKey
+	}
+	if match.Expired(r.now()) {
+		return match, ErrExpired
+	}
+	return match, nil
+}
+
+// VerifySignature finds the key named name whose secret produced signature
+// over stringToSign. Every secret under the name is checked in constant
+// time.
+func (r *Registry) VerifySignature(name, stringToSign, signature string) (Key, error) {
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return Key{}, ErrInvalidSignature
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var match Key
+	found := 0
+	for _, key := range r.keys {
+		if key.Name != name || key.Secret == "" {
+			continue
+		}
+		expected, _ := hex.DecodeString(signing.Compute([]byte(key.Secret), stringToSign))
+		if hmac.Equal(given, expected) {
+			match = key
+			found = 1
+		}
+	}
+	if found == 0 {
+		return Key{}, ErrInvalidSignature
+	}
+	if match.Expired(r.now()) {
+		return match, ErrExpired
+	}
+	return match, nil
+}
+
+// AuthenticateCertificate finds the key that lists one of identities, the
+// names on a verified client certificate.
+func (r *Registry) AuthenticateCertificate(identities []string) (Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		for _, allowed := range key.ClientIdentities {
+			if slices.Contains(identities, allowed) {
+				if key.Expired(r.now()) {
+					return key, ErrExpired
+				}
+				return key, nil
+			}
+		}
+	}
+	return Key{}, ErrUnknownCertificate
+}
+
+func prepare(keys []Key) ([]Key, error) {
+	prepared := make([]Key, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("API key %d has no name", i+1)
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("API key %q has no scopes", key.Name)
+		}
+		if key.Hash == "" && key.Secret == "" && len(key.ClientIdentities) == 0 {
+			return nil, fmt.Errorf("API key %q needs a hash, a signing secret or client identities", key.Name)
+		}
+		if key.Secret != "" && len(key.Secret) < signing.MinSecretLength {
+			return nil, fmt.Errorf("API key %q signing secret must be at least %d characters", key.Name, signing.MinSecretLength)
+		}
+		if key.Hash != "" {
+			digest, err := hex.DecodeString(strings.TrimPrefix(key.Hash, hashPrefix))
+			if err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("API key %q must have a hash of the form %s<64 hex digits>", key.Name, hashPrefix)
+			}
+			if seen[string(digest)] {
+				return nil, fmt.Errorf("API key %q duplicates another key's hash", key.Name)
+			}
+			seen[string(digest)] = true
+			key.digest = digest
+		}
+
+		prepared = append(prepared, key)
+	}
+	return prepared, nil
+}
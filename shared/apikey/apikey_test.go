@@ -0,0 +1,247 @@
+package apikey
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRegistry(t *testing.T, path string, keys ...Key) {
+	t.Helper()
+	data, err := json.Marshal(registryFile{Keys: keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestHasScope(t *testing.T) {
+	key := Key{Scopes: []string{"payments:write", "webhooks:*"}}
+
+	assert.True(t, key.HasScope("payments:write"))
+	assert.False(t, key.HasScope("payments:refund"))
+	assert.True(t, key.HasScope("webhooks:manage"))
+	assert.False(t, key.HasScope("movies:read"))
+	assert.True(t, Key{Scopes: []string{ScopeAll}}.HasScope("movies:read"))
+}
+
+func TestAuthenticate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	registry, err := NewRegistry(
+		Key{Name: "booking", Hash: Hash("booking-secret"), Scopes: []string{"payments:write"}},
+		Key{Name: "old", Hash: Hash("old-secret"), Scopes: []string{"payments:write"}, ExpiresAt: &past},
+	)
+	require.NoError(t, err)
+
+	key, err := registry.Authenticate("booking-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "booking", key.Name)
+
+	key, err = registry.Authenticate("old-secret")
+	assert.ErrorIs(t, err, ErrExpired)
+	assert.Equal(t, "old", key.Name)
+
+	_, err = registry.Authenticate("booking-secret ")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestRotationUnderOneName(t *testing.T) {
+	registry, err := NewRegistry(
+		Key{Name: "booking", Hash: Hash("first"), Scopes: []string{ScopeAll}},
+		Key{Name: "booking", Hash: Hash("second"), Scopes: []string{ScopeAll}},
+	)
+	require.NoError(t, err)
+
+	for _, raw := range []string{"first", "second"} {
+		key, err := registry.Authenticate(raw)
+		require.NoError(t, err)
+		assert.Equal(t, "booking", key.Name)
+	}
+}
+
+func TestRegistryValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{name: "MissingName", key: Key{Hash: Hash("a"), Scopes: []string{ScopeAll}}},
+		{name: "MissingScopes", key: Key{Name: "a", Hash: Hash("a")}},
+		{name: "BadHash", key: Key{Name: "a", Hash: "sha256:abc", Scopes: []string{ScopeAll}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRegistry(tc.key)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := NewRegistry(
+		Key{Name: "a", Hash: Hash("same"), Scopes: []string{ScopeAll}},
+		Key{Name: "b", Hash: Hash("same"), Scopes: []string{ScopeAll}},
+	)
+	assert.Error(t, err, "duplicate hashes are ambiguous")
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeRegistry(t, path, Key{Name: "first", Hash: Hash("first"), Scopes: []string{ScopeAll}})
+
+	registry, err := LoadFile(path)
+	require.NoError(t, err)
+
+	changed, err := registry.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	writeRegistry(t, path,
+		Key{Name: "first", Hash: Hash("first"), Scopes: []string{ScopeAll}},
+		Key{Name: "second", Hash: Hash("second"), Scopes: []string{ScopeAll}},
+	)
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+
+	changed, err = registry.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, err = registry.Authenticate("second")
+	assert.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+
+	_, err = registry.Reload()
+	assert.Error(t, err)
+	_, err = registry.Authenticate("second")
+	assert.NoError(t, err, "a broken file keeps the previous keys")
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	past := time.Now().Add(-time.Hour)
+	registry, err := NewRegistry(
+		Key{Name: "booking", Hash: Hash("booking-secret"), Scopes: []string{"payments:write"}},
+		Key{Name: "admin", Hash: Hash("admin-secret"), Scopes: []string{"payments:*"}},
+		Key{Name: "old", Hash: Hash("old-secret"), Scopes: []string{ScopeAll}, ExpiresAt: &past},
+	)
+	require.NoError(t, err)
+
+	router := gin.New()
+	group := router.Group("/", Middleware(registry, nil, logger))
+	group.POST("/payment", RequireScope("payments:write", logger), func(c *gin.Context) {
+		c.String(http.StatusOK, Name(c))
+	})
+	group.POST("/refund", RequireScope("payments:refund", logger), func(c *gin.Context) {
+		c.String(http.StatusOK, Name(c))
+	})
+
+	request := func(path, rawKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if rawKey != "" {
+			req.Header.Set(Header, rawKey)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("/payment", "booking-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "booking", w.Body.String())
+
+	w = request("/refund", "booking-secret")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "payments:refund")
+
+	w = request("/refund", "admin-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request("/payment", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "API key is required")
+
+	w = request("/payment", "wrong")
+	assert.Contains(t, w.Body.String(), "Invalid API key")
+
+	w = request("/payment", "old-secret")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "API key has expired")
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	router := gin.New()
+	router.POST("/refund", Middleware(nil, nil, logger), RequireScope("payments:refund", logger), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/refund", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCertificateMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	registry, err := NewRegistry(
+		Key{Name: "booking", ClientIdentities: []string{"spiffe://skyfox/booking-app"}, Scopes: []string{"payments:write"}},
+		Key{Name: "legacy", Hash: Hash("legacy-key"), Scopes: []string{"payments:write"}},
+	)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/payment", Middleware(registry, nil, logger), func(c *gin.Context) {
+		c.String(http.StatusOK, Name(c))
+	})
+
+	request := func(commonName string, verified bool, rawKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payment", nil)
+		spiffe, _ := url.Parse("spiffe://skyfox/" + commonName)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}, URIs: []*url.URL{spiffe}}
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		if rawKey != "" {
+			req.Header.Set(Header, rawKey)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("booking-app", true, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "booking", w.Body.String())
+
+	w = request("reporting", true, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Client certificate is not authorized")
+
+	// An explicit API key wins over the certificate.
+	w = request("reporting", true, "legacy-key")
+	assert.Equal(t, "legacy", w.Body.String())
+
+	// Unverified certificates are ignored.
+	w = request("booking-app", false, "")
+	assert.Contains(t, w.Body.String(), "API key is required")
+}
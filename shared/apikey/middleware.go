@@ -0,0 +1,146 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/mtls"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/sirupsen/logrus"
+)
+
+// Header carries the API key on requests.
+const Header = "x-api-key"
+
+const contextKey = "api_key"
+
+// FromContext returns the key that authenticated the request, if any.
+func FromContext(c *gin.Context) (Key, bool) {
+	value, ok := c.Get(contextKey)
+	if !ok {
+		return Key{}, false
+	}
+	key, ok := value.(Key)
+	return key, ok
+}
+
+// Name returns the name of the key that authenticated the request, or ""
+// when authentication is disabled.
+func Name(c *gin.Context) string {
+	key, _ := FromContext(c)
+	return key.Name
+}
+
+// Middleware authenticates requests against registry by, in order of
+// preference, a request signature when verifier is not nil, a verified
+// client certificate when no x-api-key is sent, or the x-api-key header. A
+// nil registry disables authentication.
+func Middleware(registry *Registry, verifier *Verifier, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if registry == nil {
+			logger.Warn("API key authentication is disabled")
+			c.Next()
+			return
+		}
+
+		requestLogger := logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"client_ip": c.ClientIP(),
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+		})
+
+		signatureRequired := verifier != nil && verifier.Required()
+		cert := mtls.PeerCertificate(c.Request)
+
+		var key Key
+		var err error
+		switch {
+		case verifier != nil && Signed(c.Request):
+			requestLogger = requestLogger.WithField("auth_method", "signature")
+			key, err = verifier.Verify(c.Request)
+		case cert != nil && (c.GetHeader(Header) == "" || signatureRequired):
+			// A certificate cannot be replayed, so it satisfies a signature
+			// requirement too.
+			requestLogger = requestLogger.WithFields(logrus.Fields{
+				"auth_method":     "client_cert",
+				"cert_subject":    cert.Subject.String(),
+				"cert_identities": mtls.Identities(cert),
+			})
+			key, err = registry.AuthenticateCertificate(mtls.Identities(cert))
+		case signatureRequired:
+			requestLogger.Warn("Unsigned request rejected")
+			forbid(c, "Request signature is required")
+			return
+		default:
+			rawKey := c.GetHeader(Header)
+			if rawKey == "" {
+				requestLogger.Warn("Missing API key in request")
+				forbid(c, "API key is required")
+				return
+			}
+			requestLogger = requestLogger.WithField("auth_method", "api_key")
+			key, err = registry.Authenticate(rawKey)
+		}
+
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrExpired):
+			requestLogger.WithField("key_name", key.Name).Warn("Expired API key provided")
+			forbid(c, "API key has expired")
+			return
+		case errors.Is(err, ErrStaleRequest):
+			requestLogger.Warn("Stale request signature")
+			forbid(c, "Request timestamp is outside the allowed window")
+			return
+		case errors.Is(err, ErrReplayedNonce):
+			requestLogger.WithField("key_name", key.Name).Warn("Replayed request nonce")
+			forbid(c, "Request nonce has already been used")
+			return
+		case errors.Is(err, ErrUnknownCertificate):
+			requestLogger.Warn("Client certificate not mapped to an API key")
+			forbid(c, "Client certificate is not authorized")
+			return
+		case errors.Is(err, ErrBodyTooLarge):
+			requestLogger.WithField("key_name", key.Name).Warn("Signed request body too large")
+			problem.Abort(c, problem.New(problem.TypePayloadTooLarge, http.StatusRequestEntityTooLarge, "Request body is too large"))
+			return
+		case errors.Is(err, ErrInvalidSignature):
+			requestLogger.WithError(err).Warn("Invalid request signature")
+			forbid(c, "Invalid request signature")
+			return
+		default:
+			requestLogger.Warn("Invalid API key provided")
+			forbid(c, "Invalid API key")
+			return
+		}
+
+		c.Set(contextKey, key)
+		c.Next()
+	}
+}
+
+func forbid(c *gin.Context, message string) {
+	problem.Abort(c, problem.New(problem.TypeForbidden, http.StatusForbidden, message))
+}
+
+// RequireScope rejects requests whose key does not grant scope. Requests are
+// let through when authentication is disabled.
+func RequireScope(scope string, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := FromContext(c)
+		if !ok || key.HasScope(scope) {
+			c.Next()
+			return
+		}
+
+		logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"client_ip": c.ClientIP(),
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"key_name":  key.Name,
+			"scope":     scope,
+		}).Warn("API key lacks required scope")
+		forbid(c, "API key does not have the "+scope+" scope")
+	}
+}
@@ -0,0 +1,139 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/signing"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrStaleRequest     = errors.New("request timestamp is outside the allowed window")
+	ErrReplayedNonce    = errors.New("request nonce has already been used")
+	ErrBodyTooLarge     = errors.New("request body is too large to verify")
+)
+
+const (
+	minNonceLength = 16
+	maxNonceLength = 128
+)
+
+type SignatureConfig struct {
+	// MaxSkew is how far a request timestamp may be from the server clock.
+	// Nonces are remembered for as long as their timestamp is acceptable.
+	MaxSkew time.Duration
+	// Required rejects requests that only send x-api-key.
+	Required bool
+	// MaxBodyBytes caps the body read to check a signature. The whole body
+	// is read before the signature can be checked, so without a cap anyone
+	// could make the server buffer an unbounded body.
+	MaxBodyBytes int64
+}
+
+func DefaultSignatureConfig() SignatureConfig {
+	return SignatureConfig{MaxSkew: 5 * time.Minute, MaxBodyBytes: 1 << 20}
+}
+
+// Signed reports whether r carries a request signature.
+func Signed(r *http.Request) bool {
+	return r.Header.Get(signing.HeaderSignature) != ""
+}
+
+// Verifier checks signed requests against the secrets in a registry and
+// rejects stale timestamps and reused nonces. Nonces are kept in memory, so
+// every instance behind a load balancer keeps its own set.
+type Verifier struct {
+	registry *Registry
+	cfg      SignatureConfig
+	now      func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewVerifier(registry *Registry, cfg SignatureConfig) *Verifier {
+	return &Verifier{
+		registry: registry,
+		cfg:      cfg,
+		now:      time.Now,
+		nonces:   make(map[string]time.Time),
+	}
+}
+
+func (v *Verifier) Required() bool {
+	return v.cfg.Required
+}
+
+// Verify authenticates a signed request. The body is read and replaced, so
+// handlers can still bind it; a body over MaxBodyBytes is ErrBodyTooLarge.
+func (v *Verifier) Verify(r *http.Request) (Key, error) {
+	name := r.Header.Get(signing.HeaderKey)
+	nonce := r.Header.Get(signing.HeaderNonce)
+	signature := r.Header.Get(signing.HeaderSignature)
+	timestamp, err := strconv.ParseInt(r.Header.Get(signing.HeaderTimestamp), 10, 64)
+	if name == "" || signature == "" || err != nil {
+		return Key{}, ErrInvalidSignature
+	}
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return Key{}, ErrInvalidSignature
+	}
+
+	now := v.now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-v.cfg.MaxSkew)) || signedAt.After(now.Add(v.cfg.MaxSkew)) {
+		return Key{}, ErrStaleRequest
+	}
+
+	if r.Body != nil && r.Body != http.NoBody && v.cfg.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, v.cfg.MaxBodyBytes)
+	}
+	body, err := signing.ReadBody(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return Key{}, ErrBodyTooLarge
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	stringToSign := signing.StringToSign(r.Method, signing.Path(r), timestamp, nonce, signing.BodyHash(body))
+
+	// The nonce is only recorded once the signature checks out, so a
+	// forged request cannot burn a legitimate client's nonce.
+	key, err := v.registry.VerifySignature(name, stringToSign, signature)
+	if err != nil {
+		return key, err
+	}
+	if !v.useNonce(name, nonce, signedAt.Add(v.cfg.MaxSkew), now) {
+		return key, ErrReplayedNonce
+	}
+	return key, nil
+}
+
+// useNonce records nonce for name until expiresAt and reports whether it
+// was unused.
+func (v *Verifier) useNonce(name, nonce string, expiresAt, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastSweep) >= v.cfg.MaxSkew {
+		for id, expiry := range v.nonces {
+			if now.After(expiry) {
+				delete(v.nonces, id)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	id := name + "\n" + nonce
+	if expiry, ok := v.nonces[id]; ok && !now.After(expiry) {
+		return false
+	}
+	v.nonces[id] = expiresAt
+	return true
+}
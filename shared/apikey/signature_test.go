@@ -0,0 +1,192 @@
+package apikey
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/signing"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	bookingSecret = "booking-signing-secret-0123456789"
+	rotatedSecret = "booking-signing-secret-rotated-01"
+)
+
+func signedRequest(t *testing.T, secret, body string, signedAt time.Time, nonce string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/payment", strings.NewReader(body))
+	signer := signing.NewSigner("booking", secret)
+	signer.Now = func() time.Time { return signedAt }
+	signer.Nonce = func() (string, error) { return nonce, nil }
+	require.NoError(t, signer.Sign(req))
+	return req
+}
+
+func newTestVerifier(t *testing.T, now time.Time, cfg SignatureConfig) *Verifier {
+	t.Helper()
+	registry, err := NewRegistry(
+		Key{Name: "booking", Secret: bookingSecret, Scopes: []string{"payments:write"}},
+		Key{Name: "booking", Secret: rotatedSecret, Scopes: []string{"payments:write"}},
+		Key{Name: "legacy", Hash: Hash("legacy-key"), Scopes: []string{"payments:write"}},
+	)
+	require.NoError(t, err)
+	verifier := NewVerifier(registry, cfg)
+	verifier.now = func() time.Time { return now }
+	return verifier
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	verifier := newTestVerifier(t, now, DefaultSignatureConfig())
+
+	req := signedRequest(t, bookingSecret, `{"amount":"10.00"}`, now, "nonce-aaaaaaaaaaaa")
+	key, err := verifier.Verify(req)
+	require.NoError(t, err)
+	assert.Equal(t, "booking", key.Name)
+
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"amount":"10.00"}`, string(body))
+
+	// Both secrets under a name are accepted while it is rotated.
+	_, err = verifier.Verify(signedRequest(t, rotatedSecret, "{}", now, "nonce-bbbbbbbbbbbb"))
+	assert.NoError(t, err)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	verifier := newTestVerifier(t, now, DefaultSignatureConfig())
+
+	tampered := signedRequest(t, bookingSecret, `{"amount":"10.00"}`, now, "nonce-cccccccccccc")
+	tampered.Body = io.NopCloser(strings.NewReader(`{"amount":"9999.00"}`))
+	_, err := verifier.Verify(tampered)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	wrongPath := signedRequest(t, bookingSecret, "{}", now, "nonce-dddddddddddd")
+	wrongPath.URL.Path = "/payment/txn-1/refund"
+	_, err = verifier.Verify(wrongPath)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = verifier.Verify(signedRequest(t, "some-other-secret-0123456789abcdef", "{}", now, "nonce-eeeeeeeeeeee"))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = verifier.Verify(signedRequest(t, bookingSecret, "{}", now, "short"))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = verifier.Verify(signedRequest(t, bookingSecret, "{}", now.Add(-6*time.Minute), "nonce-ffffffffffff"))
+	assert.ErrorIs(t, err, ErrStaleRequest)
+
+	_, err = verifier.Verify(signedRequest(t, bookingSecret, "{}", now.Add(6*time.Minute), "nonce-gggggggggggg"))
+	assert.ErrorIs(t, err, ErrStaleRequest)
+}
+
+func TestVerifyCapsBody(t *testing.T) {
+	now := time.Now()
+	cfg := DefaultSignatureConfig()
+	cfg.MaxBodyBytes = 16
+	verifier := newTestVerifier(t, now, cfg)
+
+	_, err := verifier.Verify(signedRequest(t, bookingSecret, `{"amount":"10"}`, now, "nonce-mmmmmmmmmmmm"))
+	assert.NoError(t, err)
+
+	_, err = verifier.Verify(signedRequest(t, bookingSecret, `{"amount":"10.00"}`, now, "nonce-nnnnnnnnnnnn"))
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestVerifyReplay(t *testing.T) {
+	now := time.Now()
+	verifier := newTestVerifier(t, now, DefaultSignatureConfig())
+
+	_, err := verifier.Verify(signedRequest(t, bookingSecret, "{}", now, "nonce-hhhhhhhhhhhh"))
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(signedRequest(t, bookingSecret, "{}", now, "nonce-hhhhhhhhhhhh"))
+	assert.ErrorIs(t, err, ErrReplayedNonce)
+
+	// A forged request does not use up the nonce.
+	_, err = verifier.Verify(signedRequest(t, "forged-secret-0123456789abcdefgh", "{}", now, "nonce-iiiiiiiiiiii"))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = verifier.Verify(signedRequest(t, bookingSecret, "{}", now, "nonce-iiiiiiiiiiii"))
+	assert.NoError(t, err)
+
+	// Once the timestamp is outside the window the nonce can be forgotten,
+	// since the request would be rejected as stale anyway.
+	verifier.useNonce("booking", "nonce-jjjjjjjjjjjj", now.Add(16*time.Minute), now.Add(11*time.Minute))
+	assert.NotContains(t, verifier.nonces, "booking\nnonce-hhhhhhhhhhhh")
+}
+
+func TestSigningSecretValidation(t *testing.T) {
+	_, err := NewRegistry(Key{Name: "booking", Secret: "too-short", Scopes: []string{ScopeAll}})
+	assert.Error(t, err)
+
+	_, err = NewRegistry(Key{Name: "booking", Scopes: []string{ScopeAll}})
+	assert.Error(t, err)
+
+	registry, err := NewRegistry(Key{Name: "booking", Secret: bookingSecret, Scopes: []string{ScopeAll}})
+	require.NoError(t, err)
+	_, err = registry.Authenticate(bookingSecret)
+	assert.ErrorIs(t, err, ErrInvalidKey, "a signing secret is not an x-api-key")
+}
+
+func TestSignatureMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	now := time.Now()
+	handler := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, Name(c)+" "+string(body))
+	}
+
+	optional := newTestVerifier(t, now, DefaultSignatureConfig())
+	router := gin.New()
+	router.POST("/payment", Middleware(optional.registry, optional, logger), handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedRequest(t, bookingSecret, "{}", now, "nonce-kkkkkkkkkkkk"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "booking {}", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, signedRequest(t, bookingSecret, "{}", now, "nonce-kkkkkkkkkkkk"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Request nonce has already been used")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, signedRequest(t, bookingSecret, "{}", now.Add(-time.Hour), "nonce-llllllllllll"))
+	assert.Contains(t, w.Body.String(), "Request timestamp is outside the allowed window")
+
+	large := signedRequest(t, bookingSecret, strings.Repeat("x", 2<<20), now, "nonce-oooooooooooo")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, large)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+	keyOnly := httptest.NewRequest(http.MethodPost, "/payment", nil)
+	keyOnly.Header.Set(Header, "legacy-key")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, keyOnly)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cfg := DefaultSignatureConfig()
+	cfg.Required = true
+	required := newTestVerifier(t, now, cfg)
+	router = gin.New()
+	router.POST("/payment", Middleware(required.registry, required, logger), handler)
+
+	keyOnly.Body = http.NoBody
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, keyOnly)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Request signature is required")
+}
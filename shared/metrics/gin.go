@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// UnmatchedRoute labels requests that matched no route, so scans of random
+// paths do not create a series each.
+const UnmatchedRoute = "unmatched"
+
+// GinMiddleware counts requests and observes their latency by method, route
+// template and status code.
+func GinMiddleware(registerer prometheus.Registerer) gin.HandlerFunc {
+	factory := promauto.With(registerer)
+	requests := factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	duration := factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = UnmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		duration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	names := make([]string, 0, len(families))
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	assert.Contains(t, names, "go_goroutines")
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := NewRegistry()
+
+	router := gin.New()
+	router.Use(GinMiddleware(registry))
+	router.GET("/payment/:transaction_id", func(c *gin.Context) {
+		c.Status(http.StatusNotFound)
+	})
+	router.GET("/metrics", GinHandler(registry))
+
+	for _, path := range []string{"/payment/txn-1", "/payment/txn-2", "/wp-admin"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+
+	body := w.Body.String()
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/payment/:transaction_id",status="404"} 2`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/payment/:transaction_id",status="404"} 2`)
+	// The scrape itself is counted once it has been served
+	assert.Equal(t, 3, testutil.CollectAndCount(registry, "http_requests_total"))
+}
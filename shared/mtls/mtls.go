@@ -0,0 +1,224 @@
+// Package mtls terminates TLS for the HTTP servers, optionally requiring
+// client certificates signed by a trusted CA. Certificates and the CA bundle
+// are re-read when their files change, so they can be rotated without a
+// restart.
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Client certificate policies.
+const (
+	// ClientAuthRequire rejects handshakes without a verified client
+	// certificate.
+	ClientAuthRequire = "require"
+	// ClientAuthOptional verifies a client certificate if one is sent, so
+	// health checks and API key clients can still connect.
+	ClientAuthOptional = "optional"
+)
+
+var (
+	ErrMissingKeyPair = errors.New("TLS needs both a certificate and a key file")
+	ErrNoClientCAs    = errors.New("client CA bundle contains no certificates")
+)
+
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   string
+	MinVersion   uint16
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ClientAuth: ClientAuthRequire,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// ParseVersion accepts "1.2" or "1.3".
+func ParseVersion(value string) (uint16, error) {
+	switch value {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", value)
+	}
+}
+
+// Reloader holds the current certificate and client CA pool.
+type Reloader struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewReloader loads the files named by cfg.
+func NewReloader(cfg Config) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, ErrMissingKeyPair
+	}
+	if cfg.ClientAuth != ClientAuthRequire && cfg.ClientAuth != ClientAuthOptional {
+		return nil, fmt.Errorf("unsupported client auth %q, use %s or %s", cfg.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+	}
+
+	r := &Reloader{cfg: cfg}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// Reload re-reads the certificate, key and CA bundle if any of them changed
+// since the last load. On error the previous ones stay in use.
+func (r *Reloader) Reload() (bool, error) {
+	modTimes := make(map[string]time.Time)
+	changed := false
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		modTimes[path] = info.ModTime()
+
+		r.mu.RLock()
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			changed = true
+		}
+		r.mu.RUnlock()
+	}
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	var clientCA *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return false, ErrNoClientCAs
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = clientCA
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the files every interval until ctx is done. Failed reloads
+// are logged and the previous certificate stays in use.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if err != nil {
+				logger.WithError(err).Error("Failed to reload TLS certificates")
+				continue
+			}
+			if changed {
+				logger.Info("Reloaded TLS certificates")
+			}
+		}
+	}
+}
+
+// MutualTLS reports whether client certificates are verified.
+func (r *Reloader) MutualTLS() bool {
+	return r.cfg.ClientCAFile != ""
+}
+
+// TLSConfig returns a server configuration that picks up the current
+// certificate and CA pool on every handshake. The configuration returned for
+// a handshake replaces this one entirely, so it offers HTTP/2 and HTTP/1.1
+// itself rather than relying on http.Server to add them.
+func (r *Reloader) TLSConfig() *tls.Config {
+	nextProtos := []string{"h2", "http/1.1"}
+	return &tls.Config{
+		MinVersion: r.cfg.MinVersion,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   r.cfg.MinVersion,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCA != nil {
+				cfg.ClientCAs = r.clientCA
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				if r.cfg.ClientAuth == ClientAuthOptional {
+					cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// PeerCertificate returns the client certificate of r if it was verified
+// against the client CA bundle.
+func PeerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// Identities lists the names a client certificate can be authorized by: its
+// common name and its DNS, URI and email subject alternative names.
+func Identities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.EmailAddresses...)
+	return identities
+}
@@ -0,0 +1,289 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func issue(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func newCA(t *testing.T, name string) *testCert {
+	return issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newServerCert(t *testing.T, ca *testCert, name string) *testCert {
+	return issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+func newClientCert(t *testing.T, ca *testCert, name string) *testCert {
+	return issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+func (c *testCert) keyPair(t *testing.T) tls.Certificate {
+	t.Helper()
+	pair, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	require.NoError(t, err)
+	return pair
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// writeFiles writes cert, key and CA bundle, bumping their modification time
+// so a reload notices the change on filesystems with coarse timestamps.
+func writeFiles(t *testing.T, cfg Config, server, clientCA *testCert, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(cfg.CertFile, server.pem, 0o600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, server.keyPEM(t), 0o600))
+	require.NoError(t, os.WriteFile(cfg.ClientCAFile, clientCA.pem, 0o600))
+	for _, path := range []string{cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile} {
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+}
+
+func testConfig(t *testing.T) Config {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.CertFile = filepath.Join(dir, "server.pem")
+	cfg.KeyFile = filepath.Join(dir, "server-key.pem")
+	cfg.ClientCAFile = filepath.Join(dir, "clients.pem")
+	return cfg
+}
+
+func startServer(t *testing.T, reloader *Reloader) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cert := PeerCertificate(r); cert != nil {
+			w.Write([]byte(cert.Subject.CommonName))
+		}
+	}))
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func client(serverCA *testCert, cert *tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	cfg := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCA := newCA(t, "server-ca")
+	clientCA := newCA(t, "client-ca")
+	cfg := testConfig(t)
+	writeFiles(t, cfg, newServerCert(t, serverCA, "payment-gateway"), clientCA, time.Now())
+
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	assert.True(t, reloader.MutualTLS())
+	server := startServer(t, reloader)
+
+	trusted := newClientCert(t, clientCA, "booking-app").keyPair(t)
+	resp, err := client(serverCA, &trusted).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = client(serverCA, nil).Get(server.URL)
+	assert.Error(t, err, "a client certificate is required")
+
+	untrusted := newClientCert(t, newCA(t, "other-ca"), "booking-app").keyPair(t)
+	_, err = client(serverCA, &untrusted).Get(server.URL)
+	assert.Error(t, err, "the client certificate must chain to the client CA")
+}
+
+func TestOptionalClientAuth(t *testing.T) {
+	serverCA := newCA(t, "server-ca")
+	cfg := testConfig(t)
+	cfg.ClientAuth = ClientAuthOptional
+	writeFiles(t, cfg, newServerCert(t, serverCA, "payment-gateway"), newCA(t, "client-ca"), time.Now())
+
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	server := startServer(t, reloader)
+
+	resp, err := client(serverCA, nil).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNegotiatesHTTP2(t *testing.T) {
+	serverCA := newCA(t, "server-ca")
+	clientCA := newCA(t, "client-ca")
+	cfg := testConfig(t)
+	writeFiles(t, cfg, newServerCert(t, serverCA, "payment-gateway"), clientCA, time.Now())
+
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	trusted := newClientCert(t, clientCA, "booking-app").keyPair(t)
+	http2Client := client(serverCA, &trusted)
+	http2Client.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+	resp, err := http2Client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	// Clients without HTTP/2 still get HTTP/1.1
+	resp, err = client(serverCA, &trusted).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, resp.ProtoMajor)
+}
+
+func TestReload(t *testing.T) {
+	serverCA := newCA(t, "server-ca")
+	oldClientCA := newCA(t, "old-client-ca")
+	cfg := testConfig(t)
+	start := time.Now().Add(-time.Minute)
+	writeFiles(t, cfg, newServerCert(t, serverCA, "first"), oldClientCA, start)
+
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	server := startServer(t, reloader)
+
+	changed, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	newClientCA := newCA(t, "new-client-ca")
+	writeFiles(t, cfg, newServerCert(t, serverCA, "second"), newClientCA, start.Add(time.Second))
+	changed, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	rotated := newClientCert(t, newClientCA, "booking-app").keyPair(t)
+	resp, err := client(serverCA, &rotated).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	old := newClientCert(t, oldClientCA, "booking-app").keyPair(t)
+	_, err = client(serverCA, &old).Get(server.URL)
+	assert.Error(t, err, "the old client CA is no longer trusted")
+
+	// A broken file keeps the previous certificate in use.
+	require.NoError(t, os.WriteFile(cfg.CertFile, []byte("not a certificate"), 0o600))
+	require.NoError(t, os.Chtimes(cfg.CertFile, start.Add(2*time.Second), start.Add(2*time.Second)))
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+
+	resp, err = client(serverCA, &rotated).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestNewReloaderValidation(t *testing.T) {
+	_, err := NewReloader(Config{CertFile: "server.pem"})
+	assert.ErrorIs(t, err, ErrMissingKeyPair)
+
+	cfg := testConfig(t)
+	cfg.ClientAuth = "sometimes"
+	_, err = NewReloader(cfg)
+	assert.Error(t, err)
+
+	cfg = testConfig(t)
+	serverCA := newCA(t, "server-ca")
+	writeFiles(t, cfg, newServerCert(t, serverCA, "payment-gateway"), serverCA, time.Now())
+	require.NoError(t, os.WriteFile(cfg.ClientCAFile, []byte("empty"), 0o600))
+	_, err = NewReloader(cfg)
+	assert.ErrorIs(t, err, ErrNoClientCAs)
+}
+
+func TestParseVersion(t *testing.T) {
+	version, err := ParseVersion("1.3")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = ParseVersion("1.1")
+	assert.Error(t, err)
+}
+
+func TestIdentities(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://skyfox/booking-app")
+	require.NoError(t, err)
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "booking-app"},
+		DNSNames: []string{"booking.internal"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	assert.Equal(t, []string{"booking-app", "booking.internal", "spiffe://skyfox/booking-app"}, Identities(cert))
+}
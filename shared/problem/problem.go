@@ -0,0 +1,160 @@
+// Package problem writes error responses as RFC 7807 problem details
+// (application/problem+json), so every error from the service has the same
+// shape: type, title, status, detail, instance, request_id and, for
+// rejected input, field-level errors.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+)
+
+// ContentType is the media type of every problem response.
+const ContentType = "application/problem+json"
+
+// Type identifies the kind of problem. Types are relative URI references
+// that stay the same for every occurrence of a problem, so clients can
+// switch on them instead of parsing detail.
+type Type string
+
+const (
+	TypeInvalidRequest       Type = "/problems/invalid-request"
+	TypeValidation           Type = "/problems/validation-error"
+	TypeForbidden            Type = "/problems/forbidden"
+	TypeNotFound             Type = "/problems/not-found"
+	TypeConflict             Type = "/problems/conflict"
+	TypeIdempotencyKeyReused Type = "/problems/idempotency-key-reused"
+	TypeAuthorizationExpired Type = "/problems/authorization-expired"
+	TypePayloadTooLarge      Type = "/problems/payload-too-large"
+	TypeRateLimited          Type = "/problems/rate-limited"
+	TypeServiceUnavailable   Type = "/problems/service-unavailable"
+	TypeInternal             Type = "/problems/internal-error"
+)
+
+var titles = map[Type]string{
+	TypeInvalidRequest:       "Invalid request",
+	TypeValidation:           "Validation failed",
+	TypeForbidden:            "Forbidden",
+	TypeNotFound:             "Resource not found",
+	TypeConflict:             "Conflict with the current state",
+	TypeIdempotencyKeyReused: "Idempotency key reused",
+	TypeAuthorizationExpired: "Authorization expired",
+	TypePayloadTooLarge:      "Payload too large",
+	TypeRateLimited:          "Rate limit exceeded",
+	TypeServiceUnavailable:   "Service unavailable",
+	TypeInternal:             "Internal server error",
+}
+
+// ValidationError describes a rejected request field.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is a single RFC 7807 problem. Extensions holds any additional
+// members, such as the transaction a problem refers to; they are written
+// alongside the standard ones and cannot replace them.
+type Problem struct {
+	Type       Type              `json:"type"`
+	Title      string            `json:"title"`
+	Status     int               `json:"status"`
+	Detail     string            `json:"detail,omitempty"`
+	Instance   string            `json:"instance,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Errors     []ValidationError `json:"errors,omitempty"`
+	Extensions map[string]any    `json:"-"`
+}
+
+// New returns a problem of type typ with the given status. The title is the
+// fixed title of typ.
+func New(typ Type, status int, detail string) *Problem {
+	title, ok := titles[typ]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	return &Problem{Type: typ, Title: title, Status: status, Detail: detail}
+}
+
+// Validation returns a 422 problem listing the rejected fields.
+func Validation(errors []ValidationError) *Problem {
+	p := New(TypeValidation, http.StatusUnprocessableEntity, "One or more fields are invalid")
+	p.Errors = errors
+	return p
+}
+
+// With adds the extension member key and returns p.
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]any)
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	// The alias drops this method so the standard members marshal normally
+	type standard Problem
+	body, err := json.Marshal((*standard)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+
+	var members map[string]any
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	for key, value := range p.Extensions {
+		if _, taken := members[key]; !taken {
+			members[key] = value
+		}
+	}
+	return json.Marshal(members)
+}
+
+// Respond writes p as the response to c, filling in the request path and
+// request ID.
+func Respond(c *gin.Context, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = tracing.RequestID(c)
+	}
+	// gin keeps a Content-Type that is already set when rendering JSON
+	c.Header("Content-Type", ContentType)
+	c.JSON(p.Status, p)
+}
+
+// Abort writes p like Respond and stops the remaining handlers, for use in
+// middleware.
+func Abort(c *gin.Context, p *Problem) {
+	c.Abort()
+	Respond(c, p)
+}
+
+// BadRequest responds with a 400 problem for a request that could not be
+// parsed.
+func BadRequest(c *gin.Context, detail string) {
+	Respond(c, New(TypeInvalidRequest, http.StatusBadRequest, detail))
+}
+
+// NotFound responds with a 404 problem.
+func NotFound(c *gin.Context, detail string) {
+	Respond(c, New(TypeNotFound, http.StatusNotFound, detail))
+}
+
+// Internal responds with a 500 problem. detail must not leak the cause.
+func Internal(c *gin.Context, detail string) {
+	Respond(c, New(TypeInternal, http.StatusInternalServerError, detail))
+}
+
+// Recovery replaces gin.Recovery, answering a panicking handler with a 500
+// problem. The panic itself is logged by gin.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, _ any) {
+		Abort(c, New(TypeInternal, http.StatusInternalServerError, "The request could not be completed"))
+	})
+}
@@ -0,0 +1,81 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, handler gin.HandlerFunc) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tracing.Middleware(), Recovery())
+	router.POST("/payment", handler)
+
+	req := httptest.NewRequest(http.MethodPost, "/payment", nil)
+	req.Header.Set(tracing.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w, body
+}
+
+func TestValidationProblem(t *testing.T) {
+	w, body := serve(t, func(c *gin.Context) {
+		Respond(c, Validation([]ValidationError{
+			{Field: "card_number", Message: "Card number failed Luhn check"},
+		}))
+	})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, map[string]any{
+		"type":       "/problems/validation-error",
+		"title":      "Validation failed",
+		"status":     float64(422),
+		"detail":     "One or more fields are invalid",
+		"instance":   "/payment",
+		"request_id": "req-1",
+		"errors": []any{
+			map[string]any{"field": "card_number", "message": "Card number failed Luhn check"},
+		},
+	}, body)
+}
+
+func TestExtensionsCannotReplaceStandardMembers(t *testing.T) {
+	w, body := serve(t, func(c *gin.Context) {
+		Respond(c, New(TypeServiceUnavailable, http.StatusServiceUnavailable, "Payment gateway is busy").
+			With("transaction_id", "txn-1").
+			With("status", "FAILED"))
+	})
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "txn-1", body["transaction_id"])
+	assert.Equal(t, float64(503), body["status"])
+	assert.Equal(t, "Service unavailable", body["title"])
+	assert.NotContains(t, body, "errors")
+}
+
+func TestUnknownTypeUsesStatusText(t *testing.T) {
+	p := New("/problems/teapot", http.StatusTeapot, "")
+	assert.Equal(t, "I'm a teapot", p.Title)
+}
+
+func TestRecovery(t *testing.T) {
+	w, body := serve(t, func(c *gin.Context) {
+		panic("card vault exploded")
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "/problems/internal-error", body["type"])
+	assert.NotContains(t, w.Body.String(), "exploded")
+}
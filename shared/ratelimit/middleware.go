@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamsuteerth/skyfox-helper/tree/main/shared/problem"
+	"github.com/sirupsen/logrus"
+)
+
+// KeyFunc picks the bucket a request draws from.
+type KeyFunc func(c *gin.Context) string
+
+// DefaultKey limits each API key separately, identified by a hash of the
+// x-api-key header, and falls back to the client IP for requests without
+// one.
+func DefaultKey(c *gin.Context) string {
+	if apiKey := c.GetHeader("x-api-key"); apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// ClientIP limits each client IP, whether or not the request carries an API
+// key. Use it for limits that run ahead of authentication, so requests that
+// fail it still count.
+func ClientIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// Middleware rejects requests over limiter's limit with 429 and a
+// Retry-After header. Every response carries X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset (seconds until the bucket is
+// full). A nil key uses DefaultKey.
+func Middleware(limiter *Limiter, key KeyFunc, logger *logrus.Logger) gin.HandlerFunc {
+	if key == nil {
+		key = DefaultKey
+	}
+	return func(c *gin.Context) {
+		if !limiter.Limit().Enabled() {
+			c.Next()
+			return
+		}
+
+		result := limiter.Allow(key(c))
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+		if !result.Allowed {
+			retryAfter := seconds(result.RetryAfter)
+			logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+				"client_ip":   c.ClientIP(),
+				"method":      c.Request.Method,
+				"path":        c.Request.URL.Path,
+				"retry_after": retryAfter,
+			}).Warn("Rate limit exceeded")
+
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			problem.Abort(c, problem.New(problem.TypeRateLimited, http.StatusTooManyRequests,
+				"Rate limit exceeded, retry after "+strconv.Itoa(retryAfter)+" seconds"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// seconds rounds up so clients never retry before a token is available.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
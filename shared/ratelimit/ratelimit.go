@@ -0,0 +1,157 @@
+// Package ratelimit implements per-client token buckets for gin routes.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled completely are
+// dropped, so idle clients do not accumulate in memory.
+const sweepInterval = time.Minute
+
+// Limit allows Burst requests at once, refilled at Rate requests per second.
+// The zero Limit disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d per %s", l.Burst, time.Duration(float64(l.Burst)/l.Rate*float64(time.Second)))
+}
+
+// ParseLimit reads limits such as "100/m": up to 100 requests at once,
+// refilled evenly over a minute. Units are s, m and h. "off" or "0" disables
+// limiting.
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return Limit{}, nil
+	}
+
+	count, unit, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 100/m", value)
+	}
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests < 1 {
+		return Limit{}, fmt.Errorf("rate limit %q must start with a positive number of requests", value)
+	}
+
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("rate limit %q must use s, m or h as the unit", value)
+	}
+
+	return Limit{Rate: float64(requests) / period.Seconds(), Burst: requests}, nil
+}
+
+// Result describes a client's bucket after a request.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero when Allowed is true.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per key. It is safe for concurrent use.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     Limit
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from key's bucket if one is available.
+func (l *Limiter) Allow(key string) Result {
+	if !l.limit.Enabled() {
+		return Result{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	result := Result{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.duration(1 - b.tokens)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = l.duration(float64(l.limit.Burst) - b.tokens)
+	return result
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	return math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.limit.Rate)
+}
+
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "10/s", want: Limit{Rate: 10, Burst: 10}},
+		{value: "120/m", want: Limit{Rate: 2, Burst: 120}},
+		{value: "3600/h", want: Limit{Rate: 1, Burst: 3600}},
+		{value: "off", want: Limit{}},
+		{value: "0", want: Limit{}},
+		{value: "100", wantErr: true},
+		{value: "-1/m", wantErr: true},
+		{value: "10/d", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			limit, err := ParseLimit(tc.value)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, limit)
+		})
+	}
+}
+
+func newTestLimiter(limit Limit) (*Limiter, *time.Time) {
+	now := time.Now()
+	limiter := New(limit)
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	limiter, now := newTestLimiter(Limit{Rate: 1, Burst: 3})
+
+	for i := 2; i >= 0; i-- {
+		result := limiter.Allow("a")
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	result := limiter.Allow("a")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	assert.True(t, limiter.Allow("b").Allowed, "buckets are per key")
+
+	*now = now.Add(500 * time.Millisecond)
+	result = limiter.Allow("a")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.Allow("a").Allowed)
+}
+
+func TestLimiterSweepsFullBuckets(t *testing.T) {
+	limiter, now := newTestLimiter(Limit{Rate: 1, Burst: 1})
+
+	limiter.Allow("a")
+	*now = now.Add(2 * sweepInterval)
+	limiter.Allow("b")
+
+	assert.NotContains(t, limiter.buckets, "a")
+	assert.Contains(t, limiter.buckets, "b")
+}
+
+func TestDisabledLimiter(t *testing.T) {
+	limiter := New(Limit{})
+	for i := 0; i < 100; i++ {
+		assert.True(t, limiter.Allow("a").Allowed)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	router := gin.New()
+	router.Use(Middleware(New(Limit{Rate: 1, Burst: 2}), nil, logger))
+	router.GET("/movies", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(apiKey, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/movies", nil)
+		req.RemoteAddr = ip + ":1234"
+		if apiKey != "" {
+			req.Header.Set("x-api-key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("key-a", "10.0.0.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Reset"))
+
+	// The same key is limited across client IPs.
+	assert.Equal(t, http.StatusOK, request("key-a", "10.0.0.2").Code)
+	w = request("key-a", "10.0.0.3")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"type":"/problems/rate-limited"`)
+
+	assert.Equal(t, http.StatusOK, request("key-b", "10.0.0.3").Code)
+
+	// Requests without a key are limited by client IP.
+	assert.Equal(t, http.StatusOK, request("", "10.0.0.9").Code)
+	assert.Equal(t, http.StatusOK, request("", "10.0.0.9").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("", "10.0.0.9").Code)
+	assert.Equal(t, http.StatusOK, request("", "10.0.0.10").Code)
+}
+
+func TestClientIPCountsRejectedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Stands in for authentication rejecting every key
+	router := gin.New()
+	router.Use(Middleware(New(Limit{Rate: 1, Burst: 2}), ClientIP, logger), func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+	router.GET("/movies", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(apiKey, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/movies", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("x-api-key", apiKey)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Guessing a different key each time does not reset the bucket
+	assert.Equal(t, http.StatusUnauthorized, request("guess-1", "10.0.0.1"))
+	assert.Equal(t, http.StatusUnauthorized, request("guess-2", "10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, request("guess-3", "10.0.0.1"))
+	assert.Equal(t, http.StatusUnauthorized, request("guess-4", "10.0.0.2"))
+}
@@ -0,0 +1,74 @@
+package signing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func fixedSigner() *Signer {
+	signer := NewSigner("booking-app", testSecret)
+	signer.Now = func() time.Time { return time.Unix(1700000000, 0) }
+	signer.Nonce = func() (string, error) { return "nonce-0123456789", nil }
+	return signer
+}
+
+func TestSign(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/payment?mode=async", strings.NewReader(`{"amount":"10.00"}`))
+	require.NoError(t, fixedSigner().Sign(req))
+
+	assert.Equal(t, "booking-app", req.Header.Get(HeaderKey))
+	assert.Equal(t, "1700000000", req.Header.Get(HeaderTimestamp))
+	assert.Equal(t, "nonce-0123456789", req.Header.Get(HeaderNonce))
+
+	stringToSign := "POST\n/payment?mode=async\n1700000000\nnonce-0123456789\n" + BodyHash([]byte(`{"amount":"10.00"}`))
+	assert.Equal(t, Compute([]byte(testSecret), stringToSign), req.Header.Get(HeaderSignature))
+
+	// The body is still there for the transport to send.
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"amount":"10.00"}`, string(body))
+}
+
+func TestSignWithoutBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/payment/txn-1", nil)
+	require.NoError(t, fixedSigner().Sign(req))
+
+	stringToSign := StringToSign(http.MethodGet, "/payment/txn-1", 1700000000, "nonce-0123456789", BodyHash(nil))
+	assert.Equal(t, Compute([]byte(testSecret), stringToSign), req.Header.Get(HeaderSignature))
+}
+
+func TestSignEmptySecret(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.ErrorIs(t, NewSigner("booking-app", "").Sign(req), ErrEmptySecret)
+}
+
+func TestTransport(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewSigner("booking-app", testSecret).Transport(nil)}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/payment", strings.NewReader("{}"))
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "booking-app", got.Get(HeaderKey))
+	assert.NotEmpty(t, got.Get(HeaderSignature))
+	assert.Len(t, got.Get(HeaderNonce), 32)
+	assert.Empty(t, req.Header.Get(HeaderSignature), "caller's request must not be modified")
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LogHook adds trace_id and span_id to entries whose context carries a
+// span, so logs made with logger.WithContext(ctx) can be joined to traces.
+type LogHook struct{}
+
+func NewLogHook() *LogHook {
+	return &LogHook{}
+}
+
+func (h *LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *LogHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	spanContext := trace.SpanContextFromContext(entry.Context)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	entry.Data["trace_id"] = spanContext.TraceID().String()
+	entry.Data["span_id"] = spanContext.SpanID().String()
+	return nil
+}
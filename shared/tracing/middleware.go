@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the caller's correlation ID. It is echoed on every
+// response and generated when a request does not send a usable one.
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	requestIDContextKey = "request_id"
+	maxRequestIDLength  = 128
+	unmatchedRoute      = "unmatched"
+)
+
+// RequestID returns the correlation ID of the request, as set by Middleware.
+// Outside the middleware it falls back to a fresh ID.
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(requestIDContextKey); id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// validRequestID accepts IDs that are safe to log and echo: up to 128
+// letters, digits and "-._:".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '.', r == '_', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware continues the caller's trace from the traceparent header, or
+// starts a new one, and wraps the request in a server span named after its
+// route. The span context is set on c.Request so handlers and log entries
+// made with WithContext join the trace. The trace context is returned in
+// the traceparent response header.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Set(requestIDContextKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+				attribute.String("request_id", requestID),
+			),
+		)
+		defer span.End()
+
+		propagator.Inject(ctx, propagation.HeaderCarrier(c.Writer.Header()))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package tracing sets up OpenTelemetry tracing with W3C trace context
+// propagation, correlates requests through X-Request-ID and stamps trace
+// IDs on log entries.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted in Config.Exporter. They match the values of the
+// standard OTEL_TRACES_EXPORTER variable.
+const (
+	ExporterOTLP    = "otlp"
+	ExporterConsole = "console"
+	ExporterNone    = "none"
+)
+
+const instrumentationName = "github.com/iamsuteerth/skyfox-helper"
+
+type Config struct {
+	ServiceName    string
+	ServiceVersion string
+	Exporter       string
+}
+
+// Setup installs a global tracer provider and the W3C trace context and
+// baggage propagators. Spans are always created, so logs carry trace IDs and
+// incoming trace context is continued, but they are only sent anywhere when
+// an exporter is configured. The OTLP exporter sends over HTTP and reads the
+// standard OTEL_EXPORTER_OTLP_* variables; sampling follows
+// OTEL_TRACES_SAMPLER. The returned function flushes pending spans.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", cfg.ServiceName),
+			attribute.String("service.version", cfg.ServiceVersion),
+		),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES win over the defaults
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	switch cfg.Exporter {
+	case "", ExporterNone:
+	case ExporterOTLP:
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case ExporterConsole, "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithSyncer(exporter))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// Start begins a span as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RecordError marks span as failed with err.
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Detach returns a context carrying the span of parent but none of its
+// cancellation, for work that outlives the request it belongs to.
+func Detach(ctx, parent context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, trace.SpanFromContext(parent))
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanID  = "00f067aa0ba902b7"
+)
+
+func setupRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+	return recorder
+}
+
+func newRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/payment/:transaction_id", handler)
+	return router
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recorder := setupRecorder(t)
+
+	var requestID string
+	router := newRouter(func(c *gin.Context) {
+		requestID = RequestID(c)
+		_, span := Start(c.Request.Context(), "payment.lookup")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/payment/txn-1", nil)
+	req.Header.Set("traceparent", "00-"+parentTraceID+"-"+parentSpanID+"-01")
+	req.Header.Set(RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "req-123", requestID)
+	assert.Equal(t, "req-123", w.Header().Get(RequestIDHeader))
+	assert.True(t, strings.HasPrefix(w.Header().Get("traceparent"), "00-"+parentTraceID+"-"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "GET /payment/:transaction_id", server.Name())
+	assert.Equal(t, parentTraceID, server.SpanContext().TraceID().String())
+	assert.Equal(t, parentSpanID, server.Parent().SpanID().String())
+	assert.Equal(t, "payment.lookup", child.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID())
+}
+
+func TestMiddlewareStartsTrace(t *testing.T) {
+	recorder := setupRecorder(t)
+	router := newRouter(func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payment/txn-1", nil))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.False(t, spans[0].Parent().IsValid())
+	assert.Equal(t, "Error", spans[0].Status().Code.String())
+	assert.NotEmpty(t, w.Header().Get(RequestIDHeader))
+}
+
+func TestMiddlewareReplacesInvalidRequestID(t *testing.T) {
+	setupRecorder(t)
+	router := newRouter(func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, id := range []string{"", "has spaces", "line\nbreak", strings.Repeat("a", 129)} {
+		req := httptest.NewRequest(http.MethodGet, "/payment/txn-1", nil)
+		req.Header.Set(RequestIDHeader, id)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		echoed := w.Header().Get(RequestIDHeader)
+		assert.NotEqual(t, id, echoed)
+		assert.True(t, validRequestID(echoed), echoed)
+	}
+}
+
+func TestLogHook(t *testing.T) {
+	setupRecorder(t)
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(&out)
+	logger.AddHook(NewLogHook())
+
+	ctx, span := Start(context.Background(), "payment.handle")
+	logger.WithContext(ctx).Info("inside span")
+	span.End()
+	logger.Info("outside span")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var inside, outside map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &inside))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &outside))
+
+	assert.Equal(t, span.SpanContext().TraceID().String(), inside["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), inside["span_id"])
+	assert.NotContains(t, outside, "trace_id")
+}
+
+func TestDetach(t *testing.T) {
+	setupRecorder(t)
+
+	parent, span := Start(context.Background(), "payment.handle")
+	defer span.End()
+	cancelled, cancel := context.WithCancel(parent)
+	cancel()
+
+	ctx := Detach(context.Background(), cancelled)
+	assert.NoError(t, ctx.Err())
+	_, child := Start(ctx, "processor.ProcessPayment")
+	defer child.End()
+	assert.Equal(t, span.SpanContext().TraceID(), child.SpanContext().TraceID())
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{ServiceName: "test", Exporter: "zipkin"})
+	assert.Error(t, err)
+}